@@ -0,0 +1,54 @@
+package configfile
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/c2h5oh/datasize"
+)
+
+// ByteSize is a size in bytes. In the configuration file it can be a number of
+// bytes or a human readable string such as 100mb or 50MiB.
+// Units are powers of 1024 so mb and mib are the same.
+type ByteSize uint64
+
+// ParseByteSize reads a size from a string such as 512kb, 50MiB or 1048576.
+func ParseByteSize(value string) (ByteSize, error) {
+	normalised := strings.ToLower(strings.TrimSpace(value))
+	if normalised == "" {
+		return 0, fmt.Errorf("size can not be empty")
+	}
+	// datasize does not know about the binary prefixes but uses them anyway.
+	if strings.HasSuffix(normalised, "ib") {
+		normalised = strings.TrimSuffix(normalised, "ib") + "b"
+	}
+	var size datasize.ByteSize
+	if err := size.UnmarshalText([]byte(normalised)); err != nil {
+		return 0, fmt.Errorf("%s is not a valid size", value)
+	}
+	return ByteSize(size.Bytes()), nil
+}
+
+// UnmarshalYAML allows sizes to be written as numbers or strings.
+func (b *ByteSize) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value string
+	if err := unmarshal(&value); err != nil {
+		return err
+	}
+	size, err := ParseByteSize(value)
+	if err != nil {
+		return err
+	}
+	*b = size
+	return nil
+}
+
+// MarshalYAML writes the size in the largest unit that fits it exactly.
+func (b ByteSize) MarshalYAML() (interface{}, error) {
+	return datasize.ByteSize(b).String(), nil
+}
+
+// Bytes returns the size as a number of bytes.
+func (b ByteSize) Bytes() uint64 {
+	return uint64(b)
+}
@@ -3,6 +3,8 @@ package configfile
 import (
 	"fmt"
 	"io/ioutil"
+	"path/filepath"
+	"strings"
 
 	"github.com/morfien101/launch/configfile/templating"
 	"gopkg.in/yaml.v2"
@@ -38,6 +40,27 @@ func New(filePath string) (*Config, error) {
 	newConfig.setDefaultProcessManager()
 	newConfig.setDefaultProcessTimeout()
 	newConfig.setDefaultSecretTimeout()
+	if err := newConfig.setDefaultFileLoggers(); err != nil {
+		return nil, err
+	}
+	if err := newConfig.setDefaultFilters(); err != nil {
+		return nil, err
+	}
+	if err := newConfig.setDefaultFields(); err != nil {
+		return nil, err
+	}
+	if err := newConfig.setDefaultParsers(); err != nil {
+		return nil, err
+	}
+	if err := newConfig.setLogFiles(); err != nil {
+		return nil, err
+	}
+	if err := newConfig.setStdin(); err != nil {
+		return nil, err
+	}
+	if err := newConfig.validateInitOptions(); err != nil {
+		return nil, err
+	}
 
 	return newConfig, nil
 }
@@ -129,6 +152,226 @@ func (cf *Config) setDefaultProcessTimeout() {
 	f(cf.Processes.MainProcesses)
 }
 
+// setDefaultFileLoggers fills in the file logger settings for each logger that
+// uses the file logger engine. Empty settings are taken from the default logger
+// config and then from the built in defaults.
+// The settings are then validated and loggers that share a file must agree on
+// how it is rotated.
+//
+// NOTE: setDefaultProcessLogger and setDefaultProcessManager should be called first
+func (cf *Config) setDefaultFileLoggers() error {
+	defaults := cf.DefaultLoggerConfig.Config.Logfile
+	configs := make([]*LoggingConfig, 0)
+	if cf.ProcessManager.LoggerConfig.Engine == fileLoggerEngine {
+		configs = append(configs, &cf.ProcessManager.LoggerConfig)
+	}
+	for _, procList := range [][]*Process{cf.Processes.InitProcesses, cf.Processes.MainProcesses} {
+		for _, proc := range procList {
+			if proc.LoggerConfig.Engine == fileLoggerEngine {
+				configs = append(configs, &proc.LoggerConfig)
+			}
+		}
+	}
+
+	// owners tracks the first logger to use each file.
+	owners := make(map[string]*LoggingConfig)
+	for _, conf := range configs {
+		conf.Logfile = conf.Logfile.withDefaults(defaults).withDefaults(defaultFileLogger)
+		if err := conf.Logfile.validate(); err != nil {
+			return fmt.Errorf("%s has an invalid file logger configuration. Error: %s", loggerName(conf), err)
+		}
+
+		owner, ok := owners[conf.Logfile.Filename]
+		if !ok {
+			owners[conf.Logfile.Filename] = conf
+			continue
+		}
+		if differences := owner.Logfile.rotationDifferences(conf.Logfile); len(differences) > 0 {
+			return fmt.Errorf(
+				"%s and %s both write to %s but have different settings for %s",
+				loggerName(owner),
+				loggerName(conf),
+				conf.Logfile.Filename,
+				strings.Join(differences, ", "),
+			)
+		}
+	}
+	return nil
+}
+
+// setDefaultFilters gives processes without filters the filters from the
+// default logger config and then validates them.
+func (cf *Config) setDefaultFilters() error {
+	defaults := cf.DefaultLoggerConfig.Config.Filters
+	if err := defaults.validate(); err != nil {
+		return fmt.Errorf("default_logger_config has invalid filters. Error: %s", err)
+	}
+	for _, procList := range [][]*Process{cf.Processes.InitProcesses, cf.Processes.MainProcesses} {
+		for _, proc := range procList {
+			if proc.LoggerConfig.Filters.Empty() {
+				proc.LoggerConfig.Filters = defaults
+				continue
+			}
+			if err := proc.LoggerConfig.Filters.validate(); err != nil {
+				return fmt.Errorf("%s has invalid filters. Error: %s", loggerName(&proc.LoggerConfig), err)
+			}
+		}
+	}
+	return nil
+}
+
+// setDefaultFields merges the fields from the default logger config into the
+// fields of each logger. Fields set on a logger win. The names are then validated.
+//
+// NOTE: setDefaultProcessManager should be called first
+func (cf *Config) setDefaultFields() error {
+	defaults := cf.DefaultLoggerConfig.Config.Fields
+	configs := []*LoggingConfig{&cf.ProcessManager.LoggerConfig}
+	for _, procList := range [][]*Process{cf.Processes.InitProcesses, cf.Processes.MainProcesses} {
+		for _, proc := range procList {
+			configs = append(configs, &proc.LoggerConfig)
+		}
+	}
+
+	for _, conf := range configs {
+		conf.Fields = mergeFields(defaults, conf.Fields)
+		for name := range conf.Fields {
+			if !validFieldName.MatchString(name) {
+				return fmt.Errorf(
+					"%s has an invalid field name %s. Names must start with a letter and only contain letters, numbers and _",
+					loggerName(conf),
+					name,
+				)
+			}
+		}
+	}
+	return nil
+}
+
+// setDefaultParsers gives the parser in the default logger config to each
+// process that does not have one. The parsers are then validated.
+func (cf *Config) setDefaultParsers() error {
+	defaults := cf.DefaultLoggerConfig.Config
+	if err := validateParser(defaults); err != nil {
+		return fmt.Errorf("default_logger_config has an invalid parser. Error: %s", err)
+	}
+	for _, procList := range [][]*Process{cf.Processes.InitProcesses, cf.Processes.MainProcesses} {
+		for _, proc := range procList {
+			if !proc.LoggerConfig.ParseJSON && proc.LoggerConfig.Parser == "" && proc.LoggerConfig.ParserPattern == "" {
+				proc.LoggerConfig.ParseJSON = defaults.ParseJSON
+				proc.LoggerConfig.Parser = defaults.Parser
+				proc.LoggerConfig.ParserPattern = defaults.ParserPattern
+				continue
+			}
+			if err := validateParser(proc.LoggerConfig); err != nil {
+				return fmt.Errorf("%s has an invalid parser. Error: %s", loggerName(&proc.LoggerConfig), err)
+			}
+		}
+	}
+	return nil
+}
+
+// setLogFiles makes the log file patterns of each process relative to its
+// working directory and checks that they are valid globs.
+func (cf *Config) setLogFiles() error {
+	for _, procList := range [][]*Process{cf.Processes.InitProcesses, cf.Processes.MainProcesses} {
+		for _, proc := range procList {
+			for i, pattern := range proc.LogFiles {
+				if pattern == "" {
+					return fmt.Errorf("%s has an empty log_files pattern", proc.Name)
+				}
+				if _, err := filepath.Match(pattern, ""); err != nil {
+					return fmt.Errorf("%s has an invalid log_files pattern %s. Error: %s", proc.Name, pattern, err)
+				}
+				if !filepath.IsAbs(pattern) && proc.WorkingDirectory != "" {
+					proc.LogFiles[i] = filepath.Join(proc.WorkingDirectory, pattern)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// setStdin checks the stdin of each process and makes stdin files relative to
+// the working directory of init and main processes. Only one main process can
+// inherit the stdin of Launch as they all run at the same time.
+func (cf *Config) setStdin() error {
+	for _, proc := range cf.Processes.SecretProcess {
+		if err := proc.Stdin.validate(); err != nil {
+			return fmt.Errorf("%s has an invalid stdin. Error: %s", proc.Name, err)
+		}
+	}
+	inheriting := ""
+	for _, procList := range [][]*Process{cf.Processes.InitProcesses, cf.Processes.MainProcesses} {
+		for _, proc := range procList {
+			if err := proc.Stdin.validate(); err != nil {
+				return fmt.Errorf("%s has an invalid stdin. Error: %s", proc.Name, err)
+			}
+			if proc.TTY && proc.Stdin != (Stdin{}) {
+				return fmt.Errorf("%s can not use stdin and tty as the process reads from the terminal", proc.Name)
+			}
+			if proc.Stdin.File != "" && !filepath.IsAbs(proc.Stdin.File) && proc.WorkingDirectory != "" {
+				proc.Stdin.File = filepath.Join(proc.WorkingDirectory, proc.Stdin.File)
+			}
+		}
+	}
+	for _, proc := range cf.Processes.MainProcesses {
+		if !proc.Stdin.Inherit {
+			continue
+		}
+		if inheriting != "" {
+			return fmt.Errorf("%s and %s both inherit stdin. Only one main process can", inheriting, proc.Name)
+		}
+		inheriting = proc.Name
+	}
+	// Init processes in a parallel group run at the same time.
+	groupInheriting := map[string]string{}
+	for _, proc := range cf.Processes.InitProcesses {
+		if !proc.Stdin.Inherit || proc.ParallelGroup == "" {
+			continue
+		}
+		if other, ok := groupInheriting[proc.ParallelGroup]; ok {
+			return fmt.Errorf("%s and %s both inherit stdin. Only one process in parallel_group %s can", other, proc.Name, proc.ParallelGroup)
+		}
+		groupInheriting[proc.ParallelGroup] = proc.Name
+	}
+	return nil
+}
+
+// validateInitOptions checks the timeout, retry and parallel settings. They
+// can only be used by init processes. The processes in a parallel group must
+// be next to each other so that the order of the groups is clear.
+func (cf *Config) validateInitOptions() error {
+	for _, proc := range cf.Processes.MainProcesses {
+		if proc.TimeoutSeconds != 0 || proc.Retries != 0 || proc.RetryBackoffSeconds != 0 || proc.AllowFailure || proc.ParallelGroup != "" {
+			return fmt.Errorf("%s is a main process. timeout_seconds, retries, retry_backoff_seconds, allow_failure and parallel_group can only be used by init processes", proc.Name)
+		}
+	}
+	finished := map[string]bool{}
+	lastGroup := ""
+	for _, proc := range cf.Processes.InitProcesses {
+		if proc.TimeoutSeconds < 0 || proc.Retries < 0 || proc.RetryBackoffSeconds < 0 {
+			return fmt.Errorf("%s can not have a negative timeout_seconds, retries or retry_backoff_seconds", proc.Name)
+		}
+		if proc.ParallelGroup != lastGroup {
+			if finished[proc.ParallelGroup] {
+				return fmt.Errorf("%s is in parallel_group %s but is not next to the other processes in the group", proc.Name, proc.ParallelGroup)
+			}
+			finished[lastGroup] = lastGroup != ""
+			lastGroup = proc.ParallelGroup
+		}
+	}
+	return nil
+}
+
+// loggerName is used to name a logging config in errors.
+func loggerName(conf *LoggingConfig) string {
+	if conf.ProcessName == "" {
+		return "process_manager"
+	}
+	return conf.ProcessName
+}
+
 func (cf Config) String() string {
 	output, _ := yaml.Marshal(cf)
 	return string(output)
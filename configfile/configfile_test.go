@@ -246,3 +246,622 @@ func TestConfigString(t *testing.T) {
 	}
 	t.Logf("config as string:\n%s", cf)
 }
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		value string
+		want  ByteSize
+	}{
+		{value: "1048576", want: 1048576},
+		{value: "100 mb", want: 100 * 1024 * 1024},
+		{value: "50MiB", want: 50 * 1024 * 1024},
+		{value: "512kb", want: 512 * 1024},
+		{value: "2GiB", want: 2 * 1024 * 1024 * 1024},
+	}
+	for _, test := range tests {
+		got, err := ParseByteSize(test.value)
+		if err != nil || got != test.want {
+			t.Logf("%s was not parsed as expected. Want: %d, Got: %d, Error: %v", test.value, test.want, got, err)
+			t.Fail()
+		}
+	}
+	for _, value := range []string{"", "lots", "-5mb", "1.5gb"} {
+		if _, err := ParseByteSize(value); err == nil {
+			t.Logf("%q should not be a valid size", value)
+			t.Fail()
+		}
+	}
+}
+
+func TestFileLoggerDefaults(t *testing.T) {
+	testYaml := `default_logger_config:
+  logging_config:
+    engine: logfile
+    file_logger:
+      filepath: /var/log/launch/./app.log
+      size_limit: 50MiB
+      compression: gzip
+processes:
+  main_processes:
+  - name: web
+    command: /bin/web
+  - name: worker
+    command: /bin/worker
+    logging_config:
+      file_logger:
+        filepath: /var/log/launch/worker.log
+        size_limit: 1048576
+        historical_files_limit: 7`
+
+	testingfile := filet.TmpFile(t, "", testYaml)
+	conf, err := New(testingfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	web := conf.Processes.MainProcesses[0].LoggerConfig.Logfile
+	want := FileLogger{
+		Filename:        "/var/log/launch/app.log",
+		SizeLimit:       50 * 1024 * 1024,
+		HistoricalFiles: defaultFileLogger.HistoricalFiles,
+		Compression:     "gzip",
+	}
+	if web != want {
+		t.Logf("Default file logger settings were not applied.\nWant: %+v\nGot:  %+v", want, web)
+		t.Fail()
+	}
+
+	worker := conf.Processes.MainProcesses[1].LoggerConfig.Logfile
+	if worker.SizeLimit != 1048576 || worker.HistoricalFiles != 7 || worker.Compression != "gzip" {
+		t.Logf("Process file logger settings should win over the defaults. Got: %+v", worker)
+		t.Fail()
+	}
+}
+
+func TestFileLoggerKeepsNoHistoricalFiles(t *testing.T) {
+	testYaml := `default_logger_config:
+  logging_config:
+    engine: logfile
+    file_logger:
+      filepath: /var/log/launch/app.log
+      historical_files_limit: 0
+processes:
+  main_processes:
+  - name: web
+    command: /bin/web
+  - name: worker
+    command: /bin/worker
+    logging_config:
+      file_logger:
+        filepath: /var/log/launch/worker.log
+        historical_files_limit: 0
+  - name: cron
+    command: /bin/cron
+    logging_config:
+      file_logger:
+        filepath: /var/log/launch/cron.log`
+
+	testingfile := filet.TmpFile(t, "", testYaml)
+	conf, err := New(testingfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, proc := range conf.Processes.MainProcesses {
+		if proc.LoggerConfig.Logfile.HistoricalFiles != 0 {
+			t.Logf("historical_files_limit of 0 should be kept for %s. Got: %d", proc.Name, proc.LoggerConfig.Logfile.HistoricalFiles)
+			t.Fail()
+		}
+	}
+
+	testingfile = filet.TmpFile(t, "", `processes:
+  main_processes:
+  - name: web
+    command: /bin/web
+    logging_config:
+      engine: logfile
+      file_logger:
+        filepath: /var/log/launch/web.log`)
+	conf, err = New(testingfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := conf.Processes.MainProcesses[0].LoggerConfig.Logfile.HistoricalFiles; got != defaultFileLogger.HistoricalFiles {
+		t.Logf("historical_files_limit should default to %d when it is not set. Got: %d", defaultFileLogger.HistoricalFiles, got)
+		t.Fail()
+	}
+}
+
+func TestFileLoggerValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+	}{
+		{
+			name: "missing filepath",
+			yaml: `processes:
+  main_processes:
+  - name: web
+    command: /bin/web
+    logging_config:
+      engine: logfile`,
+		},
+		{
+			name: "negative history",
+			yaml: `processes:
+  main_processes:
+  - name: web
+    command: /bin/web
+    logging_config:
+      engine: logfile
+      file_logger:
+        filepath: /tmp/web.log
+        historical_files_limit: -1`,
+		},
+		{
+			name: "invalid size",
+			yaml: `processes:
+  main_processes:
+  - name: web
+    command: /bin/web
+    logging_config:
+      engine: logfile
+      file_logger:
+        filepath: /tmp/web.log
+        size_limit: huge`,
+		},
+		{
+			name: "conflicting rotation",
+			yaml: `default_logger_config:
+  logging_config:
+    engine: logfile
+    file_logger:
+      filepath: /tmp/shared.log
+processes:
+  main_processes:
+  - name: web
+    command: /bin/web
+  - name: worker
+    command: /bin/worker
+    logging_config:
+      file_logger:
+        filepath: /tmp/./shared.log
+        size_limit: 1mb`,
+		},
+	}
+
+	for _, test := range tests {
+		testingfile := filet.TmpFile(t, "", test.yaml)
+		if _, err := New(testingfile.Name()); err == nil {
+			t.Logf("%s should be rejected", test.name)
+			t.Fail()
+		} else {
+			t.Logf("%s: %s", test.name, err)
+		}
+	}
+}
+
+func TestSharedFileLogger(t *testing.T) {
+	testYaml := `default_logger_config:
+  logging_config:
+    engine: logfile
+    file_logger:
+      filepath: /tmp/shared.log
+processes:
+  main_processes:
+  - name: web
+    command: /bin/web
+  - name: worker
+    command: /bin/worker
+    logging_config:
+      file_logger:
+        format: json`
+
+	testingfile := filet.TmpFile(t, "", testYaml)
+	if _, err := New(testingfile.Name()); err != nil {
+		t.Logf("Processes sharing a file with the same rotation settings should be allowed. Error: %s", err)
+		t.Fail()
+	}
+}
+
+func TestFilterValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+	}{
+		{
+			name: "invalid pattern",
+			yaml: `processes:
+  main_processes:
+  - name: web
+    command: /bin/web
+    logging_config:
+      filters:
+        drop:
+        - "health("`,
+		},
+		{
+			name: "sample without every or limit",
+			yaml: `processes:
+  main_processes:
+  - name: web
+    command: /bin/web
+    logging_config:
+      filters:
+        sample:
+        - pattern: GET`,
+		},
+		{
+			name: "invalid per",
+			yaml: `default_logger_config:
+  logging_config:
+    filters:
+      sample:
+      - pattern: GET
+        limit: 10
+        per: often
+processes:
+  main_processes:
+  - name: web
+    command: /bin/web`,
+		},
+	}
+
+	for _, test := range tests {
+		testingfile := filet.TmpFile(t, "", test.yaml)
+		if _, err := New(testingfile.Name()); err == nil {
+			t.Logf("%s should be rejected", test.name)
+			t.Fail()
+		} else {
+			t.Logf("%s: %s", test.name, err)
+		}
+	}
+}
+
+func TestDefaultFilters(t *testing.T) {
+	testYaml := `default_logger_config:
+  logging_config:
+    filters:
+      drop:
+      - health
+processes:
+  main_processes:
+  - name: web
+    command: /bin/web
+  - name: worker
+    command: /bin/worker
+    logging_config:
+      filters:
+        redact_secrets: false`
+
+	testingfile := filet.TmpFile(t, "", testYaml)
+	config, err := New(testingfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	web := config.Processes.MainProcesses[0].LoggerConfig.Filters
+	if len(web.Drop) != 1 || web.Drop[0] != "health" {
+		t.Logf("web should use the default filters. Got: %+v", web)
+		t.Fail()
+	}
+	worker := config.Processes.MainProcesses[1].LoggerConfig.Filters
+	if len(worker.Drop) != 0 || worker.SecretsRedacted() {
+		t.Logf("worker should keep its own filters. Got: %+v", worker)
+		t.Fail()
+	}
+}
+
+func TestDefaultFields(t *testing.T) {
+	testYaml := `default_logger_config:
+  logging_config:
+    fields:
+      env: prod
+      version: "1.0"
+processes:
+  main_processes:
+  - name: web
+    command: /bin/web
+    logging_config:
+      fields:
+        version: "2.0"
+  - name: worker
+    command: /bin/worker`
+
+	testingfile := filet.TmpFile(t, "", testYaml)
+	config, err := New(testingfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]map[string]string{
+		"web":             config.Processes.MainProcesses[0].LoggerConfig.Fields,
+		"worker":          config.Processes.MainProcesses[1].LoggerConfig.Fields,
+		"process_manager": config.ProcessManager.LoggerConfig.Fields,
+	}
+	want := map[string]string{"web": "2.0", "worker": "1.0", "process_manager": "1.0"}
+	for name, fields := range tests {
+		if fields["env"] != "prod" || fields["version"] != want[name] {
+			t.Logf("%s fields are not as expected. Got: %v", name, fields)
+			t.Fail()
+		}
+	}
+}
+
+func TestInvalidFieldNames(t *testing.T) {
+	for _, name := range []string{"_private", "bad-name", "1st", "a_name_that_is_much_too_long_to_use"} {
+		testYaml := `processes:
+  main_processes:
+  - name: web
+    command: /bin/web
+    logging_config:
+      fields:
+        ` + name + `: value`
+		testingfile := filet.TmpFile(t, "", testYaml)
+		if _, err := New(testingfile.Name()); err == nil {
+			t.Logf("Field name %s should be rejected", name)
+			t.Fail()
+		}
+	}
+}
+
+func TestDefaultParseJSON(t *testing.T) {
+	testYaml := `default_logger_config:
+  logging_config:
+    parse_json: true
+processes:
+  init_processes:
+  - name: setup
+    command: /bin/setup
+  main_processes:
+  - name: web
+    command: /bin/web`
+
+	testingfile := filet.TmpFile(t, "", testYaml)
+	config, err := New(testingfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !config.Processes.InitProcesses[0].LoggerConfig.ParseJSON || !config.Processes.MainProcesses[0].LoggerConfig.ParseJSON {
+		t.Logf("parse_json should be turned on for every process")
+		t.Fail()
+	}
+}
+
+func TestDefaultParser(t *testing.T) {
+	testYaml := `default_logger_config:
+  logging_config:
+    parser: logfmt
+processes:
+  main_processes:
+  - name: web
+    command: /bin/web
+    logging_config:
+      parser: regex
+      parser_pattern: '(?P<status>\d{3})'
+  - name: worker
+    command: /bin/worker`
+
+	testingfile := filet.TmpFile(t, "", testYaml)
+	config, err := New(testingfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Processes.MainProcesses[0].LoggerConfig.Parser != "regex" || config.Processes.MainProcesses[1].LoggerConfig.Parser != "logfmt" {
+		t.Logf("Parsers are not as expected. Got: %s and %s", config.Processes.MainProcesses[0].LoggerConfig.Parser, config.Processes.MainProcesses[1].LoggerConfig.Parser)
+		t.Fail()
+	}
+}
+
+func TestInvalidParser(t *testing.T) {
+	for _, parser := range []string{
+		"parser: xml",
+		"parser: regex",
+		"parser: regex\n      parser_pattern: '\\d+'",
+		"parser: regex\n      parser_pattern: '(?P<broken'",
+		"parser: logfmt\n      parser_pattern: '(?P<status>\\d+)'",
+		"parser: logfmt\n      parse_json: true",
+	} {
+		testYaml := `processes:
+  main_processes:
+  - name: web
+    command: /bin/web
+    logging_config:
+      ` + parser
+
+		testingfile := filet.TmpFile(t, "", testYaml)
+		if _, err := New(testingfile.Name()); err == nil {
+			t.Logf("Parser should be rejected: %s", parser)
+			t.Fail()
+		}
+	}
+}
+
+func TestLogFiles(t *testing.T) {
+	testYaml := `processes:
+  main_processes:
+  - name: web
+    command: /bin/web
+    working_dir: /app
+    log_files:
+    - logs/*.log
+    - /var/log/app/access.log`
+
+	testingfile := filet.TmpFile(t, "", testYaml)
+	config, err := New(testingfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := config.Processes.MainProcesses[0].LogFiles
+	if len(got) != 2 || got[0] != "/app/logs/*.log" || got[1] != "/var/log/app/access.log" {
+		t.Logf("log_files are not as expected. Got: %v", got)
+		t.Fail()
+	}
+
+	testYaml = `processes:
+  main_processes:
+  - name: web
+    command: /bin/web
+    log_files:
+    - '/var/log/[broken'`
+	testingfile = filet.TmpFile(t, "", testYaml)
+	if _, err := New(testingfile.Name()); err == nil {
+		t.Logf("Invalid log_files patterns should be rejected")
+		t.Fail()
+	}
+}
+
+func TestStdin(t *testing.T) {
+	testYaml := `processes:
+  secret_processes:
+  - name: vault
+    command: /bin/vault
+    stdin:
+      value: token
+  init_processes:
+  - name: setup
+    command: /bin/setup
+    working_dir: /app
+    stdin:
+      file: setup.conf
+  main_processes:
+  - name: web
+    command: /bin/web
+    stdin:
+      inherit: true
+  - name: worker
+    command: /bin/worker`
+
+	testingfile := filet.TmpFile(t, "", testYaml)
+	config, err := New(testingfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Processes.SecretProcess[0].Stdin.Value != "token" ||
+		config.Processes.InitProcesses[0].Stdin.File != "/app/setup.conf" ||
+		!config.Processes.MainProcesses[0].Stdin.Inherit ||
+		config.Processes.MainProcesses[1].Stdin != (Stdin{}) {
+		t.Logf("stdin is not as expected. Got: %+v", config.Processes)
+		t.Fail()
+	}
+}
+
+func TestInvalidStdin(t *testing.T) {
+	tests := []string{
+		`main_processes:
+  - name: web
+    command: /bin/web
+    stdin:
+      inherit: true
+      value: text`,
+		`main_processes:
+  - name: web
+    command: /bin/web
+    stdin:
+      inherit: true
+  - name: worker
+    command: /bin/worker
+    stdin:
+      inherit: true`,
+		`main_processes:
+  - name: web
+    command: /bin/web
+    tty: true
+    stdin:
+      value: text`,
+		`init_processes:
+  - name: download
+    command: /bin/download
+    parallel_group: setup
+    stdin:
+      inherit: true
+  - name: migrate
+    command: /bin/migrate
+    parallel_group: setup
+    stdin:
+      inherit: true
+  main_processes:
+  - name: web
+    command: /bin/web`,
+		`secret_processes:
+  - name: vault
+    command: /bin/vault
+    stdin:
+      file: /token
+      value: text
+  main_processes:
+  - name: web
+    command: /bin/web`,
+	}
+	for _, processes := range tests {
+		testingfile := filet.TmpFile(t, "", "processes:\n  "+processes)
+		if _, err := New(testingfile.Name()); err == nil {
+			t.Logf("stdin should be rejected:\n%s", processes)
+			t.Fail()
+		}
+	}
+}
+
+func TestInitOptions(t *testing.T) {
+	testYaml := `processes:
+  init_processes:
+  - name: download
+    command: /bin/download
+    timeout_seconds: 60
+    retries: 3
+    retry_backoff_seconds: 2
+    parallel_group: setup
+  - name: warm cache
+    command: /bin/warm
+    allow_failure: true
+    parallel_group: setup
+  - name: migrate
+    command: /bin/migrate
+  main_processes:
+  - name: web
+    command: /bin/web`
+
+	testingfile := filet.TmpFile(t, "", testYaml)
+	config, err := New(testingfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	download := config.Processes.InitProcesses[0]
+	if download.TimeoutSeconds != 60 || download.Retries != 3 || download.RetryBackoffSeconds != 2 ||
+		download.ParallelGroup != "setup" || !config.Processes.InitProcesses[1].AllowFailure {
+		t.Logf("Init options are not as expected. Got: %+v", config.Processes.InitProcesses)
+		t.Fail()
+	}
+}
+
+func TestInvalidInitOptions(t *testing.T) {
+	tests := []string{
+		`main_processes:
+  - name: web
+    command: /bin/web
+    retries: 2`,
+		`init_processes:
+  - name: setup
+    command: /bin/setup
+    timeout_seconds: -1
+  main_processes:
+  - name: web
+    command: /bin/web`,
+		`init_processes:
+  - name: download
+    command: /bin/download
+    parallel_group: setup
+  - name: migrate
+    command: /bin/migrate
+  - name: warm cache
+    command: /bin/warm
+    parallel_group: setup
+  main_processes:
+  - name: web
+    command: /bin/web`,
+	}
+	for _, processes := range tests {
+		testingfile := filet.TmpFile(t, "", "processes:\n  "+processes)
+		if _, err := New(testingfile.Name()); err == nil {
+			t.Logf("Init options should be rejected:\n%s", processes)
+			t.Fail()
+		}
+	}
+}
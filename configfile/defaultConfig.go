@@ -16,4 +16,12 @@ var (
 	}
 
 	defaultProcTimeout = 30
+
+	// fileLoggerEngine is the engine name of the file logger.
+	fileLoggerEngine = "logfile"
+
+	defaultFileLogger = FileLogger{
+		SizeLimit:       100 * 1024 * 1024,
+		HistoricalFiles: 3,
+	}
 )
@@ -0,0 +1,24 @@
+package configfile
+
+import "regexp"
+
+// validFieldName is a name that every logging engine can use without changing
+// it. Eg. syslog structured data names are limited to 32 characters and journald
+// fields that start with _ are reserved.
+var validFieldName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]{0,31}$`)
+
+// mergeFields returns the default fields overridden by the fields of a logger.
+// nil is returned if there are no fields.
+func mergeFields(defaults, fields map[string]string) map[string]string {
+	if len(defaults) == 0 && len(fields) == 0 {
+		return nil
+	}
+	merged := make(map[string]string, len(defaults)+len(fields))
+	for name, value := range defaults {
+		merged[name] = value
+	}
+	for name, value := range fields {
+		merged[name] = value
+	}
+	return merged
+}
@@ -0,0 +1,101 @@
+package configfile
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// Filters controls which lines of a process are logged and what is removed
+// from them before they reach the logging engine.
+type Filters struct {
+	// Keep only logs lines that match at least one of the patterns.
+	Keep []string `yaml:"keep,omitempty"`
+	// Drop removes lines that match any of the patterns.
+	Drop []string `yaml:"drop,omitempty"`
+	// Sample reduces the number of lines that match a pattern.
+	Sample []SampleRule `yaml:"sample,omitempty"`
+	// Redact replaces the parts of lines that match a pattern.
+	Redact []RedactRule `yaml:"redact,omitempty"`
+	// MaskJSONKeys replaces the values of these keys in JSON lines.
+	MaskJSONKeys []string `yaml:"mask_json_keys,omitempty"`
+	// RedactSecrets replaces the values collected by the secret processes.
+	// It is on unless it is set to false.
+	RedactSecrets *bool `yaml:"redact_secrets,omitempty"`
+}
+
+// SampleRule keeps some of the lines that match the pattern. Every and Limit
+// can be used together.
+type SampleRule struct {
+	Pattern string `yaml:"pattern"`
+	// Every keeps 1 in every N matching lines.
+	Every int `yaml:"every,omitempty"`
+	// Limit keeps at most this many matching lines in each Per.
+	Limit int `yaml:"limit,omitempty"`
+	// Per is the length of the rate limit window, eg. 1m. The default is 1s.
+	Per string `yaml:"per,omitempty"`
+}
+
+// RedactRule replaces the parts of a line that match the pattern.
+type RedactRule struct {
+	Pattern string `yaml:"pattern"`
+	// Replacement can use $1 or ${name} to keep capture groups. The default
+	// is [REDACTED].
+	Replacement string `yaml:"replacement,omitempty"`
+}
+
+// Empty tells us if no filters have been configured.
+func (f Filters) Empty() bool {
+	return len(f.Keep) == 0 &&
+		len(f.Drop) == 0 &&
+		len(f.Sample) == 0 &&
+		len(f.Redact) == 0 &&
+		len(f.MaskJSONKeys) == 0 &&
+		f.RedactSecrets == nil
+}
+
+// SecretsRedacted tells us if the values collected by the secret processes
+// should be removed from the lines.
+func (f Filters) SecretsRedacted() bool {
+	return f.RedactSecrets == nil || *f.RedactSecrets
+}
+
+// validate checks that the patterns compile and the sample rules make sense.
+func (f Filters) validate() error {
+	patterns := append(append([]string{}, f.Keep...), f.Drop...)
+	for _, rule := range f.Redact {
+		patterns = append(patterns, rule.Pattern)
+	}
+	for _, rule := range f.Sample {
+		patterns = append(patterns, rule.Pattern)
+		if rule.Every < 0 || rule.Limit < 0 {
+			return fmt.Errorf("sample rule %s can not have a negative every or limit", rule.Pattern)
+		}
+		if rule.Every == 0 && rule.Limit == 0 {
+			return fmt.Errorf("sample rule %s needs every or limit", rule.Pattern)
+		}
+		if rule.Per != "" {
+			per, err := time.ParseDuration(rule.Per)
+			if err != nil {
+				return fmt.Errorf("sample rule %s has an invalid per. Error: %s", rule.Pattern, err)
+			}
+			if per <= 0 {
+				return fmt.Errorf("sample rule %s must have a per of more than 0", rule.Pattern)
+			}
+		}
+	}
+	for _, pattern := range patterns {
+		if pattern == "" {
+			return fmt.Errorf("filter patterns can not be empty")
+		}
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("failed to compile filter pattern %s. Error: %s", pattern, err)
+		}
+	}
+	for _, key := range f.MaskJSONKeys {
+		if key == "" {
+			return fmt.Errorf("mask_json_keys can not contain an empty key")
+		}
+	}
+	return nil
+}
@@ -1,5 +1,11 @@
 package configfile
 
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
 // LoggingConfig is a struct that will hold the values of the logging
 // configuration of the process or process manager
 type LoggingConfig struct {
@@ -7,6 +13,36 @@ type LoggingConfig struct {
 	ProcessName string     `yaml:"process_name,omitempty"`
 	Syslog      Syslog     `yaml:"syslog,omitempty"`
 	Logfile     FileLogger `yaml:"file_logger,omitempty"`
+	Console     Console    `yaml:"console,omitempty"`
+	HTTP        HTTP       `yaml:"http,omitempty"`
+	Fluentd     Fluentd    `yaml:"fluentd,omitempty"`
+	GELF        GELF       `yaml:"gelf,omitempty"`
+	Journald    Journald   `yaml:"journald,omitempty"`
+	Loki        Loki       `yaml:"loki,omitempty"`
+	Kafka       Kafka      `yaml:"kafka,omitempty"`
+	// LevelDetection is used by loggers that need to read a level from the message.
+	LevelDetection LevelDetection `yaml:"level_detection,omitempty"`
+	// Filters are applied to the lines of a process before they are logged.
+	Filters Filters `yaml:"filters,omitempty"`
+	// Fields are attached to each message and sent in the way that suits the engine.
+	Fields map[string]string `yaml:"fields,omitempty"`
+	// ParseJSON decodes JSON lines so that loggers can use their keys.
+	// It is the same as using the json parser.
+	ParseJSON bool `yaml:"parse_json,omitempty"`
+	// Parser decodes lines so that loggers can use their keys. json, logfmt or regex.
+	Parser string `yaml:"parser,omitempty"`
+	// ParserPattern is the regex used by the regex parser. Named groups become the keys.
+	ParserPattern string `yaml:"parser_pattern,omitempty"`
+}
+
+// LevelDetection configures how log levels are read from JSON log messages.
+type LevelDetection struct {
+	// JSONKeys is a list of dotted key paths to look for the level in. Eg. log.level
+	JSONKeys []string `yaml:"json_keys,omitempty"`
+	// NumericLevels selects how numeric levels are translated. pino, bunyan, zap or syslog.
+	NumericLevels string `yaml:"numeric_levels,omitempty"`
+	// Aliases maps extra level names onto the known level names.
+	Aliases map[string]string `yaml:"aliases,omitempty"`
 }
 
 // DefaultLoggerDetails will hold the default logger configuration
@@ -20,15 +56,293 @@ type Syslog struct {
 	Address                    string `yaml:"address"`
 	ConnectionType             string `yaml:"protocol,omitempty"`
 	CertificateBundlePath      string `yaml:"cert_bundle_path,omitempty"`
+	UseSystemRoots             bool   `yaml:"use_system_roots,omitempty"`
+	ClientCertificatePath      string `yaml:"client_cert_path,omitempty"`
+	ClientKeyPath              string `yaml:"client_key_path,omitempty"`
+	ServerName                 string `yaml:"server_name,omitempty"`
+	MinTLSVersion              string `yaml:"min_tls_version,omitempty"`
+	ReloadCertificates         bool   `yaml:"reload_certificates,omitempty"`
 	ExtractLogLevel            bool   `yaml:"extract_log_level,omitempty"`
+	LevelRegex                 string `yaml:"level_regex,omitempty"`
+	Facility                   string `yaml:"facility,omitempty"`
+	StdoutSeverity             string `yaml:"stdout_severity,omitempty"`
+	StderrSeverity             string `yaml:"stderr_severity,omitempty"`
 	OverrideHostname           string `yaml:"override_hostname,omitempty"`
 	AddContainerNameToTag      bool   `yaml:"append_container_name_to_tag,omitempty"`
 	AddContainerNameToHostname bool   `yaml:"append_container_name_to_hostname,omitempty"`
+	// Format selects the syslog message format. rfc3164 or rfc5424.
+	Format string `yaml:"format,omitempty"`
+	// Framing selects how messages are framed on stream connections.
+	// non_transparent or octet_counting.
+	Framing string `yaml:"framing,omitempty"`
+	// MessageID is the MSGID sent with rfc5424 messages.
+	MessageID string `yaml:"message_id,omitempty"`
+	// StructuredDataID is the SD-ID used for the launch structured data element.
+	StructuredDataID string `yaml:"structured_data_id,omitempty"`
+	// StructuredData holds static fields added to the rfc5424 structured data.
+	StructuredData map[string]string `yaml:"structured_data,omitempty"`
+}
+
+// Console is used to send configuration to the console logger
+type Console struct {
+	// Format is how lines are written. plain, prefixed or json.
+	Format string `yaml:"format,omitempty"`
+}
+
+// HTTP is used to send configuration to the HTTP logger
+type HTTP struct {
+	// URL is where batches of logs are posted to.
+	URL string `yaml:"url"`
+	// Format is how a batch is sent. ndjson or json_array.
+	Format string `yaml:"format,omitempty"`
+	// Gzip compresses the body of each request.
+	Gzip bool `yaml:"gzip,omitempty"`
+	// Headers are added to each request. Values can use the template functions.
+	Headers map[string]string `yaml:"headers,omitempty"`
+	// BatchSize is the number of messages that are sent in each request.
+	BatchSize int `yaml:"batch_size,omitempty"`
+	// BatchBytes is the largest a batch can be before it is sent.
+	BatchBytes ByteSize `yaml:"batch_bytes,omitempty"`
+	// FlushInterval is how long messages can wait to be sent, eg. 5s.
+	FlushInterval string `yaml:"flush_interval,omitempty"`
+	// Timeout is how long each request can take, eg. 10s.
+	Timeout string `yaml:"timeout,omitempty"`
+	// MaxRetries is how many times a failed request is tried again. It is a
+	// pointer so that 0 can turn retries off.
+	MaxRetries *int `yaml:"max_retries,omitempty"`
+	// RetryBackoff is how long to wait before the first retry, eg. 500ms.
+	// The wait is doubled for each retry.
+	RetryBackoff string `yaml:"retry_backoff,omitempty"`
+}
+
+// Fluentd is used to send configuration to the fluentd logger
+type Fluentd struct {
+	// Address of the forward input, eg. 127.0.0.1:24224 or a unix socket path.
+	Address string `yaml:"address,omitempty"`
+	// Protocol is tcp or unix.
+	Protocol string `yaml:"protocol,omitempty"`
+	// Tag overrides the tag of the process.
+	Tag string `yaml:"tag,omitempty"`
+	// TagPrefix is added in front of the process name to make the tag.
+	TagPrefix string `yaml:"tag_prefix,omitempty"`
+	// RequireAck waits for fluentd to confirm each batch.
+	RequireAck bool `yaml:"require_ack,omitempty"`
+	// BatchSize is the number of messages that are sent in each batch.
+	BatchSize int `yaml:"batch_size,omitempty"`
+	// FlushInterval is how long messages can wait to be sent, eg. 1s.
+	FlushInterval string `yaml:"flush_interval,omitempty"`
+	// Timeout is how long to wait to connect, write and for an ack, eg. 10s.
+	Timeout string `yaml:"timeout,omitempty"`
+	// MaxRetries is how many times a failed batch is tried again. It is a
+	// pointer so that 0 can turn retries off.
+	MaxRetries *int `yaml:"max_retries,omitempty"`
+	// RetryBackoff is how long to wait before the first retry, eg. 500ms.
+	// The wait is doubled for each retry.
+	RetryBackoff string `yaml:"retry_backoff,omitempty"`
+}
+
+// GELF is used to send configuration to the GELF logger
+type GELF struct {
+	// Address of the Graylog input, eg. graylog:12201.
+	Address string `yaml:"address,omitempty"`
+	// Protocol is udp or tcp.
+	Protocol string `yaml:"protocol,omitempty"`
+	// Compression is used on udp messages. gzip, zlib or none.
+	Compression string `yaml:"compression,omitempty"`
+	// ChunkSize is the largest udp packet that is sent.
+	ChunkSize int `yaml:"chunk_size,omitempty"`
+	// Host overrides the host field. The default is the container hostname.
+	Host string `yaml:"host,omitempty"`
+	// Timeout is how long to wait to connect and write, eg. 10s.
+	Timeout string `yaml:"timeout,omitempty"`
+	// ExtraFields are added to each message. The _ prefix is added for you.
+	ExtraFields map[string]string `yaml:"extra_fields,omitempty"`
+}
+
+// Journald is used to send configuration to the journald logger
+type Journald struct {
+	// Socket is the journal socket. The default is /run/systemd/journal/socket.
+	Socket string `yaml:"socket,omitempty"`
+	// SyslogIdentifier is sent as SYSLOG_IDENTIFIER. The default is the process name.
+	SyslogIdentifier string `yaml:"syslog_identifier,omitempty"`
+	// Fields are extra journal fields added to each message. Names are upper cased.
+	Fields map[string]string `yaml:"fields,omitempty"`
+}
+
+// Loki is used to send configuration to the Loki logger
+type Loki struct {
+	// URL of Loki, eg. http://loki:3100. The push path is added if the URL has no path.
+	URL string `yaml:"url,omitempty"`
+	// Format is how batches are sent. json or protobuf.
+	Format string `yaml:"format,omitempty"`
+	// TenantID is sent in the X-Scope-OrgID header.
+	TenantID string `yaml:"tenant_id,omitempty"`
+	// Username and Password are used for basic auth.
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	// StreamLabels are the labels taken from each message. process, pipe and host.
+	StreamLabels []string `yaml:"stream_labels,omitempty"`
+	// Labels are static labels added to each stream.
+	Labels map[string]string `yaml:"labels,omitempty"`
+	// BatchSize is the number of messages that are sent in each request.
+	BatchSize int `yaml:"batch_size,omitempty"`
+	// BatchBytes is the largest a batch can be before it is sent.
+	BatchBytes ByteSize `yaml:"batch_bytes,omitempty"`
+	// FlushInterval is how long messages can wait to be sent, eg. 1s.
+	FlushInterval string `yaml:"flush_interval,omitempty"`
+	// Timeout is how long each request can take, eg. 10s.
+	Timeout string `yaml:"timeout,omitempty"`
+	// MaxRetries is how many times a failed request is tried again. It is a
+	// pointer so that 0 can turn retries off.
+	MaxRetries *int `yaml:"max_retries,omitempty"`
+	// RetryBackoff is how long to wait before the first retry, eg. 500ms.
+	// The wait is doubled for each retry.
+	RetryBackoff string `yaml:"retry_backoff,omitempty"`
+}
+
+// Kafka is used to send configuration to the Kafka logger
+type Kafka struct {
+	// Brokers are the addresses used to find the cluster, eg. kafka1:9092.
+	Brokers []string `yaml:"brokers,omitempty"`
+	// Topic is a template that is given the process name, eg. logs.{{ .ProcessName }}.
+	Topic string `yaml:"topic,omitempty"`
+	// Key selects the message key. process, pipe, hostname or none.
+	Key string `yaml:"key,omitempty"`
+	// ClientID is sent to the brokers to identify Launch.
+	ClientID string `yaml:"client_id,omitempty"`
+	// Version is the Kafka version of the brokers, eg. 2.8.0.
+	Version string `yaml:"version,omitempty"`
+	// Acks is how many replicas must confirm a message. none, leader or all.
+	Acks string `yaml:"acks,omitempty"`
+	// Compression is used on batches. none, gzip, snappy, lz4 or zstd.
+	Compression string `yaml:"compression,omitempty"`
+	// BatchSize is the number of messages that are sent together.
+	BatchSize int `yaml:"batch_size,omitempty"`
+	// BatchBytes is the largest a batch can be before it is sent.
+	BatchBytes ByteSize `yaml:"batch_bytes,omitempty"`
+	// FlushInterval is how long messages can wait to be sent, eg. 500ms.
+	FlushInterval string `yaml:"flush_interval,omitempty"`
+	// Timeout is how long to wait to connect and for the brokers to respond, eg. 10s.
+	Timeout string `yaml:"timeout,omitempty"`
+	// MaxRetries is how many times a message is sent again if it fails. It
+	// is a pointer so that 0 can turn retries off.
+	MaxRetries *int `yaml:"max_retries,omitempty"`
+	// TLS connects to the brokers using TLS.
+	TLS                   bool   `yaml:"tls,omitempty"`
+	CertificateBundlePath string `yaml:"cert_bundle_path,omitempty"`
+	ClientCertificatePath string `yaml:"client_cert_path,omitempty"`
+	ClientKeyPath         string `yaml:"client_key_path,omitempty"`
+	ServerName            string `yaml:"server_name,omitempty"`
+	// SASLMechanism turns on SASL. PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512.
+	SASLMechanism string `yaml:"sasl_mechanism,omitempty"`
+	Username      string `yaml:"username,omitempty"`
+	Password      string `yaml:"password,omitempty"`
 }
 
 // FileLogger is a logger that will write to files
 type FileLogger struct {
-	Filename        string `yaml:"filepath"`
-	SizeLimit       uint64 `yaml:"size_limit"`
-	HistoricalFiles int    `yaml:"historical_files_limit"`
+	Filename        string   `yaml:"filepath"`
+	SizeLimit       ByteSize `yaml:"size_limit"`
+	HistoricalFiles int      `yaml:"historical_files_limit"`
+	// Format is how lines are written. raw, logfmt, json or a template.
+	Format string `yaml:"format,omitempty"`
+	// RotateEvery rotates the file on a schedule. hourly or daily.
+	RotateEvery string `yaml:"rotate_every,omitempty"`
+	// MaxAge rotates the file once it has been open for this long, eg. 6h.
+	MaxAge string `yaml:"max_age,omitempty"`
+	// Compression compresses rotated files. gzip or zstd.
+	Compression string `yaml:"compression,omitempty"`
+	// HistoricalSizeLimit is the total size that rotated files can use.
+	HistoricalSizeLimit ByteSize `yaml:"historical_size_limit,omitempty"`
+	// Suffix is how rotated files are named. timestamp, index or a time layout.
+	Suffix string `yaml:"suffix,omitempty"`
+
+	// historicalFilesSet is true when historical_files_limit is in the
+	// config. A limit of 0 keeps no rotated files and is not a missing value.
+	historicalFilesSet bool
+}
+
+// UnmarshalYAML records if historical_files_limit was set so that a limit of
+// 0 is not replaced by the default.
+func (fl *FileLogger) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain FileLogger
+	if err := unmarshal((*plain)(fl)); err != nil {
+		return err
+	}
+	keys := map[string]interface{}{}
+	if err := unmarshal(&keys); err != nil {
+		return err
+	}
+	_, fl.historicalFilesSet = keys["historical_files_limit"]
+	return nil
+}
+
+// withDefaults returns a copy of the settings with the empty values taken from
+// defaults. historical_files_limit is only taken when it was not set.
+func (fl FileLogger) withDefaults(defaults FileLogger) FileLogger {
+	if fl.Filename == "" {
+		fl.Filename = defaults.Filename
+	}
+	if fl.SizeLimit == 0 {
+		fl.SizeLimit = defaults.SizeLimit
+	}
+	if !fl.historicalFilesSet {
+		fl.HistoricalFiles = defaults.HistoricalFiles
+		fl.historicalFilesSet = defaults.historicalFilesSet
+	}
+	if fl.Format == "" {
+		fl.Format = defaults.Format
+	}
+	if fl.RotateEvery == "" {
+		fl.RotateEvery = defaults.RotateEvery
+	}
+	if fl.MaxAge == "" {
+		fl.MaxAge = defaults.MaxAge
+	}
+	if fl.Compression == "" {
+		fl.Compression = defaults.Compression
+	}
+	if fl.HistoricalSizeLimit == 0 {
+		fl.HistoricalSizeLimit = defaults.HistoricalSizeLimit
+	}
+	if fl.Suffix == "" {
+		fl.Suffix = defaults.Suffix
+	}
+	return fl
+}
+
+// validate checks the file path and limits. The path is cleaned so that
+// loggers using the same file can be matched up.
+func (fl *FileLogger) validate() error {
+	if fl.Filename == "" {
+		return fmt.Errorf("filepath is required")
+	}
+	fl.Filename = filepath.Clean(fl.Filename)
+	if info, err := os.Stat(fl.Filename); err == nil && info.IsDir() {
+		return fmt.Errorf("filepath %s is a directory", fl.Filename)
+	}
+	if fl.HistoricalFiles < 0 {
+		return fmt.Errorf("historical_files_limit can not be negative. Got: %d", fl.HistoricalFiles)
+	}
+	return nil
+}
+
+// rotationDifferences lists the rotation settings that are not the same in
+// both configs. The line format is not included as each process can write its
+// own format.
+func (fl FileLogger) rotationDifferences(other FileLogger) []string {
+	differences := make([]string, 0)
+	add := func(same bool, setting string) {
+		if !same {
+			differences = append(differences, setting)
+		}
+	}
+	add(fl.SizeLimit == other.SizeLimit, "size_limit")
+	add(fl.HistoricalFiles == other.HistoricalFiles, "historical_files_limit")
+	add(fl.RotateEvery == other.RotateEvery, "rotate_every")
+	add(fl.MaxAge == other.MaxAge, "max_age")
+	add(fl.Compression == other.Compression, "compression")
+	add(fl.HistoricalSizeLimit == other.HistoricalSizeLimit, "historical_size_limit")
+	add(fl.Suffix == other.Suffix, "suffix")
+	return differences
 }
@@ -0,0 +1,45 @@
+package configfile
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// parsers are the names that can be used for parser.
+var parsers = map[string]bool{"json": true, "logfmt": true, "regex": true}
+
+// validateParser checks that the parser is known and that a regex parser has
+// a pattern with named groups.
+func validateParser(conf LoggingConfig) error {
+	if conf.Parser == "" {
+		if conf.ParserPattern != "" {
+			return fmt.Errorf("parser_pattern is only used by the regex parser")
+		}
+		return nil
+	}
+	if !parsers[conf.Parser] {
+		return fmt.Errorf("parser must be json, logfmt or regex. Got: %s", conf.Parser)
+	}
+	if conf.ParseJSON && conf.Parser != "json" {
+		return fmt.Errorf("parse_json can not be used with the %s parser", conf.Parser)
+	}
+	if conf.Parser != "regex" {
+		if conf.ParserPattern != "" {
+			return fmt.Errorf("parser_pattern is only used by the regex parser")
+		}
+		return nil
+	}
+	if conf.ParserPattern == "" {
+		return fmt.Errorf("the regex parser needs a parser_pattern")
+	}
+	pattern, err := regexp.Compile(conf.ParserPattern)
+	if err != nil {
+		return fmt.Errorf("failed to compile parser_pattern %s. Error: %s", conf.ParserPattern, err)
+	}
+	for _, name := range pattern.SubexpNames() {
+		if name != "" {
+			return nil
+		}
+	}
+	return fmt.Errorf("parser_pattern %s has no named groups. Use (?P<name>...) to name the fields", conf.ParserPattern)
+}
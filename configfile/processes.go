@@ -5,6 +5,9 @@ type Processes struct {
 	SecretProcess []*SecretProcess `yaml:"secret_processes,omitempty"`
 	InitProcesses []*Process       `yaml:"init_processes,omitempty"`
 	MainProcesses []*Process       `yaml:"main_processes"`
+	// LogFilesState is the file used to remember how far through each log file
+	// Launch has read. Positions are not kept if it is empty.
+	LogFilesState string `yaml:"log_files_state,omitempty"`
 }
 
 // Process is a struct that consumes a yaml configration and holds config for a
@@ -18,6 +21,29 @@ type Process struct {
 	TermTimeout      int           `yaml:"termination_timeout_seconds,omitempty"`
 	StartDelay       int           `yaml:"start_delay_seconds,omitempty"`
 	WorkingDirectory string        `yaml:"working_dir,omitempty"`
+	// LogFiles are glob patterns of files written by the process. They are
+	// followed and logged with the logging config of the process.
+	LogFiles []string `yaml:"log_files,omitempty"`
+	// TTY runs the process under a pseudo terminal. Stdout and stderr are
+	// merged as they are both written to the terminal.
+	TTY bool `yaml:"tty,omitempty"`
+	// StripANSI removes ANSI escape codes, such as colours, from the output.
+	StripANSI bool `yaml:"strip_ansi,omitempty"`
+	// Stdin is what the process reads from stdin.
+	Stdin Stdin `yaml:"stdin,omitempty"`
+	// TimeoutSeconds stops an init process that runs for longer than this.
+	// 0 means no timeout.
+	TimeoutSeconds int `yaml:"timeout_seconds,omitempty"`
+	// Retries is how many more times a failed init process is run.
+	Retries int `yaml:"retries,omitempty"`
+	// RetryBackoffSeconds is the wait before the first retry. It doubles for
+	// each retry after that.
+	RetryBackoffSeconds int `yaml:"retry_backoff_seconds,omitempty"`
+	// AllowFailure lets Launch carry on if the init process fails.
+	AllowFailure bool `yaml:"allow_failure,omitempty"`
+	// ParallelGroup runs init processes that are next to each other and have
+	// the same group at the same time.
+	ParallelGroup string `yaml:"parallel_group,omitempty"`
 }
 
 // SecretProcess is a struct that consumes a yaml configration and holds config for a
@@ -29,4 +55,6 @@ type SecretProcess struct {
 	TermTimeout      int      `yaml:"termination_timeout_seconds,omitempty"`
 	Skip             bool     `yaml:"skip"`
 	WorkingDirectory string   `yaml:"working_dir,omitempty"`
+	// Stdin is what the process reads from stdin.
+	Stdin Stdin `yaml:"stdin,omitempty"`
 }
@@ -0,0 +1,29 @@
+package configfile
+
+import "fmt"
+
+// Stdin is what a process reads from stdin. The process reads from the null
+// device if nothing is set.
+type Stdin struct {
+	// Inherit gives the process the stdin of Launch.
+	Inherit bool `yaml:"inherit,omitempty"`
+	// File is a file that the process reads as stdin.
+	File string `yaml:"file,omitempty"`
+	// Value is written to stdin of the process. Template functions can be
+	// used to build it.
+	Value string `yaml:"value,omitempty"`
+}
+
+// validate checks that only one source has been set.
+func (s Stdin) validate() error {
+	set := 0
+	for _, ok := range []bool{s.Inherit, s.File != "", s.Value != ""} {
+		if ok {
+			set++
+		}
+	}
+	if set > 1 {
+		return fmt.Errorf("only one of inherit, file or value can be used")
+	}
+	return nil
+}
@@ -9,7 +9,10 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"regexp"
+	"strings"
 	"text/template"
 )
 
@@ -18,10 +21,23 @@ type OptionalString struct {
 }
 
 var funcMap = template.FuncMap{
-	"env":      Env,
-	"default":  Default,
-	"required": Required,
-	"zerolen":  ZeroLen,
+	"env":         Env,
+	"default":     Default,
+	"required":    Required,
+	"zerolen":     ZeroLen,
+	"file":        File,
+	"containerid": ContainerID,
+}
+
+// containerIDSources are read in order to find the id of the container.
+// cgroup v1 has the id in the cgroup paths. cgroup v2 only shows it in the
+// files that the runtime mounts from the container directory.
+var containerIDSources = []struct {
+	path  string
+	regex *regexp.Regexp
+}{
+	{path: "/proc/self/cgroup", regex: regexp.MustCompile(`([0-9a-f]{64})`)},
+	{path: "/proc/self/mountinfo", regex: regexp.MustCompile(`containers/([0-9a-f]{64})/`)},
 }
 
 func (s OptionalString) String() string {
@@ -39,6 +55,34 @@ func Env(key string) OptionalString {
 	return OptionalString{&value}
 }
 
+// File returns the contents of the file without the trailing white space.
+// Nothing is returned if the file can not be read. Use required to make
+// sure that the file is present.
+func File(path string) OptionalString {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return OptionalString{nil}
+	}
+	value := strings.TrimRight(string(content), " \t\r\n")
+	return OptionalString{&value}
+}
+
+// ContainerID returns the id of the container that Launch is running in.
+// It is read from the cgroup and mount details of the process. Nothing is
+// returned if Launch is not running in a container.
+func ContainerID() OptionalString {
+	for _, source := range containerIDSources {
+		content, err := ioutil.ReadFile(source.path)
+		if err != nil {
+			continue
+		}
+		if match := source.regex.FindStringSubmatch(string(content)); match != nil {
+			return OptionalString{&match[1]}
+		}
+	}
+	return OptionalString{nil}
+}
+
 func Default(args ...interface{}) (string, error) {
 	for _, arg := range args {
 		if arg == nil {
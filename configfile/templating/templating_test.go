@@ -1,7 +1,11 @@
 package templating
 
 import (
+	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -48,3 +52,57 @@ func TestFailedRequired(t *testing.T) {
 	}
 
 }
+
+func TestFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "version")
+	if err := ioutil.WriteFile(path, []byte("1.2.3\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	source := []byte(`{{ file "` + path + `" }}|{{ default (file "` + filepath.Join(dir, "missing") + `") "none" }}`)
+	result, err := GenerateTemplate(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(result) != "1.2.3|none" {
+		t.Logf("File contents are not as expected. Got: %s", result)
+		t.Fail()
+	}
+}
+
+func TestContainerID(t *testing.T) {
+	id := strings.Repeat("0123456789abcdef", 4)
+	layer := strings.Repeat("fedcba9876543210", 4)
+	dir := t.TempDir()
+	tests := []struct {
+		cgroup    string
+		mountinfo string
+		want      string
+	}{
+		{cgroup: "12:pids:/docker/" + id + "\n", want: id},
+		{cgroup: "0::/kubepods/besteffort/pod1/cri-containerd-" + id + ".scope\n", want: id},
+		{
+			cgroup:    "0::/\n",
+			mountinfo: "1 0 0:1 / / rw - overlay overlay rw,upperdir=/var/lib/docker/overlay2/" + layer + "/diff\n2 1 8:1 /var/lib/docker/containers/" + id + "/hostname /etc/hostname rw\n",
+			want:      id,
+		},
+		{cgroup: "0::/\n", mountinfo: "1 0 0:1 / / rw\n", want: ""},
+	}
+
+	original := append(containerIDSources[:0:0], containerIDSources...)
+	defer func() { containerIDSources = original }()
+	for i, test := range tests {
+		cgroup := filepath.Join(dir, fmt.Sprintf("cgroup%d", i))
+		mountinfo := filepath.Join(dir, fmt.Sprintf("mountinfo%d", i))
+		ioutil.WriteFile(cgroup, []byte(test.cgroup), 0644)
+		ioutil.WriteFile(mountinfo, []byte(test.mountinfo), 0644)
+		containerIDSources[0].path = cgroup
+		containerIDSources[1].path = mountinfo
+
+		if got := ContainerID().String(); got != test.want {
+			t.Logf("Container id is not as expected. Want: %s, Got: %s", test.want, got)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,313 @@
+// Package filetail follows log files that are written by processes rather than
+// sent to stdout or stderr. Files are found with glob patterns and followed
+// through rotation and truncation.
+// Files that already exist when tailing starts are read from their end unless
+// a saved position is found for them. Files that are created later are read
+// from the start.
+package filetail
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/morfien101/launch/internallogger"
+)
+
+const (
+	pollInterval = time.Millisecond * 250
+	// fingerprintSize is how much of the start of a file is used to tell
+	// files apart across restarts.
+	fingerprintSize = 256
+	// maxLineSize is the longest line that is kept in memory. Longer lines
+	// are split.
+	maxLineSize = 64 * 1024
+	readSize    = 32 * 1024
+)
+
+// Tailer follows the files that match a list of glob patterns.
+type Tailer struct {
+	patterns []string
+	state    *State
+	logger   internallogger.IntErrLogger
+	interval time.Duration
+
+	submit func(path, line string)
+	files  map[string]*file
+	// failed holds the paths that could not be opened so that the error is
+	// only logged once.
+	failed map[string]bool
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// file is a file that is being followed.
+type file struct {
+	path    string
+	handle  *os.File
+	info    os.FileInfo
+	offset  int64
+	partial []byte
+	// fingerprint is kept until the start of the file that it covers changes.
+	fingerprint     string
+	fingerprintSize int64
+}
+
+// New creates a Tailer for the patterns. Positions are saved in state which
+// can be nil.
+func New(patterns []string, state *State, logger internallogger.IntErrLogger) *Tailer {
+	return &Tailer{
+		patterns: patterns,
+		state:    state,
+		logger:   logger,
+		interval: pollInterval,
+		files:    make(map[string]*file),
+		failed:   make(map[string]bool),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start finds the files that already exist and then follows them in the
+// background. submit is called for each line that is read.
+func (t *Tailer) Start(submit func(path, line string)) {
+	t.submit = submit
+	t.poll(true)
+	go func() {
+		defer close(t.done)
+		ticker := time.NewTicker(t.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				t.poll(false)
+			case <-t.stop:
+				t.poll(false)
+				t.closeAll()
+				return
+			}
+		}
+	}()
+}
+
+// Stop reads what is left in the files, saves the positions and stops
+// following them. It is safe to call Stop on a nil Tailer.
+func (t *Tailer) Stop() {
+	if t == nil {
+		return
+	}
+	t.stopOnce.Do(func() { close(t.stop) })
+	<-t.done
+}
+
+// poll matches the patterns, works out which files have been rotated, renamed
+// or removed and reads the new lines.
+func (t *Tailer) poll(starting bool) {
+	matched := make(map[string]os.FileInfo)
+	for _, pattern := range t.patterns {
+		paths, _ := filepath.Glob(pattern)
+		for _, path := range paths {
+			info, err := os.Stat(path)
+			if err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+			matched[path] = info
+		}
+	}
+
+	// Files are followed by identity rather than path, so a file that has
+	// been renamed by a rotation is read to its end before it is closed.
+	followed := make(map[string]*file, len(t.files))
+	for _, f := range t.files {
+		path, ok := findFile(f.info, matched)
+		if !ok {
+			t.read(f)
+			t.flush(f)
+			f.handle.Close()
+			t.state.remove(f.path)
+			continue
+		}
+		if path != f.path {
+			t.state.remove(f.path)
+			f.path = path
+		}
+		followed[path] = f
+		// Read now so that a rotated file is finished before the file that
+		// replaced it is started.
+		t.read(f)
+	}
+	t.files = followed
+
+	paths := make([]string, 0, len(matched))
+	for path := range matched {
+		if _, ok := t.files[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if f := t.open(path, starting); f != nil {
+			t.files[path] = f
+			t.read(f)
+		}
+	}
+
+	for _, f := range t.files {
+		t.state.set(f.path, f.position())
+	}
+	if err := t.state.Save(); err != nil {
+		t.logger.Errorf("%s\n", err)
+	}
+}
+
+// findFile returns the path that info is now found at.
+func findFile(info os.FileInfo, matched map[string]os.FileInfo) (string, bool) {
+	for path, other := range matched {
+		if os.SameFile(info, other) {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// open starts following a file. Files found when tailing starts carry on from
+// their saved position or are read from the end. Files found later are read
+// from the start.
+func (t *Tailer) open(path string, starting bool) *file {
+	handle, err := os.Open(path)
+	if err != nil {
+		if !t.failed[path] {
+			t.logger.Errorf("Failed to open log file %s. Error: %s\n", path, err)
+			t.failed[path] = true
+		}
+		return nil
+	}
+	delete(t.failed, path)
+	info, err := handle.Stat()
+	if err != nil {
+		handle.Close()
+		return nil
+	}
+	f := &file{path: path, handle: handle, info: info}
+	if starting {
+		f.offset = info.Size()
+		if pos, ok := t.state.get(path); ok && pos.Offset <= info.Size() && fingerprint(handle, pos.Offset) == pos.Fingerprint {
+			f.offset = pos.Offset
+		}
+	}
+	if _, err := handle.Seek(f.offset, io.SeekStart); err != nil {
+		handle.Close()
+		return nil
+	}
+	return f
+}
+
+// read submits the complete lines that have been written since the last read.
+// A file that is smaller than the offset, or whose start has changed, has been
+// truncated and is read again from the start.
+func (t *Tailer) read(f *file) {
+	if info, err := f.handle.Stat(); err == nil && (info.Size() < f.offset || f.truncated()) {
+		if _, err := f.handle.Seek(0, io.SeekStart); err == nil {
+			f.offset = 0
+			f.partial = nil
+			f.fingerprintSize = -1
+		}
+	}
+	buf := make([]byte, readSize)
+	for {
+		n, err := f.handle.Read(buf)
+		f.offset += int64(n)
+		data := buf[:n]
+		for len(data) > 0 {
+			end := bytes.IndexByte(data, '\n')
+			if end < 0 {
+				f.partial = append(f.partial, data...)
+				break
+			}
+			line := append(f.partial, data[:end]...)
+			f.partial = nil
+			data = data[end+1:]
+			t.emit(f, line)
+		}
+		if len(f.partial) >= maxLineSize {
+			t.flush(f)
+		}
+		if err != nil || n == 0 {
+			return
+		}
+	}
+}
+
+// flush submits a line that has not been ended with a new line.
+func (t *Tailer) flush(f *file) {
+	line := f.partial
+	f.partial = nil
+	t.emit(f, line)
+}
+
+func (t *Tailer) emit(f *file, line []byte) {
+	if len(line) > 0 {
+		t.submit(f.path, string(line))
+	}
+}
+
+// closeAll submits the lines that have not been ended, saves the positions
+// and closes the files.
+func (t *Tailer) closeAll() {
+	for _, f := range t.files {
+		t.flush(f)
+		t.state.set(f.path, f.position())
+		f.handle.Close()
+	}
+	t.files = make(map[string]*file)
+	if err := t.state.Save(); err != nil {
+		t.logger.Errorf("%s\n", err)
+	}
+}
+
+// position is the offset of the end of the last complete line.
+func (f *file) position() Position {
+	offset := f.offset - int64(len(f.partial))
+	if size := headSize(offset); size != f.fingerprintSize {
+		f.fingerprint = fingerprint(f.handle, offset)
+		f.fingerprintSize = size
+	}
+	return Position{Offset: offset, Fingerprint: f.fingerprint}
+}
+
+// truncated tells us if the start of the file is no longer what it was when
+// the fingerprint was taken. This catches files that have been truncated and
+// written past the old offset between polls.
+func (f *file) truncated() bool {
+	return f.fingerprintSize > 0 && fingerprint(f.handle, f.fingerprintSize) != f.fingerprint
+}
+
+// headSize is how much of the start of the file is used for the fingerprint.
+func headSize(offset int64) int64 {
+	if offset > fingerprintSize {
+		return fingerprintSize
+	}
+	return offset
+}
+
+// fingerprint hashes the start of the file up to the offset.
+func fingerprint(handle *os.File, offset int64) string {
+	size := headSize(offset)
+	if size == 0 {
+		return ""
+	}
+	head := make([]byte, size)
+	if _, err := handle.ReadAt(head, 0); err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(head)
+	return hex.EncodeToString(sum[:])
+}
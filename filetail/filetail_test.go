@@ -0,0 +1,159 @@
+package filetail
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/morfien101/launch/internallogger"
+)
+
+// collector keeps the lines that a Tailer submits.
+type collector struct {
+	sync.Mutex
+	lines []string
+}
+
+func (c *collector) submit(path, line string) {
+	c.Lock()
+	defer c.Unlock()
+	c.lines = append(c.lines, filepath.Base(path)+": "+line)
+}
+
+// waitFor polls until the collector has the lines that are wanted.
+func (c *collector) waitFor(t *testing.T, want []string) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second * 2)
+	for {
+		c.Lock()
+		got := append([]string{}, c.lines...)
+		c.Unlock()
+		if reflect.DeepEqual(got, want) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Lines are not as expected.\nWant: %q\nGot:  %q", want, got)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func startTailer(t *testing.T, pattern string, state *State) (*Tailer, *collector) {
+	c := &collector{}
+	tailer := New([]string{pattern}, state, internallogger.NewFakeLogger())
+	tailer.interval = time.Millisecond * 10
+	tailer.Start(c.submit)
+	return tailer, c
+}
+
+func appendFile(t *testing.T, path, text string) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(text); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFollowRotationAndTruncation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	appendFile(t, path, "written before launch\n")
+
+	tailer, c := startTailer(t, filepath.Join(dir, "*.log*"), nil)
+	defer tailer.Stop()
+
+	appendFile(t, path, "one\ntw")
+	c.waitFor(t, []string{"app.log: one"})
+	appendFile(t, path, "o\n")
+	c.waitFor(t, []string{"app.log: one", "app.log: two"})
+
+	// Rotate by renaming. Lines written to the old file before the rename
+	// are still read and the new file is read from the start.
+	appendFile(t, path, "three\n")
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, path, "four\n")
+	c.waitFor(t, []string{"app.log: one", "app.log: two", "app.log.1: three", "app.log: four"})
+
+	// Rotate by truncating.
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, path, "five\n")
+	c.waitFor(t, []string{"app.log: one", "app.log: two", "app.log.1: three", "app.log: four", "app.log: five"})
+
+	// New files that match the glob are picked up.
+	appendFile(t, filepath.Join(dir, "other.log"), "six\n")
+	c.waitFor(t, []string{"app.log: one", "app.log: two", "app.log.1: three", "app.log: four", "app.log: five", "other.log: six"})
+}
+
+func TestStopFlushesPartialLines(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	tailer, c := startTailer(t, path, nil)
+	appendFile(t, path, "complete\nno new line")
+	tailer.Stop()
+	c.waitFor(t, []string{"app.log: complete", "app.log: no new line"})
+	tailer.Stop()
+}
+
+func TestStateResumes(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	statePath := filepath.Join(dir, "state.json")
+
+	state, err := LoadState(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tailer, c := startTailer(t, path, state)
+	appendFile(t, path, "first run\n")
+	c.waitFor(t, []string{"app.log: first run"})
+	tailer.Stop()
+
+	// Lines written while Launch is not running are read after a restart.
+	appendFile(t, path, "while stopped\n")
+	state, err = LoadState(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tailer, c = startTailer(t, path, state)
+	c.waitFor(t, []string{"app.log: while stopped"})
+	tailer.Stop()
+
+	// A file that has been replaced is read from the end.
+	if err := os.WriteFile(path, []byte(fmt.Sprintf("%s\n", "replaced with different content")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	state, err = LoadState(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tailer, c = startTailer(t, path, state)
+	appendFile(t, path, "after replace\n")
+	c.waitFor(t, []string{"app.log: after replace"})
+	tailer.Stop()
+}
+
+func TestLoadStateErrors(t *testing.T) {
+	if state, err := LoadState(""); state != nil || err != nil {
+		t.Logf("An empty path should not keep any state")
+		t.Fail()
+	}
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := os.WriteFile(path, []byte("not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadState(path); err == nil {
+		t.Logf("A broken state file should return an error")
+		t.Fail()
+	}
+}
@@ -0,0 +1,116 @@
+package filetail
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Position is how far through a file Launch has read.
+type Position struct {
+	Offset int64 `json:"offset"`
+	// Fingerprint is a hash of the start of the file. It is used to tell if
+	// the file has been replaced since the position was saved.
+	Fingerprint string `json:"fingerprint,omitempty"`
+}
+
+// State holds the positions of the tailed files keyed by path. It is saved to
+// a file so that Launch can carry on where it stopped after a restart.
+// A nil State does not keep anything.
+type State struct {
+	path      string
+	lock      sync.Mutex
+	positions map[string]Position
+	changed   bool
+}
+
+// LoadState reads the positions saved in path. A missing file gives an empty
+// State. nil is returned if path is empty.
+func LoadState(path string) (*State, error) {
+	if path == "" {
+		return nil, nil
+	}
+	s := &State{path: path, positions: make(map[string]Position)}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the log file state %s. Error: %s", path, err)
+	}
+	if err := json.Unmarshal(data, &s.positions); err != nil {
+		return nil, fmt.Errorf("failed to decode the log file state %s. Error: %s", path, err)
+	}
+	return s, nil
+}
+
+func (s *State) get(path string) (Position, bool) {
+	if s == nil {
+		return Position{}, false
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	pos, ok := s.positions[path]
+	return pos, ok
+}
+
+func (s *State) set(path string, pos Position) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.positions[path] != pos {
+		s.positions[path] = pos
+		s.changed = true
+	}
+}
+
+func (s *State) remove(path string) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.positions[path]; ok {
+		delete(s.positions, path)
+		s.changed = true
+	}
+}
+
+// Save writes the positions if they have changed. The file is replaced in one
+// step so that a crash does not leave half a state file behind.
+func (s *State) Save() error {
+	if s == nil {
+		return nil
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.changed {
+		return nil
+	}
+	data, err := json.Marshal(s.positions)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to save the log file state. Error: %s", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to save the log file state. Error: %s", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to save the log file state. Error: %s", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to save the log file state. Error: %s", err)
+	}
+	s.changed = false
+	return nil
+}
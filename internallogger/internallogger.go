@@ -5,15 +5,21 @@ package internallogger
 
 import (
 	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
 
 	"github.com/morfien101/launch/configfile"
 	"github.com/morfien101/launch/processlogger"
+	"github.com/morfien101/launch/secrets"
 )
 
 const (
 	// processManagerSource is used to tag the messages when they are sent on to the logging engine
 	// required.
 	processManagerSource = "launch_process_manager"
+	// processManagerType is the process type used on messages from the process manager.
+	processManagerType = "launch"
 )
 
 //IntErrLogger is a logger that will log at Error level
@@ -47,6 +53,7 @@ type IntLogger interface {
 // This logger has a debug bool value which will dictate if the debug logging
 // will be produced.
 type InternalLogger struct {
+	sequence   uint64
 	debug      bool
 	config     configfile.LoggingConfig
 	logManager *processlogger.LogManager
@@ -103,12 +110,18 @@ func (il *InternalLogger) DebugOn(on bool) {
 }
 
 // newMsg creates a new LogMessage with the required resources and returns a pointer to it.
+// Secret values are removed from the message so that they never reach a logging engine.
 func (il *InternalLogger) newMsg(msg string, pipe processlogger.Pipe) *processlogger.LogMessage {
 	return &processlogger.LogMessage{
-		Source:  processManagerSource,
-		Pipe:    pipe,
-		Config:  il.config,
-		Message: msg,
+		Source:      processManagerSource,
+		Pipe:        pipe,
+		Config:      il.config,
+		Message:     secrets.Scrub(msg),
+		Time:        time.Now(),
+		PID:         os.Getpid(),
+		ProcessType: processManagerType,
+		Sequence:    atomic.AddUint64(&il.sequence, 1),
+		Fields:      il.config.Fields,
 	}
 }
 
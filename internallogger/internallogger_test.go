@@ -0,0 +1,81 @@
+package internallogger
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/morfien101/launch/configfile"
+	"github.com/morfien101/launch/processlogger"
+	"github.com/morfien101/launch/secrets"
+)
+
+// captureLogger keeps the messages that it is given so that tests can inspect them.
+type captureLogger struct {
+	sync.Mutex
+	messages []string
+}
+
+func (cl *captureLogger) RegisterConfig(configfile.LoggingConfig, configfile.DefaultLoggerDetails) error {
+	return nil
+}
+
+func (cl *captureLogger) Start() error { return nil }
+
+func (cl *captureLogger) Submit(msg processlogger.LogMessage) {
+	cl.Lock()
+	defer cl.Unlock()
+	cl.messages = append(cl.messages, msg.Message)
+}
+
+func (cl *captureLogger) captured() []string {
+	cl.Lock()
+	defer cl.Unlock()
+	return append([]string{}, cl.messages...)
+}
+
+func (cl *captureLogger) Shutdown() chan error {
+	c := make(chan error, 1)
+	c <- nil
+	return c
+}
+
+func TestSecretsAreScrubbed(t *testing.T) {
+	defer secrets.Forget()
+	capture := &captureLogger{}
+	processlogger.RegisterLogger("capture_internal", func() processlogger.Logger {
+		return capture
+	})
+	config := configfile.LoggingConfig{Engine: "capture_internal"}
+	lm := processlogger.New(10, configfile.DefaultLoggerDetails{})
+	if err := lm.StartLoggers(configfile.Processes{}, config); err != nil {
+		t.Fatal(err)
+	}
+
+	secrets.Remember("sup3r-s3cret")
+	il := New(config, lm)
+	il.DebugOn(true)
+	il.Printf("token=%s\n", "sup3r-s3cret")
+	il.Errorln("failed with sup3r-s3cret")
+	il.Debugf("Using generated config:\n%s", "password: sup3r-s3cret\n")
+
+	want := []string{
+		"token=[REDACTED]\n",
+		"failed with [REDACTED]\n",
+		"Using generated config:\npassword: [REDACTED]\n",
+	}
+	deadline := time.Now().Add(time.Second)
+	for len(capture.captured()) < len(want) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 5)
+	}
+	messages := capture.captured()
+	if len(messages) != len(want) {
+		t.Fatalf("Expected %d messages, Got: %v", len(want), messages)
+	}
+	for i, msg := range messages {
+		if msg != want[i] {
+			t.Logf("Message is not scrubbed. Want: %q, Got: %q", want[i], msg)
+			t.Fail()
+		}
+	}
+}
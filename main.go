@@ -20,6 +20,7 @@ import (
 	"github.com/morfien101/launch/internallogger"
 	"github.com/morfien101/launch/processlogger"
 	"github.com/morfien101/launch/processmanager"
+	"github.com/morfien101/launch/secrets"
 )
 
 var (
@@ -185,6 +186,10 @@ func collectSecrets(secretConfig []*configfile.SecretProcess, pmlogger *internal
 		if err != nil {
 			return fmt.Errorf("failed to decode the secrets from %s. Error: %s", secretProc.Name, err)
 		}
+		// Remember the values before they are used so that they are never logged.
+		for _, value := range procsSecrets {
+			secrets.Remember(value)
+		}
 		if err := addEnvVars(procsSecrets); err != nil {
 			return err
 		}
@@ -219,7 +224,7 @@ func terminate(exitcode int, loggers *processlogger.LogManager) {
 			}
 			return strings.Join(es, ",")
 		}
-		log.Fatalf("Error shutting down loggers. Errors: %s" + errString())
+		log.Fatalf("Error shutting down loggers. Errors: %s", secrets.Scrub(errString()))
 	}
 
 	os.Exit(exitcode)
@@ -12,6 +12,18 @@ import (
 	_ "github.com/morfien101/launch/processlogger/devnull"
 	// Adding ELK logger
 	_ "github.com/morfien101/launch/processlogger/filelogger"
+	// Adding fluentd logger
+	_ "github.com/morfien101/launch/processlogger/fluentd"
+	// Adding GELF logger
+	_ "github.com/morfien101/launch/processlogger/gelf"
+	// Adding HTTP logger
+	_ "github.com/morfien101/launch/processlogger/httplogger"
+	// Adding journald logger
+	_ "github.com/morfien101/launch/processlogger/journald"
+	// Adding Kafka logger
+	_ "github.com/morfien101/launch/processlogger/kafka"
+	// Adding Loki logger
+	_ "github.com/morfien101/launch/processlogger/loki"
 	// Adding Syslog logger
 	_ "github.com/morfien101/launch/processlogger/syslog"
 )
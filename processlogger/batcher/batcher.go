@@ -0,0 +1,134 @@
+// Package batcher groups log lines together so that network loggers can send
+// them in bulk. A batch is sent when it has enough lines, when it is large
+// enough or when the flush interval passes.
+package batcher
+
+import (
+	"sync"
+	"time"
+
+	"github.com/morfien101/launch/processlogger"
+)
+
+const (
+	defaultQueueSize = 1000
+	defaultInterval  = time.Second * 5
+)
+
+// Flusher sends a batch of lines. It is only ever called from one go routine.
+type Flusher func(batch [][]byte) error
+
+// Config controls when batches are sent.
+type Config struct {
+	// MaxItems is the number of lines in a full batch.
+	MaxItems int
+	// MaxBytes is the size of a full batch. A single line that is larger than
+	// MaxBytes is sent on its own.
+	MaxBytes int
+	// Interval is the longest time lines wait before they are sent.
+	Interval time.Duration
+	// QueueSize is the number of lines that can wait while a batch is being sent.
+	QueueSize int
+	// Name is used to identify the logger in errors.
+	Name string
+}
+
+// Batcher collects lines and passes them to a Flusher in batches.
+type Batcher struct {
+	config Config
+	flush  Flusher
+	input  chan []byte
+	done   chan error
+
+	lock    sync.RWMutex
+	stopped bool
+}
+
+// New creates a Batcher. Start needs to be called before lines are added.
+func New(config Config, flush Flusher) *Batcher {
+	if config.QueueSize <= 0 {
+		config.QueueSize = defaultQueueSize
+	}
+	if config.Interval <= 0 {
+		config.Interval = defaultInterval
+	}
+	return &Batcher{
+		config: config,
+		flush:  flush,
+		input:  make(chan []byte, config.QueueSize),
+		done:   make(chan error, 1),
+	}
+}
+
+// Start starts collecting lines.
+func (b *Batcher) Start() {
+	go b.run()
+}
+
+// Add queues a line to be sent. It blocks if the queue is full. It returns
+// false if the Batcher has been stopped.
+func (b *Batcher) Add(line []byte) bool {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	if b.stopped {
+		return false
+	}
+	b.input <- line
+	return true
+}
+
+// Stop sends the lines that are waiting and stops the Batcher. The error from
+// the last batch is returned.
+func (b *Batcher) Stop() error {
+	b.lock.Lock()
+	if b.stopped {
+		b.lock.Unlock()
+		return nil
+	}
+	b.stopped = true
+	close(b.input)
+	b.lock.Unlock()
+	return <-b.done
+}
+
+func (b *Batcher) run() {
+	ticker := time.NewTicker(b.config.Interval)
+	defer ticker.Stop()
+
+	batch := make([][]byte, 0)
+	size := 0
+	send := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := b.flush(batch)
+		batch = make([][]byte, 0)
+		size = 0
+		return err
+	}
+	report := func(err error) {
+		if err != nil {
+			processlogger.ReportError("%s failed to send a batch of logs. Error: %s\n", b.config.Name, err)
+		}
+	}
+
+	for {
+		select {
+		case line, ok := <-b.input:
+			if !ok {
+				b.done <- send()
+				return
+			}
+			if b.config.MaxBytes > 0 && len(batch) > 0 && size+len(line) > b.config.MaxBytes {
+				report(send())
+			}
+			batch = append(batch, line)
+			size = size + len(line)
+			if (b.config.MaxItems > 0 && len(batch) >= b.config.MaxItems) || (b.config.MaxBytes > 0 && size >= b.config.MaxBytes) {
+				report(send())
+			}
+		case <-ticker.C:
+			report(send())
+		}
+	}
+}
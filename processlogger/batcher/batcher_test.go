@@ -0,0 +1,171 @@
+package batcher
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// collector keeps the batches that it is given.
+type collector struct {
+	sync.Mutex
+	batches [][][]byte
+}
+
+func (c *collector) flush(batch [][]byte) error {
+	c.Lock()
+	defer c.Unlock()
+	c.batches = append(c.batches, batch)
+	return nil
+}
+
+func (c *collector) sizes() []int {
+	c.Lock()
+	defer c.Unlock()
+	sizes := make([]int, len(c.batches))
+	for i, batch := range c.batches {
+		sizes[i] = len(batch)
+	}
+	return sizes
+}
+
+func equalSizes(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestBatchByCount(t *testing.T) {
+	c := &collector{}
+	b := New(Config{MaxItems: 3, Interval: time.Hour}, c.flush)
+	b.Start()
+	for i := 0; i < 7; i++ {
+		b.Add([]byte("line"))
+	}
+	if err := b.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.sizes(); !equalSizes(got, []int{3, 3, 1}) {
+		t.Logf("Batches are not as expected. Got: %v", got)
+		t.Fail()
+	}
+}
+
+func TestBatchByBytes(t *testing.T) {
+	c := &collector{}
+	b := New(Config{MaxBytes: 10, Interval: time.Hour}, c.flush)
+	b.Start()
+	b.Add([]byte("1234"))
+	b.Add([]byte("1234"))
+	// Would take the batch over 10 bytes so the first two are sent.
+	b.Add([]byte("1234"))
+	// Larger than the limit so it is sent on its own.
+	b.Add([]byte("123456789012"))
+	if err := b.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.sizes(); !equalSizes(got, []int{2, 1, 1}) {
+		t.Logf("Batches are not as expected. Got: %v", got)
+		t.Fail()
+	}
+}
+
+func TestBatchByInterval(t *testing.T) {
+	c := &collector{}
+	b := New(Config{MaxItems: 100, Interval: time.Millisecond * 20}, c.flush)
+	b.Start()
+	defer b.Stop()
+	b.Add([]byte("line"))
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if equalSizes(c.sizes(), []int{1}) {
+			return
+		}
+		time.Sleep(time.Millisecond * 5)
+	}
+	t.Logf("The batch was not sent after the interval. Got: %v", c.sizes())
+	t.Fail()
+}
+
+func TestAddAfterStop(t *testing.T) {
+	c := &collector{}
+	b := New(Config{}, c.flush)
+	b.Start()
+	b.Stop()
+	if b.Add([]byte("late")) {
+		t.Logf("Lines should not be accepted after the batcher is stopped")
+		t.Fail()
+	}
+	if err := b.Stop(); err != nil {
+		t.Logf("Stopping twice should not fail. Error: %s", err)
+		t.Fail()
+	}
+}
+
+func TestRetry(t *testing.T) {
+	waits := []time.Duration{}
+	retry := Retry{MaxRetries: 6, Backoff: time.Second * 5, Sleep: func(d time.Duration) { waits = append(waits, d) }}
+	tries := 0
+	err := retry.Send(1, func() (bool, error) {
+		tries++
+		if tries < 5 {
+			return true, fmt.Errorf("try again")
+		}
+		return false, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []time.Duration{time.Second * 5, time.Second * 10, time.Second * 20, time.Second * 30}
+	if !reflect.DeepEqual(waits, want) {
+		t.Logf("Backoff is not as expected. Want: %v, Got: %v", want, waits)
+		t.Fail()
+	}
+
+	tries = 0
+	err = retry.Send(3, func() (bool, error) {
+		tries++
+		return false, fmt.Errorf("rejected")
+	})
+	if err == nil || tries != 1 {
+		t.Logf("Errors that can not be retried should stop the retries. Tries: %d, Error: %v", tries, err)
+		t.Fail()
+	}
+
+	retry.MaxRetries = 0
+	tries = 0
+	retry.Send(3, func() (bool, error) {
+		tries++
+		return true, fmt.Errorf("try again")
+	})
+	if tries != 1 {
+		t.Logf("No retries should be made when MaxRetries is 0. Tries: %d", tries)
+		t.Fail()
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	if d, err := ParseDuration("timeout", "", time.Second); err != nil || d != time.Second {
+		t.Logf("An empty value should use the fallback. Got: %s, %v", d, err)
+		t.Fail()
+	}
+	if d, err := ParseDuration("timeout", "250ms", time.Second); err != nil || d != time.Millisecond*250 {
+		t.Logf("The value should be parsed. Got: %s, %v", d, err)
+		t.Fail()
+	}
+	for _, value := range []string{"soon", "0s", "-1s"} {
+		if _, err := ParseDuration("timeout", value, time.Second); err == nil {
+			t.Logf("%s should be rejected", value)
+			t.Fail()
+		}
+	}
+}
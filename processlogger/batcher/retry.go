@@ -0,0 +1,60 @@
+package batcher
+
+import (
+	"fmt"
+	"time"
+)
+
+// maxRetryBackoff is the longest wait between retries.
+const maxRetryBackoff = time.Second * 30
+
+// ParseDuration reads a duration setting. An empty value gives the fallback.
+func ParseDuration(setting, value string, fallback time.Duration) (time.Duration, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s is not a valid duration. Error: %s", setting, err)
+	}
+	if duration <= 0 {
+		return 0, fmt.Errorf("%s must be more than 0. Got: %s", setting, value)
+	}
+	return duration, nil
+}
+
+// Retry sends a batch again when sending it fails.
+type Retry struct {
+	// MaxRetries is how many more times a batch is sent after the first try.
+	MaxRetries int
+	// Backoff is the wait before the first retry. It doubles for each retry
+	// up to 30 seconds.
+	Backoff time.Duration
+	// Sleep is used to wait between retries. time.Sleep is used if it is nil.
+	Sleep func(time.Duration)
+}
+
+// Send calls try until it works, it reports that the batch can not be tried
+// again or the retries run out. messages is the number of messages in the
+// batch and is used in the error.
+func (r Retry) Send(messages int, try func() (retry bool, err error)) error {
+	sleep := r.Sleep
+	if sleep == nil {
+		sleep = time.Sleep
+	}
+	backoff := r.Backoff
+	for attempt := 0; ; attempt++ {
+		retry, err := try()
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= r.MaxRetries {
+			return fmt.Errorf("dropped %d messages after %d attempts. Error: %s", messages, attempt+1, err)
+		}
+		sleep(backoff)
+		backoff = backoff * 2
+		if backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+}
@@ -1,6 +1,8 @@
 // Package console is a logger that prints to the console where the
 // process manager is running. It is intented to be used for development and
 // debugging purposes.
+// Lines can also be written as JSON so that collectors reading the container
+// output can parse them.
 package console
 
 import (
@@ -9,15 +11,23 @@ import (
 
 	"github.com/morfien101/launch/configfile"
 	"github.com/morfien101/launch/processlogger"
+	"github.com/morfien101/launch/processlogger/lineformat"
+	"github.com/morfien101/launch/processlogger/loglevel"
 )
 
 const (
 	//LoggerTag will be used to call this package
 	LoggerTag = "console"
+
+	defaultFormat = lineformat.Prefixed
 )
 
 // Console is a logger that will output to the local stdout and stderr
-type Console struct{}
+type Console struct {
+	// formatters holds the line formatter for each process keyed by process name.
+	formatters       map[string]*lineformat.Formatter
+	defaultFormatter *lineformat.Formatter
+}
 
 // New will return a new pointer to a Console logger
 func init() {
@@ -26,8 +36,30 @@ func init() {
 	})
 }
 
-// RegisterConfig does nothing here.
-func (c *Console) RegisterConfig(_ configfile.LoggingConfig, _ configfile.DefaultLoggerDetails) error {
+// RegisterConfig creates the line formatter for the process. The process
+// format wins over the default format.
+func (c *Console) RegisterConfig(conf configfile.LoggingConfig, defaults configfile.DefaultLoggerDetails) error {
+	detector, err := loglevel.NewDetector(conf.LevelDetection, defaults.Config.LevelDetection)
+	if err != nil {
+		return fmt.Errorf("process %s has an invalid level_detection configuration. Error: %s", conf.ProcessName, err)
+	}
+
+	format := conf.Console.Format
+	if format == "" {
+		format = defaults.Config.Console.Format
+	}
+	if format == "" {
+		format = defaultFormat
+	}
+	formatter, err := lineformat.New(format, detector)
+	if err != nil {
+		return fmt.Errorf("process %s has an invalid console format. Error: %s", conf.ProcessName, err)
+	}
+
+	if c.formatters == nil {
+		c.formatters = make(map[string]*lineformat.Formatter)
+	}
+	c.formatters[conf.ProcessName] = formatter
 	return nil
 }
 
@@ -52,16 +84,29 @@ func (c *Console) Shutdown() chan error {
 }
 
 // Submit will consume a processlogger.LogMessage and send it to the right pipe.
+// Lines read from log files are sent to Stdout.
 func (c *Console) Submit(msg processlogger.LogMessage) {
-	m := fmt.Sprintf("%s: %s", msg.Source, msg.Message)
-	if msg.Pipe == processlogger.STDERR {
+	m := c.format(msg)
+	switch msg.Pipe {
+	case processlogger.STDERR:
 		c.stdErr(m)
-	}
-	if msg.Pipe == processlogger.STDOUT {
+	case processlogger.STDOUT, processlogger.FILE:
 		c.stdOut(m)
 	}
 }
 
+// format renders the message with the formatter for the process that sent it.
+func (c *Console) format(msg processlogger.LogMessage) string {
+	formatter, ok := c.formatters[msg.Config.ProcessName]
+	if !ok {
+		if c.defaultFormatter == nil {
+			c.defaultFormatter, _ = lineformat.New(defaultFormat, nil)
+		}
+		formatter = c.defaultFormatter
+	}
+	return formatter.Format(msg)
+}
+
 // StdOut will copy the message to Stdout
 func (c *Console) stdOut(msg string) error {
 	if _, err := os.Stdout.WriteString(msg); err != nil {
@@ -0,0 +1,157 @@
+package console
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/morfien101/launch/configfile"
+	"github.com/morfien101/launch/processlogger"
+	"github.com/morfien101/launch/processlogger/lineformat"
+)
+
+func TestFormats(t *testing.T) {
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{format: "", want: "web: hello\n"},
+		{format: lineformat.Prefixed, want: "web: hello\n"},
+		{format: lineformat.Plain, want: "hello\n"},
+		{format: lineformat.Raw, want: "hello\n"},
+		{format: "[{{ .Pipe }}] {{ .Source }} {{ .Message }}", want: "[stdout] web hello\n"},
+	}
+	for _, test := range tests {
+		c := &Console{}
+		conf := configfile.LoggingConfig{
+			ProcessName: "web",
+			Console:     configfile.Console{Format: test.format},
+		}
+		if err := c.RegisterConfig(conf, configfile.DefaultLoggerDetails{}); err != nil {
+			t.Fatal(err)
+		}
+		msg := processlogger.LogMessage{
+			Source:  "web",
+			Pipe:    processlogger.STDOUT,
+			Config:  conf,
+			Message: "hello\n",
+		}
+		if got := c.format(msg); got != test.want {
+			t.Logf("Format %q is not as expected. Want: %q, Got: %q", test.format, test.want, got)
+			t.Fail()
+		}
+	}
+}
+
+func TestFileMessagesGoToStdout(t *testing.T) {
+	c := &Console{}
+	conf := configfile.LoggingConfig{ProcessName: "web"}
+	if err := c.RegisterConfig(conf, configfile.DefaultLoggerDetails{}); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = writer
+	c.Submit(processlogger.LogMessage{
+		Source:  "web",
+		Pipe:    processlogger.FILE,
+		Config:  conf,
+		Message: "from a log file\n",
+		File:    "/var/log/app.log",
+	})
+	os.Stdout = stdout
+	writer.Close()
+
+	got, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "web: from a log file\n" {
+		t.Logf("Lines from log files should be written to stdout like stdout lines. Got: %q", got)
+		t.Fail()
+	}
+}
+
+func TestJSONFormat(t *testing.T) {
+	c := &Console{}
+	defaults := configfile.DefaultLoggerDetails{
+		Config: configfile.LoggingConfig{Console: configfile.Console{Format: lineformat.JSON}},
+	}
+	conf := configfile.LoggingConfig{ProcessName: "web"}
+	if err := c.RegisterConfig(conf, defaults); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		pipe        processlogger.Pipe
+		message     string
+		wantLevel   string
+		wantMessage string
+	}{
+		{
+			name:        "plain stdout",
+			pipe:        processlogger.STDOUT,
+			message:     "hello \"world\"\n",
+			wantLevel:   "info",
+			wantMessage: `"hello \"world\""`,
+		},
+		{
+			name:        "plain stderr",
+			pipe:        processlogger.STDERR,
+			message:     "oops\n",
+			wantLevel:   "err",
+			wantMessage: `"oops"`,
+		},
+		{
+			name:        "embedded json",
+			pipe:        processlogger.STDOUT,
+			message:     "{\"level\": \"warn\", \"msg\": \"careful\"}\n",
+			wantLevel:   "warning",
+			wantMessage: `{"level":"warn","msg":"careful"}`,
+		},
+	}
+
+	for _, test := range tests {
+		out := c.format(processlogger.LogMessage{
+			Source:  "web",
+			Pipe:    test.pipe,
+			Config:  conf,
+			Message: test.message,
+		})
+		decoded := map[string]json.RawMessage{}
+		if err := json.Unmarshal([]byte(out), &decoded); err != nil {
+			t.Fatalf("%s did not produce JSON. Got: %s", test.name, out)
+		}
+		var level string
+		json.Unmarshal(decoded["level"], &level)
+		if level != test.wantLevel {
+			t.Logf("%s has the wrong level. Want: %s, Got: %s", test.name, test.wantLevel, level)
+			t.Fail()
+		}
+		if string(decoded["message"]) != test.wantMessage {
+			t.Logf("%s has the wrong message. Want: %s, Got: %s", test.name, test.wantMessage, decoded["message"])
+			t.Fail()
+		}
+		for _, key := range []string{"timestamp", "source", "pipe", "hostname"} {
+			if _, ok := decoded[key]; !ok {
+				t.Logf("%s is missing the %s key", test.name, key)
+				t.Fail()
+			}
+		}
+	}
+}
+
+func TestInvalidFormat(t *testing.T) {
+	c := &Console{}
+	conf := configfile.LoggingConfig{Console: configfile.Console{Format: "xml"}}
+	if err := c.RegisterConfig(conf, configfile.DefaultLoggerDetails{}); err == nil {
+		t.Logf("An invalid console format was accepted")
+		t.Fail()
+	}
+}
@@ -0,0 +1,18 @@
+package processlogger
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// errorOutput is where loggers report the errors that they can not return.
+// It is not stdout because that is where the console logger writes the logs
+// of the processes.
+var errorOutput io.Writer = os.Stderr
+
+// ReportError is used by loggers to report errors that happen in the
+// background, such as failed deliveries. It mimics fmt.Printf.
+func ReportError(format string, args ...interface{}) {
+	fmt.Fprintf(errorOutput, format, args...)
+}
@@ -0,0 +1,301 @@
+package filelogger
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	suffixTimestamp = "timestamp"
+	suffixIndex     = "index"
+
+	// defaultSuffixLayout is used to name rotated files when the suffix is
+	// timestamp. It avoids colons as they are not allowed in file names on
+	// some systems.
+	defaultSuffixLayout = "20060102T150405.000"
+
+	compressionGzip = "gzip"
+	compressionZstd = "zstd"
+)
+
+// compressor writes a compressed copy of src to dst.
+type compressor struct {
+	extension string
+	compress  func(dst io.Writer, src io.Reader) error
+}
+
+// compressors holds the supported compression settings. An empty setting does
+// not compress rotated files.
+var compressors = map[string]*compressor{
+	"": nil,
+	compressionGzip: {
+		extension: ".gz",
+		compress: func(dst io.Writer, src io.Reader) error {
+			zw := gzip.NewWriter(dst)
+			if _, err := io.Copy(zw, src); err != nil {
+				zw.Close()
+				return err
+			}
+			return zw.Close()
+		},
+	},
+	compressionZstd: {
+		extension: ".zst",
+		compress: func(dst io.Writer, src io.Reader) error {
+			zw, err := zstd.NewWriter(dst)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(zw, src); err != nil {
+				zw.Close()
+				return err
+			}
+			return zw.Close()
+		},
+	},
+}
+
+// validateSuffix checks that the suffix is a known scheme or a time layout.
+func validateSuffix(suffix string) error {
+	switch suffix {
+	case "", suffixTimestamp, suffixIndex:
+		return nil
+	}
+	if strings.ContainsRune(suffix, os.PathSeparator) {
+		return fmt.Errorf("suffix %s can not contain a path separator", suffix)
+	}
+	// A layout that has no time elements would give every file the same name.
+	reference := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	if reference.Format(suffix) == suffix {
+		return fmt.Errorf("suffix must be timestamp, index or a time layout. Got: %s", suffix)
+	}
+	return nil
+}
+
+// rotatedName works out the name that the active file is moved to when it is
+// rotated.
+func (w *rotateWriter) rotatedName(now time.Time) (string, error) {
+	switch w.config.Suffix {
+	case suffixIndex:
+		if err := w.shiftIndexedFiles(); err != nil {
+			return "", err
+		}
+		return indexedName(w.config.Filename, 1), nil
+	default:
+		return w.freeName(w.config.Filename + "." + now.Format(w.suffixLayout())), nil
+	}
+}
+
+// freeName adds a counter to the name if a rotated file already uses it.
+// This can happen when files are rotated quickly.
+func (w *rotateWriter) freeName(name string) string {
+	candidate := name
+	for i := 1; w.nameInUse(candidate); i++ {
+		candidate = name + "-" + strconv.Itoa(i)
+	}
+	return candidate
+}
+
+func (w *rotateWriter) nameInUse(name string) bool {
+	if _, err := os.Stat(name); err == nil {
+		return true
+	}
+	for _, c := range compressors {
+		if c == nil {
+			continue
+		}
+		if _, err := os.Stat(name + c.extension); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// shiftIndexedFiles moves each rotated file up one index so that .1 is free
+// for the file that is being rotated. The oldest file is moved first.
+// rotate waits for the files that are being compressed before this is called.
+func (w *rotateWriter) shiftIndexedFiles() error {
+	w.historyLock.Lock()
+	defer w.historyLock.Unlock()
+	for index := len(w.historicalFilePaths) - 1; index >= 0; index-- {
+		current := w.historicalFilePaths[index]
+		next := indexedName(w.config.Filename, index+2) + compressionExtension(current)
+		if err := os.Rename(current, next); err != nil {
+			return err
+		}
+		w.historicalFilePaths[index] = next
+	}
+	return nil
+}
+
+func indexedName(filename string, index int) string {
+	return filename + "." + strconv.Itoa(index)
+}
+
+// compressionExtension returns the compression extension of a rotated file.
+// All extensions are checked as the compression setting could have changed
+// since the file was rotated.
+func compressionExtension(filename string) string {
+	for _, c := range compressors {
+		if c != nil && strings.HasSuffix(filename, c.extension) {
+			return c.extension
+		}
+	}
+	return ""
+}
+
+// rotatedFile is a file found on disk that matches the rotated file names.
+type rotatedFile struct {
+	path    string
+	index   int
+	stamp   time.Time
+	counter int
+}
+
+// discoverHistoricalFiles finds the files that were rotated before a restart
+// so that the limits are applied to them. Only names that match the suffix
+// setting are picked up. The newest file is first.
+func (w *rotateWriter) discoverHistoricalFiles() error {
+	dir := filepath.Dir(w.config.Filename)
+	prefix := filepath.Base(w.config.Filename) + "."
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read %s to find rotated files. Error: %s", dir, err)
+	}
+
+	found := make([]rotatedFile, 0)
+	for _, entry := range entries {
+		if !entry.Mode().IsRegular() || !strings.HasPrefix(entry.Name(), prefix) {
+			continue
+		}
+		suffix := strings.TrimPrefix(entry.Name(), prefix)
+		suffix = strings.TrimSuffix(suffix, compressionExtension(suffix))
+		file := rotatedFile{path: filepath.Join(dir, entry.Name())}
+		if w.config.Suffix == suffixIndex {
+			index, err := strconv.Atoi(suffix)
+			if err != nil || index < 1 {
+				continue
+			}
+			file.index = index
+		} else {
+			stamp, counter, ok := parseSuffix(w.suffixLayout(), suffix)
+			if !ok {
+				continue
+			}
+			file.stamp = stamp
+			file.counter = counter
+		}
+		found = append(found, file)
+	}
+
+	sort.Slice(found, func(i, j int) bool {
+		if w.config.Suffix == suffixIndex {
+			return found[i].index < found[j].index
+		}
+		if !found[i].stamp.Equal(found[j].stamp) {
+			return found[i].stamp.After(found[j].stamp)
+		}
+		return found[i].counter > found[j].counter
+	})
+
+	w.historyLock.Lock()
+	defer w.historyLock.Unlock()
+	w.historicalFilePaths = make([]string, len(found))
+	for index, file := range found {
+		w.historicalFilePaths[index] = file.path
+	}
+	return nil
+}
+
+// suffixLayout is the time layout used to name rotated files.
+func (w *rotateWriter) suffixLayout() string {
+	if w.config.Suffix == "" || w.config.Suffix == suffixTimestamp {
+		return defaultSuffixLayout
+	}
+	return w.config.Suffix
+}
+
+// parseSuffix reads the time from a rotated file suffix. The counter added by
+// freeName is also read if there is one.
+func parseSuffix(layout, suffix string) (time.Time, int, bool) {
+	if stamp, err := time.ParseInLocation(layout, suffix, time.Local); err == nil {
+		return stamp, 0, true
+	}
+	split := strings.LastIndex(suffix, "-")
+	if split < 0 {
+		return time.Time{}, 0, false
+	}
+	counter, err := strconv.Atoi(suffix[split+1:])
+	if err != nil || counter < 1 {
+		return time.Time{}, 0, false
+	}
+	stamp, err := time.ParseInLocation(layout, suffix[:split], time.Local)
+	if err != nil {
+		return time.Time{}, 0, false
+	}
+	return stamp, counter, true
+}
+
+// archive compresses a rotated file in the background and then applies the
+// retention limits. Archiving is done one file at a time so that the retention
+// limits never remove a file that is being compressed.
+func (w *rotateWriter) archive(filename string) {
+	w.archives.Add(1)
+	go func() {
+		defer w.archives.Done()
+		w.archiveLock.Lock()
+		defer w.archiveLock.Unlock()
+		// The file could have been removed by the limits while it was waiting.
+		if c := compressors[w.config.Compression]; c != nil && w.inHistory(filename) {
+			compressed, err := compressFile(filename, c)
+			if err != nil {
+				w.panic(err)
+			} else {
+				w.replaceHistoricalFileName(filename, compressed)
+			}
+		}
+		w.deleteOldFiles()
+	}()
+}
+
+// compressFile writes a compressed copy of the file next to it and removes the
+// original. The original is kept if the compression fails.
+func compressFile(filename string, c *compressor) (string, error) {
+	src, err := os.Open(filename)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s for compression. Error: %s", filename, err)
+	}
+	defer src.Close()
+
+	compressedName := filename + c.extension
+	dst, err := os.OpenFile(compressedName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return "", fmt.Errorf("failed to create %s. Error: %s", compressedName, err)
+	}
+	err = c.compress(dst, src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(compressedName)
+		return "", fmt.Errorf("failed to compress %s. Error: %s", filename, err)
+	}
+
+	if err := os.Remove(filename); err != nil {
+		return "", fmt.Errorf("failed to remove %s after compression. Error: %s", filename, err)
+	}
+	return compressedName, nil
+}
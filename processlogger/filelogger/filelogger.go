@@ -11,17 +11,23 @@ import (
 
 	"github.com/morfien101/launch/configfile"
 	"github.com/morfien101/launch/processlogger"
+	"github.com/morfien101/launch/processlogger/lineformat"
+	"github.com/morfien101/launch/processlogger/loglevel"
 )
 
 const (
 	// LoggerTag is used to identify the logger
 	LoggerTag = "logfile"
+	// defaultFormat writes the messages as they are received.
+	defaultFormat = lineformat.Raw
 )
 
 // FileLogManager is used to keep track of the current files that are used
 // to write logs to.
 type FileLogManager struct {
 	filetracker map[string]*rotateWriter
+	// formatters holds the line formatter for each process keyed by process name.
+	formatters map[string]*lineformat.Formatter
 }
 
 var fileLogManager *FileLogManager
@@ -34,15 +40,47 @@ func init() {
 
 // RegisterConfig will create a new file and router for each config passed in.
 func (flm *FileLogManager) RegisterConfig(conf configfile.LoggingConfig, defaults configfile.DefaultLoggerDetails) error {
+	if err := flm.registerFormatter(conf, defaults); err != nil {
+		return err
+	}
+	if flm.filetracker == nil {
+		flm.filetracker = make(map[string]*rotateWriter)
+	}
 	if _, ok := flm.filetracker[conf.Logfile.Filename]; ok {
 		return nil
 	}
 	wr, err := newRW(conf.Logfile)
-	flm.filetracker[conf.Logfile.Filename] = wr
 	if err != nil {
-		return err
+		return fmt.Errorf("process %s could not open log file %s. Error: %s", conf.ProcessName, conf.Logfile.Filename, err)
 	}
+	flm.filetracker[conf.Logfile.Filename] = wr
+	wr.start()
+
+	return nil
+}
 
+// registerFormatter creates the line formatter for the process. The process format
+// wins over the default format. Messages are written as they are by default.
+func (flm *FileLogManager) registerFormatter(conf configfile.LoggingConfig, defaults configfile.DefaultLoggerDetails) error {
+	detector, err := loglevel.NewDetector(conf.LevelDetection, defaults.Config.LevelDetection)
+	if err != nil {
+		return fmt.Errorf("process %s has an invalid level_detection configuration. Error: %s", conf.ProcessName, err)
+	}
+	format := conf.Logfile.Format
+	if format == "" {
+		format = defaults.Config.Logfile.Format
+	}
+	if format == "" {
+		format = defaultFormat
+	}
+	formatter, err := lineformat.New(format, detector)
+	if err != nil {
+		return fmt.Errorf("process %s has an invalid file logger format. Error: %s", conf.ProcessName, err)
+	}
+	if flm.formatters == nil {
+		flm.formatters = make(map[string]*lineformat.Formatter)
+	}
+	flm.formatters[conf.ProcessName] = formatter
 	return nil
 }
 
@@ -82,5 +120,5 @@ func (flm *FileLogManager) Shutdown() chan error {
 // Submit will write a log message to a file that is dictated by the configuration
 // sent with the processlogger.LogMessage
 func (flm *FileLogManager) Submit(msg processlogger.LogMessage) {
-	flm.filetracker[msg.Config.Logfile.Filename].Write([]byte(msg.Message))
+	flm.filetracker[msg.Config.Logfile.Filename].Write([]byte(flm.formatters[msg.Config.ProcessName].Format(msg)))
 }
@@ -1,13 +1,20 @@
 package filelogger
 
 import (
+	"compress/gzip"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/c2h5oh/datasize"
+	"github.com/klauspost/compress/zstd"
 	"github.com/morfien101/launch/configfile"
+	"github.com/morfien101/launch/processlogger"
 )
 
 func TestDeleteOldFiles(t *testing.T) {
@@ -41,7 +48,7 @@ logline3
 	}
 	config := configfile.FileLogger{
 		Filename:        "/tmp/file01.log",
-		SizeLimit:       oneHundredMegs.Bytes(),
+		SizeLimit:       configfile.ByteSize(oneHundredMegs.Bytes()),
 		HistoricalFiles: 2,
 	}
 	rw, err := newRW(config)
@@ -72,3 +79,444 @@ logline3
 		}
 	}
 }
+
+func TestNextScheduledRotation(t *testing.T) {
+	opened := time.Date(2020, 5, 6, 7, 8, 9, 0, time.UTC)
+	tests := []struct {
+		every string
+		want  time.Time
+		ok    bool
+	}{
+		{every: rotateHourly, want: time.Date(2020, 5, 6, 8, 0, 0, 0, time.UTC), ok: true},
+		{every: rotateDaily, want: time.Date(2020, 5, 7, 0, 0, 0, 0, time.UTC), ok: true},
+		{every: "", ok: false},
+	}
+	for _, test := range tests {
+		got, ok := nextScheduledRotation(test.every, opened)
+		if ok != test.ok || !got.Equal(test.want) {
+			t.Logf("Schedule %q is not as expected. Want: %s, Got: %s", test.every, test.want, got)
+			t.Fail()
+		}
+	}
+}
+
+func TestShouldRotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rw, err := newRW(configfile.FileLogger{
+		Filename:    filepath.Join(dir, "app.log"),
+		SizeLimit:   10,
+		MaxAge:      "1h",
+		RotateEvery: rotateDaily,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rw.openedAt = time.Date(2020, 5, 6, 7, 0, 0, 0, time.Local)
+
+	if rw.shouldRotate(rw.openedAt.Add(time.Minute)) {
+		t.Logf("A new small file should not be rotated")
+		t.Fail()
+	}
+	if !rw.shouldRotate(rw.openedAt.Add(time.Hour)) {
+		t.Logf("A file older than max_age should be rotated")
+		t.Fail()
+	}
+	rw.maxAge = 0
+	if !rw.shouldRotate(time.Date(2020, 5, 7, 0, 0, 1, 0, time.Local)) {
+		t.Logf("A daily file should be rotated after midnight")
+		t.Fail()
+	}
+	rw.Write([]byte("more than ten bytes"))
+	if !rw.shouldRotate(rw.openedAt) {
+		t.Logf("A file over the size limit should be rotated")
+		t.Fail()
+	}
+}
+
+func TestCompressedRotation(t *testing.T) {
+	for _, compression := range []string{compressionGzip, compressionZstd} {
+		dir, err := ioutil.TempDir("", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		rw, err := newRW(configfile.FileLogger{
+			Filename:        filepath.Join(dir, "app.log"),
+			HistoricalFiles: 2,
+			Compression:     compression,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 3; i++ {
+			rw.Write([]byte(fmt.Sprintf("line %d\n", i)))
+			if err := rw.rotate(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		rw.archives.Wait()
+
+		if len(rw.historicalFilePaths) != 2 {
+			t.Fatalf("%s: expected 2 historical files, Got: %v", compression, rw.historicalFilePaths)
+		}
+		newest := rw.historicalFilePaths[0]
+		if !strings.HasSuffix(newest, compressors[compression].extension) {
+			t.Fatalf("%s: rotated file was not compressed. Got: %s", compression, newest)
+		}
+		if strings.Contains(filepath.Base(newest), ":") {
+			t.Logf("%s: rotated file name contains a colon. Got: %s", compression, newest)
+			t.Fail()
+		}
+
+		content, err := decompress(compression, newest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if content != "line 2\n" {
+			t.Logf("%s: compressed content is not as expected. Got: %q", compression, content)
+			t.Fail()
+		}
+		files, _ := ioutil.ReadDir(dir)
+		if len(files) != 3 {
+			t.Logf("%s: expected the active file and 2 rotated files. Got: %d", compression, len(files))
+			t.Fail()
+		}
+	}
+}
+
+func decompress(compression, filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	var reader io.Reader
+	if compression == compressionGzip {
+		reader, err = gzip.NewReader(f)
+	} else {
+		reader, err = zstd.NewReader(f)
+	}
+	if err != nil {
+		return "", err
+	}
+	content, err := ioutil.ReadAll(reader)
+	return string(content), err
+}
+
+func TestIndexSuffix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "app.log")
+	rw, err := newRW(configfile.FileLogger{
+		Filename:        filename,
+		HistoricalFiles: 3,
+		Suffix:          suffixIndex,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		rw.Write([]byte(fmt.Sprintf("file %d", i)))
+		if err := rw.rotate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rw.archives.Wait()
+
+	for index, want := range []string{"file 3", "file 2", "file 1"} {
+		content, err := ioutil.ReadFile(indexedName(filename, index+1))
+		if err != nil || string(content) != want {
+			t.Logf("%s is not as expected. Want: %s, Got: %s", indexedName(filename, index+1), want, content)
+			t.Fail()
+		}
+	}
+	if _, err := os.Stat(indexedName(filename, 4)); err == nil {
+		t.Logf("Files past the historical limit should be removed")
+		t.Fail()
+	}
+}
+
+func TestIndexSuffixWritesWhileCompressing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The slow compressor holds the archive open until it is released.
+	release := make(chan bool)
+	compressors["slow"] = &compressor{
+		extension: ".slow",
+		compress: func(dst io.Writer, src io.Reader) error {
+			<-release
+			_, err := io.Copy(dst, src)
+			return err
+		},
+	}
+	defer delete(compressors, "slow")
+
+	rw, err := newRW(configfile.FileLogger{
+		Filename:        filepath.Join(dir, "app.log"),
+		HistoricalFiles: 3,
+		Suffix:          suffixIndex,
+		Compression:     "slow",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rw.Write([]byte("first file"))
+	if err := rw.rotate(); err != nil {
+		t.Fatal(err)
+	}
+	rw.Write([]byte("second file"))
+	rotated := make(chan error, 1)
+	go func() { rotated <- rw.rotate() }()
+	// Give the rotation time to start waiting for the archive.
+	time.Sleep(time.Millisecond * 100)
+
+	written := make(chan bool, 1)
+	go func() {
+		rw.Write([]byte(" more"))
+		written <- true
+	}()
+	select {
+	case <-written:
+	case <-time.After(time.Second):
+		t.Logf("Writes should not wait for rotated files to be compressed")
+		t.Fail()
+	}
+	close(release)
+	if err := <-rotated; err != nil {
+		t.Fatal(err)
+	}
+	rw.Close()
+}
+
+func TestHistoricalSizeLimit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rw, err := newRW(configfile.FileLogger{
+		Filename:            filepath.Join(dir, "app.log"),
+		HistoricalFiles:     10,
+		HistoricalSizeLimit: 25,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		rw.Write([]byte("ten bytes\n"))
+		if err := rw.rotate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rw.archives.Wait()
+
+	if len(rw.historicalFilePaths) != 2 {
+		t.Logf("Expected 2 files to fit in 25 bytes. Got: %v", rw.historicalFilePaths)
+		t.Fail()
+	}
+}
+
+func TestInvalidRotationSettings(t *testing.T) {
+	configs := []configfile.FileLogger{
+		{RotateEvery: "weekly"},
+		{MaxAge: "soon"},
+		{MaxAge: "-1h"},
+		{Compression: "zip"},
+		{Suffix: "old"},
+		{Suffix: "2006/01/02"},
+	}
+	for _, conf := range configs {
+		conf.Filename = filepath.Join(os.TempDir(), "invalid.log")
+		if _, err := newRW(conf); err == nil {
+			t.Logf("Settings should be rejected: %+v", conf)
+			t.Fail()
+		}
+	}
+}
+
+func TestFileLogManager(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	conf := configfile.LoggingConfig{
+		Engine:      LoggerTag,
+		ProcessName: "web",
+		Logfile:     configfile.FileLogger{Filename: filepath.Join(dir, "web.log"), SizeLimit: 1024},
+	}
+	flm := &FileLogManager{}
+	if err := flm.RegisterConfig(conf, configfile.DefaultLoggerDetails{}); err != nil {
+		t.Fatal(err)
+	}
+	flm.Submit(processlogger.LogMessage{Source: "web", Config: conf, Message: "hello\n"})
+	if err := <-flm.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(conf.Logfile.Filename)
+	if err != nil || string(content) != "hello\n" {
+		t.Logf("Log file content is not as expected. Got: %q, Error: %v", content, err)
+		t.Fail()
+	}
+}
+
+func TestAppendsAfterRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	conf := configfile.FileLogger{Filename: filepath.Join(dir, "app.log"), SizeLimit: 20}
+	for _, line := range []string{"first\n", "second\n"} {
+		rw, err := newRW(conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rw.Write([]byte(line))
+		if err := rw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rw, err := newRW(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rw.Close()
+	content, _ := ioutil.ReadFile(conf.Filename)
+	if string(content) != "first\nsecond\n" {
+		t.Logf("The active file should be appended to. Got: %q", content)
+		t.Fail()
+	}
+	if rw.currentFileSize != uint64(len(content)) {
+		t.Logf("The size of the existing file was not picked up. Got: %d", rw.currentFileSize)
+		t.Fail()
+	}
+}
+
+func TestDiscoverRotatedFiles(t *testing.T) {
+	for _, suffix := range []string{suffixTimestamp, suffixIndex} {
+		dir, err := ioutil.TempDir("", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		conf := configfile.FileLogger{
+			Filename:        filepath.Join(dir, "app.log"),
+			HistoricalFiles: 5,
+			Suffix:          suffix,
+		}
+		rw, err := newRW(conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 4; i++ {
+			rw.Write([]byte(fmt.Sprintf("file %d", i)))
+			if err := rw.rotate(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		rw.Close()
+		ioutil.WriteFile(filepath.Join(dir, "app.log.unrelated"), []byte("keep me"), 0600)
+
+		conf.HistoricalFiles = 2
+		rw, err = newRW(conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rw.Close()
+
+		if len(rw.historicalFilePaths) != 2 {
+			t.Fatalf("%s: expected 2 historical files after the restart. Got: %v", suffix, rw.historicalFilePaths)
+		}
+		for index, want := range []string{"file 3", "file 2"} {
+			content, _ := ioutil.ReadFile(rw.historicalFilePaths[index])
+			if string(content) != want {
+				t.Logf("%s: historical file %d is not as expected. Want: %s, Got: %s", suffix, index, want, content)
+				t.Fail()
+			}
+		}
+		files, _ := ioutil.ReadDir(dir)
+		if len(files) != 4 {
+			t.Logf("%s: expected the active file, 2 rotated files and the unrelated file. Got: %d", suffix, len(files))
+			t.Fail()
+		}
+	}
+}
+
+func TestWatchDogRotatesOnSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rw, err := newRW(configfile.FileLogger{
+		Filename:        filepath.Join(dir, "app.log"),
+		SizeLimit:       10,
+		HistoricalFiles: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rw.start()
+	defer rw.Close()
+
+	rw.Write([]byte("more than ten bytes\n"))
+	deadline := time.Now().Add(time.Second * 2)
+	for time.Now().Before(deadline) {
+		rw.historyLock.Lock()
+		rotated := len(rw.historicalFilePaths)
+		rw.historyLock.Unlock()
+		if rotated == 1 {
+			return
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	t.Logf("The file was not rotated after it went over the size limit")
+	t.Fail()
+}
+
+func TestCloseTwice(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rw, err := newRW(configfile.FileLogger{Filename: filepath.Join(dir, "app.log")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rw.start()
+	if err := rw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := rw.Close(); err != nil {
+		t.Logf("Closing a closed writer should not fail. Error: %s", err)
+		t.Fail()
+	}
+	if _, err := rw.Write([]byte("late")); err == nil {
+		t.Logf("Writing to a closed writer should fail")
+		t.Fail()
+	}
+}
@@ -7,6 +7,16 @@ import (
 	"time"
 
 	"github.com/morfien101/launch/configfile"
+	"github.com/morfien101/launch/processlogger"
+)
+
+const (
+	rotateHourly = "hourly"
+	rotateDaily  = "daily"
+
+	// rotationCheckInterval is how often the watchDog checks the time based
+	// rotation triggers.
+	rotationCheckInterval = time.Minute
 )
 
 // rotateWriter can write and rotate a log file
@@ -18,6 +28,18 @@ type rotateWriter struct {
 	historicalFilePaths []string
 	currentFileSize     uint64
 	running             bool
+
+	// openedAt is when the current file was started.
+	openedAt time.Time
+	// maxAge is how long a file can be written to before it is rotated.
+	maxAge time.Duration
+	// historyLock protects historicalFilePaths as rotated files are
+	// compressed in the background.
+	historyLock sync.Mutex
+	// archives tracks the rotated files that are being compressed.
+	archives sync.WaitGroup
+	// archiveLock makes sure that only one file is archived at a time.
+	archiveLock sync.Mutex
 }
 
 // New makes a new rotateWriter. Return nil if error occurs during setup.
@@ -28,18 +50,80 @@ func newRW(conf configfile.FileLogger) (*rotateWriter, error) {
 		historicalFilePaths: make([]string, 0),
 		running:             true,
 	}
-	// rotate gives us the first file
-	err := w.rotate()
+	if err := w.validate(); err != nil {
+		return nil, err
+	}
+	// Files rotated before a restart count towards the limits.
+	if err := w.discoverHistoricalFiles(); err != nil {
+		return nil, err
+	}
+	// Carry on writing to the file if it is already there.
+	info, err := os.Stat(w.config.Filename)
+	if err == nil {
+		err = w.open(info.ModTime(), uint64(info.Size()))
+	} else {
+		err = w.open(time.Now(), 0)
+	}
 	if err != nil {
 		return nil, err
 	}
+	w.deleteOldFiles()
 	return w, nil
 }
 
+// start runs the watchDog and checks if the file needs to be rotated straight
+// away as it could have been left over from before a restart.
+func (w *rotateWriter) start() {
+	go w.watchDog()
+	w.signalWatchDog()
+}
+
+// signalWatchDog asks the watchDog to check the file. It does not block as a
+// pending signal will cause the check anyway.
+// The caller must hold the lock.
+func (w *rotateWriter) signalWatchDog() {
+	if !w.running {
+		return
+	}
+	select {
+	case w.watchDogSignals <- true:
+	default:
+	}
+}
+
+// validate checks the rotation settings that need to be parsed.
+func (w *rotateWriter) validate() error {
+	switch w.config.RotateEvery {
+	case "", rotateHourly, rotateDaily:
+	default:
+		return fmt.Errorf("rotate_every for %s must be hourly or daily. Got: %s", w.config.Filename, w.config.RotateEvery)
+	}
+
+	if w.config.MaxAge != "" {
+		maxAge, err := time.ParseDuration(w.config.MaxAge)
+		if err != nil {
+			return fmt.Errorf("max_age for %s is not a valid duration. Error: %s", w.config.Filename, err)
+		}
+		if maxAge <= 0 {
+			return fmt.Errorf("max_age for %s must be more than 0. Got: %s", w.config.Filename, w.config.MaxAge)
+		}
+		w.maxAge = maxAge
+	}
+
+	if _, ok := compressors[w.config.Compression]; !ok {
+		return fmt.Errorf("compression for %s must be gzip or zstd. Got: %s", w.config.Filename, w.config.Compression)
+	}
+
+	return validateSuffix(w.config.Suffix)
+}
+
 // watchDog watches the file for size changes and rotates when required.
 // WatchDog will also trigger clean up tasks to remove old files.
+// Time based rotation is checked on a timer as the file could be quiet.
 // watchDog is run as a go routine.
 func (w *rotateWriter) watchDog() {
+	ticker := time.NewTicker(rotationCheckInterval)
+	defer ticker.Stop()
 	for {
 		select {
 		case _, ok := <-w.watchDogSignals:
@@ -47,49 +131,91 @@ func (w *rotateWriter) watchDog() {
 			if !ok {
 				return
 			}
-			// Check to see if the file is too large
-			ok = w.tooLarge()
-			// Check to see if the rotation has created a new file.
-			// This means we could need to delete the files.
-			if !ok {
-				err := w.rotate()
-				if err != nil {
-					w.panic(err)
-				}
-				w.deleteOldFiles()
+		case <-ticker.C:
+		}
+		// Check to see if the file is too large or too old.
+		// Rotation creates a new file which could mean we need to delete files.
+		if w.shouldRotate(time.Now()) {
+			err := w.rotate()
+			if err != nil {
+				w.panic(err)
 			}
 		}
 	}
 }
 
+// shouldRotate tells us if any of the rotation triggers have been hit.
+func (w *rotateWriter) shouldRotate(now time.Time) bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.tooLarge() {
+		return true
+	}
+	if w.maxAge > 0 && now.Sub(w.openedAt) >= w.maxAge {
+		return true
+	}
+	if next, ok := nextScheduledRotation(w.config.RotateEvery, w.openedAt); ok && !now.Before(next) {
+		return true
+	}
+	return false
+}
+
+// nextScheduledRotation works out when a file opened at openedAt should be
+// rotated based on the rotate_every setting.
+func nextScheduledRotation(every string, openedAt time.Time) (time.Time, bool) {
+	switch every {
+	case rotateHourly:
+		return openedAt.Truncate(time.Hour).Add(time.Hour), true
+	case rotateDaily:
+		y, m, d := openedAt.Date()
+		return time.Date(y, m, d+1, 0, 0, 0, 0, openedAt.Location()), true
+	}
+	return time.Time{}, false
+}
+
 // Loggers should not terminate service.
 // Should we need to panic we should handle the situation as best we can
 // Trying to keep service running.
 func (w *rotateWriter) panic(err error) {
 	// we have an err that we need to recover from
-	// The best we can do here is print it to the console
-	fmt.Println(err)
+	// The best we can do here is report it on stderr
+	processlogger.ReportError("file logger error for %s. Error: %s\n", w.config.Filename, err)
 }
 
 // tooLarge will tell us if the number of bytes we have written is more than the
 // file size we want to handle.
 // This is infered to avoid millions of os.stat calls
 func (w *rotateWriter) tooLarge() bool {
-	if w.currentFileSize > w.config.SizeLimit {
+	if w.config.SizeLimit > 0 && w.currentFileSize > w.config.SizeLimit.Bytes() {
 		return true
 	}
 	return false
 }
 
+// deleteOldFiles removes the rotated files that are past the historical files
+// limit. If there is a historical size limit the oldest files are also removed
+// until the rotated files fit inside it.
 func (w *rotateWriter) deleteOldFiles() {
-	keep := make([]string, w.config.HistoricalFiles)
+	w.historyLock.Lock()
+	defer w.historyLock.Unlock()
+	keep := make([]string, 0, w.config.HistoricalFiles)
+	var totalSize uint64
 	for index, filename := range w.historicalFilePaths {
 		if index < w.config.HistoricalFiles {
-			keep[index] = filename
-			continue
+			if w.config.HistoricalSizeLimit == 0 {
+				keep = append(keep, filename)
+				continue
+			}
+			if info, err := os.Stat(filename); err == nil {
+				totalSize = totalSize + uint64(info.Size())
+			}
+			if totalSize <= w.config.HistoricalSizeLimit.Bytes() {
+				keep = append(keep, filename)
+				continue
+			}
 		}
 		err := os.Remove(filename)
-		if err != nil {
+		if err != nil && !os.IsNotExist(err) {
 			w.panic(err)
 		}
 	}
@@ -100,24 +226,50 @@ func (w *rotateWriter) deleteOldFiles() {
 func (w *rotateWriter) Write(output []byte) (int, error) {
 	w.lock.Lock()
 	defer w.lock.Unlock()
+	if !w.running {
+		return 0, fmt.Errorf("%s is closed", w.config.Filename)
+	}
 	n, err := w.fp.Write(output)
 	w.currentFileSize = w.currentFileSize + uint64(n)
+	if w.tooLarge() {
+		w.signalWatchDog()
+	}
 	return n, err
 }
 
-// Close closes out the current file
+// Close stops the watchDog, closes out the current file and waits for any
+// rotated files to finish compressing.
 func (w *rotateWriter) Close() error {
 	w.lock.Lock()
-	defer w.lock.Unlock()
-	close(w.watchDogSignals)
+	if !w.running {
+		w.lock.Unlock()
+		return nil
+	}
 	w.running = false
-	return w.Close()
+	close(w.watchDogSignals)
+	err := w.fp.Close()
+	w.fp = nil
+	w.lock.Unlock()
+
+	w.archives.Wait()
+	return err
 }
 
 // Rotate Perform the actual act of rotating and reopening file.
 func (w *rotateWriter) rotate() error {
+	// Indexed files are moved when rotating and files that are being
+	// compressed can not be moved. Wait for them before taking the lock so
+	// that writes are not held up by the compression. Only the watchDog
+	// rotates so no more files are archived while we wait.
+	if w.config.Suffix == suffixIndex {
+		w.archives.Wait()
+	}
 	w.lock.Lock()
 	defer w.lock.Unlock()
+	// The watchDog could be rotating as the writer is closed.
+	if !w.running {
+		return nil
+	}
 
 	// Close existing file if open
 	if w.fp != nil {
@@ -128,22 +280,77 @@ func (w *rotateWriter) rotate() error {
 		}
 	}
 	// Rename dest file if it already exists
+	now := time.Now()
 	_, err := os.Stat(w.config.Filename)
 	if err == nil {
-		newFileName := w.config.Filename + "." + time.Now().Format(time.RFC3339)
-		err = os.Rename(w.config.Filename, newFileName)
-		if err != nil {
+		if err := w.moveToHistory(now); err != nil {
+			// Keep writing to the current file so that messages are not lost.
+			if openErr := w.open(w.openedAt, w.currentFileSize); openErr != nil {
+				w.panic(openErr)
+			}
 			return err
 		}
-		w.updateHistoricalFileNames(newFileName)
 	}
 
-	// Create a file.
-	w.fp, err = os.Create(w.config.Filename)
-	w.currentFileSize = 0
-	return err
+	return w.open(now, 0)
+}
+
+// moveToHistory renames the active file to its rotated name and starts the
+// archiving of it.
+func (w *rotateWriter) moveToHistory(now time.Time) error {
+	newFileName, err := w.rotatedName(now)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(w.config.Filename, newFileName)
+	if err != nil {
+		return err
+	}
+	w.updateHistoricalFileNames(newFileName)
+	w.archive(newFileName)
+	return nil
+}
+
+// open opens the active file for appending. openedAt and size describe the
+// file if it already exists.
+func (w *rotateWriter) open(openedAt time.Time, size uint64) error {
+	fp, err := os.OpenFile(w.config.Filename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	w.fp = fp
+	w.currentFileSize = size
+	w.openedAt = openedAt
+	return nil
 }
 
 func (w *rotateWriter) updateHistoricalFileNames(newFileName string) {
+	w.historyLock.Lock()
+	defer w.historyLock.Unlock()
 	w.historicalFilePaths = append([]string{newFileName}, w.historicalFilePaths...)
 }
+
+// inHistory tells us if the file is still one of the rotated files.
+func (w *rotateWriter) inHistory(name string) bool {
+	w.historyLock.Lock()
+	defer w.historyLock.Unlock()
+	for _, filename := range w.historicalFilePaths {
+		if filename == name {
+			return true
+		}
+	}
+	return false
+}
+
+// replaceHistoricalFileName swaps a rotated file name for its new name once it
+// has been compressed.
+func (w *rotateWriter) replaceHistoricalFileName(oldName, newName string) {
+	w.historyLock.Lock()
+	defer w.historyLock.Unlock()
+	for index, filename := range w.historicalFilePaths {
+		if filename == oldName {
+			w.historicalFilePaths[index] = newName
+			return
+		}
+	}
+}
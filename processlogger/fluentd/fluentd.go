@@ -0,0 +1,404 @@
+// Package fluentd ships logs to fluentd or fluent-bit using the forward
+// protocol. Messages are sent in PackedForward mode with one batch for each tag.
+// The tag of a process is made from the tag prefix and the process name.
+// See https://github.com/fluent/fluentd/wiki/Forward-Protocol-Specification-v1
+package fluentd
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/morfien101/launch/configfile"
+	"github.com/morfien101/launch/processlogger"
+	"github.com/morfien101/launch/processlogger/batcher"
+	"github.com/morfien101/launch/processlogger/lineformat"
+	"github.com/morfien101/launch/processlogger/loglevel"
+)
+
+const (
+	// LoggerTag is used to identify the logger
+	LoggerTag = "fluentd"
+
+	tcpProtocol  = "tcp"
+	unixProtocol = "unix"
+
+	defaultAddress       = "127.0.0.1:24224"
+	defaultTagPrefix     = "launch"
+	defaultBatchSize     = 500
+	defaultFlushInterval = time.Second
+	defaultTimeout       = time.Second * 10
+	defaultMaxRetries    = 3
+	defaultRetryBackoff  = time.Millisecond * 500
+	// processManagerTag is used for the process manager as it has no process name.
+	processManagerTag = "launch"
+)
+
+// recordKeys are the keys that Launch writes in each record. file is only
+// written for messages read from log files.
+var recordKeys = map[string]bool{
+	"message":      true,
+	"source":       true,
+	"pipe":         true,
+	"level":        true,
+	"hostname":     true,
+	"generation":   true,
+	"pid":          true,
+	"process_type": true,
+	"sequence":     true,
+	"fields":       true,
+}
+
+func init() {
+	processlogger.RegisterLogger(LoggerTag, func() processlogger.Logger {
+		return &Fluentd{}
+	})
+}
+
+// Fluentd sends log messages to a forward input.
+type Fluentd struct {
+	config        configfile.Fluentd
+	flushInterval time.Duration
+	timeout       time.Duration
+	retryBackoff  time.Duration
+	maxRetries    int
+
+	// tags holds the tag for each process keyed by process name.
+	tags map[string]string
+	// formatters are used to work out the fields and level of each message.
+	formatters map[string]*lineformat.Formatter
+	// batchers holds a batcher for each tag.
+	batchers map[string]*batcher.Batcher
+
+	connLock sync.Mutex
+	conn     net.Conn
+	reader   *bufio.Reader
+	// sleep is used to wait between retries. It is replaced in tests.
+	sleep func(time.Duration)
+}
+
+// RegisterConfig validates the connection settings and works out the tag for
+// the process. Only the tag settings can be set on a process.
+func (f *Fluentd) RegisterConfig(conf configfile.LoggingConfig, defaults configfile.DefaultLoggerDetails) error {
+	shared := conf.Fluentd
+	shared.Tag, shared.TagPrefix = "", ""
+	if !reflect.DeepEqual(shared, configfile.Fluentd{}) {
+		return fmt.Errorf("process %s has fluentd connection settings. Only tag and tag_prefix can be set on a process", conf.ProcessName)
+	}
+	if err := f.configure(defaults.Config.Fluentd); err != nil {
+		return fmt.Errorf("the fluentd logger configuration is invalid. Error: %s", err)
+	}
+
+	detector, err := loglevel.NewDetector(conf.LevelDetection, defaults.Config.LevelDetection)
+	if err != nil {
+		return fmt.Errorf("process %s has an invalid level_detection configuration. Error: %s", conf.ProcessName, err)
+	}
+	formatter, err := lineformat.New(lineformat.Raw, detector)
+	if err != nil {
+		return err
+	}
+
+	if f.tags == nil {
+		f.tags = make(map[string]string)
+		f.formatters = make(map[string]*lineformat.Formatter)
+	}
+	f.tags[conf.ProcessName] = processTag(conf, defaults.Config.Fluentd)
+	f.formatters[conf.ProcessName] = formatter
+	return nil
+}
+
+// processTag works out the tag for a process. A tag set on the process wins,
+// otherwise the tag is the prefix followed by the process name.
+func processTag(conf configfile.LoggingConfig, defaults configfile.Fluentd) string {
+	if conf.Fluentd.Tag != "" {
+		return conf.Fluentd.Tag
+	}
+	name := conf.ProcessName
+	if name == "" {
+		name = processManagerTag
+	}
+	prefix := conf.Fluentd.TagPrefix
+	if prefix == "" {
+		prefix = defaults.TagPrefix
+	}
+	if prefix == "" {
+		prefix = defaultTagPrefix
+	}
+	return prefix + "." + name
+}
+
+// configure checks the connection settings and fills in the defaults.
+func (f *Fluentd) configure(config configfile.Fluentd) error {
+	if config.Protocol == "" {
+		config.Protocol = tcpProtocol
+	}
+	if config.Protocol != tcpProtocol && config.Protocol != unixProtocol {
+		return fmt.Errorf("protocol must be %s or %s. Got: %s", tcpProtocol, unixProtocol, config.Protocol)
+	}
+	if config.Address == "" {
+		if config.Protocol == unixProtocol {
+			return fmt.Errorf("address is required for unix sockets")
+		}
+		config.Address = defaultAddress
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaultBatchSize
+	}
+	f.maxRetries = defaultMaxRetries
+	if config.MaxRetries != nil {
+		f.maxRetries = *config.MaxRetries
+	}
+	if f.maxRetries < 0 {
+		return fmt.Errorf("max_retries can not be negative. Got: %d", f.maxRetries)
+	}
+
+	var err error
+	f.flushInterval, err = batcher.ParseDuration("flush_interval", config.FlushInterval, defaultFlushInterval)
+	if err != nil {
+		return err
+	}
+	f.timeout, err = batcher.ParseDuration("timeout", config.Timeout, defaultTimeout)
+	if err != nil {
+		return err
+	}
+	f.retryBackoff, err = batcher.ParseDuration("retry_backoff", config.RetryBackoff, defaultRetryBackoff)
+	if err != nil {
+		return err
+	}
+	f.config = config
+	return nil
+}
+
+// Start creates a batcher for each tag. The connection is made when the first
+// batch is sent so that a missing fluentd does not stop Launch starting.
+func (f *Fluentd) Start() error {
+	if f.sleep == nil {
+		f.sleep = time.Sleep
+	}
+	f.batchers = make(map[string]*batcher.Batcher)
+	for _, tag := range f.tags {
+		if _, ok := f.batchers[tag]; ok {
+			continue
+		}
+		tag := tag
+		b := batcher.New(
+			batcher.Config{
+				MaxItems: f.config.BatchSize,
+				Interval: f.flushInterval,
+				Name:     "fluentd logger",
+			},
+			func(entries [][]byte) error {
+				return f.send(tag, entries)
+			},
+		)
+		b.Start()
+		f.batchers[tag] = b
+	}
+	return nil
+}
+
+// Shutdown sends the messages that are waiting and closes the connection.
+func (f *Fluentd) Shutdown() chan error {
+	c := make(chan error, 1)
+	go func() {
+		defer close(c)
+		var lastErr error
+		for tag, b := range f.batchers {
+			if err := b.Stop(); err != nil {
+				lastErr = fmt.Errorf("failed to send the last batch of logs for %s. Error: %s", tag, err)
+			}
+		}
+		f.connLock.Lock()
+		f.disconnect()
+		f.connLock.Unlock()
+		c <- lastErr
+	}()
+	return c
+}
+
+// Submit encodes the message as a forward protocol entry and adds it to the
+// batch for its tag.
+func (f *Fluentd) Submit(msg processlogger.LogMessage) {
+	tag, ok := f.tags[msg.Config.ProcessName]
+	if !ok {
+		return
+	}
+	record := f.formatters[msg.Config.ProcessName].Record(msg)
+	f.batchers[tag].Add(entry(record))
+}
+
+// entry encodes a record as [time, {fields}]. The keys of parsed messages are
+// added to the record unless they clash with the keys written by Launch.
+func entry(record lineformat.Record) []byte {
+	message := record.Message
+	var parsedKeys []string
+	if record.Parsed != nil {
+		if record.Parsed.MessageKey != "" {
+			message = record.Parsed.Message
+		}
+		for key := range record.Parsed.Fields {
+			if key == record.Parsed.MessageKey || recordKeys[key] || (key == "file" && record.File != "") {
+				continue
+			}
+			parsedKeys = append(parsedKeys, key)
+		}
+		sort.Strings(parsedKeys)
+	}
+
+	fields := 6 + len(parsedKeys)
+	if record.PID != 0 {
+		fields++
+	}
+	if record.ProcessType != "" {
+		fields++
+	}
+	if record.File != "" {
+		fields++
+	}
+	if record.Sequence != 0 {
+		fields++
+	}
+	if len(record.Fields) > 0 {
+		fields++
+	}
+
+	b := appendArrayHeader(make([]byte, 0, 64+len(record.Message)), 2)
+	b = appendEventTime(b, record.Time)
+	b = appendMapHeader(b, fields)
+	b = appendString(appendString(b, "message"), message)
+	b = appendString(appendString(b, "source"), record.Source)
+	b = appendString(appendString(b, "pipe"), record.Pipe)
+	b = appendString(appendString(b, "level"), record.Level)
+	b = appendString(appendString(b, "hostname"), record.Hostname)
+	b = appendInt(appendString(b, "generation"), int64(record.Generation))
+	if record.PID != 0 {
+		b = appendInt(appendString(b, "pid"), int64(record.PID))
+	}
+	if record.ProcessType != "" {
+		b = appendString(appendString(b, "process_type"), record.ProcessType)
+	}
+	if record.File != "" {
+		b = appendString(appendString(b, "file"), record.File)
+	}
+	if record.Sequence != 0 {
+		b = appendInt(appendString(b, "sequence"), int64(record.Sequence))
+	}
+	if len(record.Fields) > 0 {
+		b = appendStringMap(appendString(b, "fields"), record.Fields)
+	}
+	for _, key := range parsedKeys {
+		b = appendValue(appendString(b, key), record.Parsed.Fields[key])
+	}
+	return b
+}
+
+// send writes a PackedForward message for the tag. The connection is remade
+// and the batch sent again if it fails.
+func (f *Fluentd) send(tag string, entries [][]byte) error {
+	f.connLock.Lock()
+	defer f.connLock.Unlock()
+
+	message, chunk, err := f.packedForward(tag, entries)
+	if err != nil {
+		return err
+	}
+	retry := batcher.Retry{MaxRetries: f.maxRetries, Backoff: f.retryBackoff, Sleep: f.sleep}
+	err = retry.Send(len(entries), func() (bool, error) {
+		err := f.write(message, chunk)
+		if err != nil {
+			f.disconnect()
+		}
+		return true, err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send to %s. Error: %s", tag, err)
+	}
+	return nil
+}
+
+// packedForward encodes [tag, entries, options]. The chunk id is only added
+// if acks are required.
+func (f *Fluentd) packedForward(tag string, entries [][]byte) ([]byte, string, error) {
+	size := 0
+	for _, e := range entries {
+		size = size + len(e)
+	}
+	stream := make([]byte, 0, size)
+	for _, e := range entries {
+		stream = append(stream, e...)
+	}
+
+	options := 1
+	chunk := ""
+	if f.config.RequireAck {
+		id := make([]byte, 16)
+		if _, err := rand.Read(id); err != nil {
+			return nil, "", fmt.Errorf("failed to create a chunk id. Error: %s", err)
+		}
+		chunk = base64.StdEncoding.EncodeToString(id)
+		options++
+	}
+
+	b := appendArrayHeader(make([]byte, 0, size+64), 3)
+	b = appendString(b, tag)
+	b = appendBinary(b, stream)
+	b = appendMapHeader(b, options)
+	b = appendInt(appendString(b, "size"), int64(len(entries)))
+	if chunk != "" {
+		b = appendString(appendString(b, "chunk"), chunk)
+	}
+	return b, chunk, nil
+}
+
+// write sends the message and waits for the ack if one is required.
+// The caller must hold connLock.
+func (f *Fluentd) write(message []byte, chunk string) error {
+	if err := f.connect(); err != nil {
+		return err
+	}
+	f.conn.SetDeadline(time.Now().Add(f.timeout))
+	if _, err := f.conn.Write(message); err != nil {
+		return err
+	}
+	if chunk == "" {
+		return nil
+	}
+
+	response, err := readStringMap(f.reader)
+	if err != nil {
+		return fmt.Errorf("failed to read ack. Error: %s", err)
+	}
+	if response["ack"] != chunk {
+		return fmt.Errorf("ack did not match the chunk sent. Want: %s, Got: %s", chunk, response["ack"])
+	}
+	return nil
+}
+
+// connect dials fluentd if there is no connection. The caller must hold connLock.
+func (f *Fluentd) connect() error {
+	if f.conn != nil {
+		return nil
+	}
+	conn, err := net.DialTimeout(f.config.Protocol, f.config.Address, f.timeout)
+	if err != nil {
+		return err
+	}
+	f.conn = conn
+	f.reader = bufio.NewReader(conn)
+	return nil
+}
+
+// disconnect closes the connection. The caller must hold connLock.
+func (f *Fluentd) disconnect() {
+	if f.conn != nil {
+		f.conn.Close()
+		f.conn = nil
+		f.reader = nil
+	}
+}
@@ -0,0 +1,402 @@
+package fluentd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/morfien101/launch/configfile"
+	"github.com/morfien101/launch/processlogger"
+	"github.com/morfien101/launch/processlogger/lineformat"
+)
+
+// decode reads the MessagePack types that the logger writes.
+func decode(r *bufio.Reader) (interface{}, error) {
+	marker, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	readN := func(n int) ([]byte, error) {
+		data := make([]byte, n)
+		_, err := io.ReadFull(r, data)
+		return data, err
+	}
+	decodeArray := func(size int) (interface{}, error) {
+		out := make([]interface{}, size)
+		for i := range out {
+			if out[i], err = decode(r); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	}
+	decodeMap := func(size int) (interface{}, error) {
+		out := make(map[string]interface{}, size)
+		for i := 0; i < size; i++ {
+			key, err := decode(r)
+			if err != nil {
+				return nil, err
+			}
+			if out[key.(string)], err = decode(r); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	}
+
+	switch {
+	case marker&0xf0 == 0x90:
+		return decodeArray(int(marker & 0x0f))
+	case marker&0xf0 == 0x80:
+		return decodeMap(int(marker & 0x0f))
+	case marker&0xe0 == 0xa0, marker == 0xd9, marker == 0xda, marker == 0xdb,
+		marker == 0xc4, marker == 0xc5, marker == 0xc6:
+		r.UnreadByte()
+		s, err := readString(r)
+		if marker >= 0xc4 && marker <= 0xc6 {
+			return []byte(s), err
+		}
+		return s, err
+	case marker == 0xd3:
+		data, err := readN(8)
+		return int64(binary.BigEndian.Uint64(data)), err
+	case marker == 0xcb:
+		data, err := readN(8)
+		return math.Float64frombits(binary.BigEndian.Uint64(data)), err
+	case marker == 0xc0:
+		return nil, nil
+	case marker == 0xc2, marker == 0xc3:
+		return marker == 0xc3, nil
+	case marker == 0xd7:
+		// The extension type followed by seconds and nanoseconds.
+		data, err := readN(9)
+		if err != nil {
+			return nil, err
+		}
+		return time.Unix(int64(binary.BigEndian.Uint32(data[1:5])), int64(binary.BigEndian.Uint32(data[5:]))), nil
+	}
+	return nil, fmt.Errorf("unexpected type 0x%x", marker)
+}
+
+// forwardMessage is a decoded PackedForward message.
+type forwardMessage struct {
+	tag     string
+	entries []interface{}
+	options map[string]interface{}
+}
+
+func readForward(r *bufio.Reader) (forwardMessage, error) {
+	value, err := decode(r)
+	if err != nil {
+		return forwardMessage{}, err
+	}
+	parts := value.([]interface{})
+	msg := forwardMessage{tag: parts[0].(string), options: parts[2].(map[string]interface{})}
+	stream := bufio.NewReader(bytes.NewReader(parts[1].([]byte)))
+	for {
+		e, err := decode(stream)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return msg, err
+		}
+		msg.entries = append(msg.entries, e)
+	}
+	return msg, nil
+}
+
+func newLogger(t *testing.T, config configfile.Fluentd) (*Fluentd, configfile.LoggingConfig) {
+	conf := configfile.LoggingConfig{Engine: LoggerTag, ProcessName: "web"}
+	defaults := configfile.DefaultLoggerDetails{Config: configfile.LoggingConfig{Fluentd: config}}
+	f := &Fluentd{sleep: func(time.Duration) {}}
+	if err := f.RegisterConfig(conf, defaults); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Start(); err != nil {
+		t.Fatal(err)
+	}
+	return f, conf
+}
+
+func TestPackedForwardWithAck(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	received := make(chan forwardMessage, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		msg, err := readForward(bufio.NewReader(conn))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ack := appendMapHeader(nil, 1)
+		ack = appendString(appendString(ack, "ack"), msg.options["chunk"].(string))
+		conn.Write(ack)
+		received <- msg
+	}()
+
+	f, conf := newLogger(t, configfile.Fluentd{
+		Address:    listener.Addr().String(),
+		RequireAck: true,
+		BatchSize:  2,
+	})
+	captured := time.Date(2020, 1, 2, 3, 4, 5, 600, time.UTC)
+	for _, line := range []string{"one\n", "two\n"} {
+		f.Submit(processlogger.LogMessage{
+			Source:  "web",
+			Pipe:    processlogger.STDERR,
+			Config:  conf,
+			Message: line,
+			Time:    captured,
+			PID:     42,
+			Fields:  map[string]string{"env": "prod"},
+		})
+	}
+
+	select {
+	case msg := <-received:
+		if msg.tag != "launch.web" {
+			t.Logf("Tag is not as expected. Got: %s", msg.tag)
+			t.Fail()
+		}
+		if len(msg.entries) != 2 || msg.options["size"] != int64(2) {
+			t.Fatalf("Expected 2 entries. Got: %v, options: %v", msg.entries, msg.options)
+		}
+		first := msg.entries[0].([]interface{})
+		if !first[0].(time.Time).Equal(captured) {
+			t.Logf("Event time is not as expected. Got: %s", first[0])
+			t.Fail()
+		}
+		record := first[1].(map[string]interface{})
+		if record["message"] != "one" || record["pipe"] != "stderr" || record["level"] != "err" || record["pid"] != int64(42) {
+			t.Logf("Record is not as expected. Got: %v", record)
+			t.Fail()
+		}
+		if fields, ok := record["fields"].(map[string]interface{}); !ok || fields["env"] != "prod" {
+			t.Logf("Fields are not as expected. Got: %v", record["fields"])
+			t.Fail()
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatal("Timed out waiting for the forward message")
+	}
+
+	if err := <-f.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResendAfterFailure(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	received := make(chan forwardMessage, 1)
+	go func() {
+		// The first connection is dropped without an ack.
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		readForward(bufio.NewReader(conn))
+		conn.Close()
+
+		conn, err = listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		msg, err := readForward(bufio.NewReader(conn))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ack := appendMapHeader(nil, 1)
+		ack = appendString(appendString(ack, "ack"), msg.options["chunk"].(string))
+		conn.Write(ack)
+		received <- msg
+	}()
+
+	f, conf := newLogger(t, configfile.Fluentd{
+		Address:    listener.Addr().String(),
+		RequireAck: true,
+		Timeout:    "1s",
+	})
+	f.Submit(processlogger.LogMessage{Source: "web", Pipe: processlogger.STDOUT, Config: conf, Message: "retry me\n"})
+	if err := <-f.Shutdown(); err != nil {
+		t.Fatalf("The batch should be sent on the second connection. Error: %s", err)
+	}
+	select {
+	case msg := <-received:
+		if len(msg.entries) != 1 {
+			t.Logf("Expected the batch to be sent again. Got: %v", msg.entries)
+			t.Fail()
+		}
+	default:
+		t.Fatal("The batch was not sent again")
+	}
+}
+
+func retries(n int) *int {
+	return &n
+}
+
+func TestRetryBackoff(t *testing.T) {
+	// Nothing listens on the address so each attempt fails to connect.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	tests := []struct {
+		maxRetries *int
+		want       []time.Duration
+	}{
+		{maxRetries: nil, want: []time.Duration{time.Millisecond * 100, time.Millisecond * 200, time.Millisecond * 400}},
+		{maxRetries: retries(1), want: []time.Duration{time.Millisecond * 100}},
+		{maxRetries: retries(0), want: nil},
+	}
+	for _, test := range tests {
+		f, conf := newLogger(t, configfile.Fluentd{Address: address, Timeout: "1s", MaxRetries: test.maxRetries, RetryBackoff: "100ms"})
+		var waits []time.Duration
+		f.sleep = func(d time.Duration) { waits = append(waits, d) }
+		f.Submit(processlogger.LogMessage{Source: "web", Pipe: processlogger.STDOUT, Config: conf, Message: "lost\n"})
+		if err := <-f.Shutdown(); err == nil {
+			t.Logf("A batch that can not be sent should return an error")
+			t.Fail()
+		}
+		if fmt.Sprint(waits) != fmt.Sprint(test.want) {
+			t.Logf("Retry waits are not as expected. Want: %v, Got: %v", test.want, waits)
+			t.Fail()
+		}
+	}
+}
+
+func TestProcessTag(t *testing.T) {
+	tests := []struct {
+		conf     configfile.LoggingConfig
+		defaults configfile.Fluentd
+		want     string
+	}{
+		{conf: configfile.LoggingConfig{ProcessName: "web"}, want: "launch.web"},
+		{conf: configfile.LoggingConfig{}, want: "launch.launch"},
+		{conf: configfile.LoggingConfig{ProcessName: "web"}, defaults: configfile.Fluentd{TagPrefix: "app"}, want: "app.web"},
+		{
+			conf:     configfile.LoggingConfig{ProcessName: "web", Fluentd: configfile.Fluentd{TagPrefix: "svc"}},
+			defaults: configfile.Fluentd{TagPrefix: "app"},
+			want:     "svc.web",
+		},
+		{conf: configfile.LoggingConfig{ProcessName: "web", Fluentd: configfile.Fluentd{Tag: "custom.tag"}}, want: "custom.tag"},
+	}
+	for _, test := range tests {
+		if got := processTag(test.conf, test.defaults); got != test.want {
+			t.Logf("Tag is not as expected. Want: %s, Got: %s", test.want, got)
+			t.Fail()
+		}
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	configs := []configfile.Fluentd{
+		{Protocol: "udp"},
+		{Protocol: unixProtocol},
+		{Timeout: "soon"},
+		{MaxRetries: retries(-1)},
+		{RetryBackoff: "later"},
+	}
+	for _, config := range configs {
+		f := &Fluentd{}
+		defaults := configfile.DefaultLoggerDetails{Config: configfile.LoggingConfig{Fluentd: config}}
+		if err := f.RegisterConfig(configfile.LoggingConfig{}, defaults); err == nil {
+			t.Logf("Config should be rejected: %+v", config)
+			t.Fail()
+		}
+	}
+
+	f := &Fluentd{}
+	conf := configfile.LoggingConfig{ProcessName: "web", Fluentd: configfile.Fluentd{Tag: "web", Address: "other:24224"}}
+	if err := f.RegisterConfig(conf, configfile.DefaultLoggerDetails{}); err == nil {
+		t.Logf("Connection settings on a process should be rejected")
+		t.Fail()
+	}
+	conf.Fluentd.Address = ""
+	if err := f.RegisterConfig(conf, configfile.DefaultLoggerDetails{}); err != nil {
+		t.Logf("The tag can be set on a process. Error: %s", err)
+		t.Fail()
+	}
+}
+
+func TestParsedEntry(t *testing.T) {
+	f, _ := lineformat.New(lineformat.Raw, nil)
+	msg := processlogger.LogMessage{
+		Source:  "web",
+		Pipe:    processlogger.STDOUT,
+		Message: `{"msg":"done","level":"warn","status":200,"took":1.5,"ok":true,"user":{"id":"42"},"tags":["a"],"source":"app"}` + "\n",
+	}
+	msg.Parsed = &processlogger.Parsed{
+		Fields: map[string]interface{}{
+			"msg":    "done",
+			"level":  "warn",
+			"status": json.Number("200"),
+			"took":   json.Number("1.5"),
+			"ok":     true,
+			"user":   map[string]interface{}{"id": "42"},
+			"tags":   []interface{}{"a"},
+			"source": "app",
+			"none":   nil,
+		},
+		MessageKey: "msg",
+		Message:    "done",
+	}
+
+	value, err := decode(bufio.NewReader(bytes.NewReader(entry(f.Record(msg)))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := value.([]interface{})[1].(map[string]interface{})
+	want := map[string]interface{}{
+		"message": "done",
+		"level":   "warning",
+		"status":  int64(200),
+		"took":    1.5,
+		"ok":      true,
+		"source":  "web",
+	}
+	for key, value := range want {
+		if record[key] != value {
+			t.Logf("%s is not as expected. Want: %v, Got: %v", key, value, record[key])
+			t.Fail()
+		}
+	}
+	if _, ok := record["msg"]; ok {
+		t.Logf("The message key should not be repeated. Got: %v", record)
+		t.Fail()
+	}
+	if user, ok := record["user"].(map[string]interface{}); !ok || user["id"] != "42" {
+		t.Logf("Nested objects are not as expected. Got: %v", record["user"])
+		t.Fail()
+	}
+	if none, ok := record["none"]; !ok || none != nil {
+		t.Logf("null values are not as expected. Got: %v", record)
+		t.Fail()
+	}
+}
@@ -0,0 +1,223 @@
+package fluentd
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+)
+
+// The forward protocol only needs a small part of MessagePack so it is written
+// here rather than bringing in a full library.
+// See https://github.com/msgpack/msgpack/blob/master/spec.md
+
+// appendArrayHeader starts an array of size items.
+func appendArrayHeader(b []byte, size int) []byte {
+	switch {
+	case size < 16:
+		return append(b, 0x90|byte(size))
+	case size <= math.MaxUint16:
+		return append(b, 0xdc, byte(size>>8), byte(size))
+	default:
+		b = append(b, 0xdd)
+		return binary.BigEndian.AppendUint32(b, uint32(size))
+	}
+}
+
+// appendMapHeader starts a map of size key value pairs.
+func appendMapHeader(b []byte, size int) []byte {
+	switch {
+	case size < 16:
+		return append(b, 0x80|byte(size))
+	case size <= math.MaxUint16:
+		return append(b, 0xde, byte(size>>8), byte(size))
+	default:
+		b = append(b, 0xdf)
+		return binary.BigEndian.AppendUint32(b, uint32(size))
+	}
+}
+
+func appendString(b []byte, s string) []byte {
+	size := len(s)
+	switch {
+	case size < 32:
+		b = append(b, 0xa0|byte(size))
+	case size <= math.MaxUint8:
+		b = append(b, 0xd9, byte(size))
+	case size <= math.MaxUint16:
+		b = append(b, 0xda, byte(size>>8), byte(size))
+	default:
+		b = append(b, 0xdb)
+		b = binary.BigEndian.AppendUint32(b, uint32(size))
+	}
+	return append(b, s...)
+}
+
+func appendBinary(b []byte, data []byte) []byte {
+	size := len(data)
+	switch {
+	case size <= math.MaxUint8:
+		b = append(b, 0xc4, byte(size))
+	case size <= math.MaxUint16:
+		b = append(b, 0xc5, byte(size>>8), byte(size))
+	default:
+		b = append(b, 0xc6)
+		b = binary.BigEndian.AppendUint32(b, uint32(size))
+	}
+	return append(b, data...)
+}
+
+// appendInt always uses the 64 bit form. It is simple and fluentd does not mind.
+func appendInt(b []byte, i int64) []byte {
+	b = append(b, 0xd3)
+	return binary.BigEndian.AppendUint64(b, uint64(i))
+}
+
+// appendFloat writes a 64 bit float.
+func appendFloat(b []byte, f float64) []byte {
+	b = append(b, 0xcb)
+	return binary.BigEndian.AppendUint64(b, math.Float64bits(f))
+}
+
+// appendValue writes a value decoded from JSON.
+func appendValue(b []byte, value interface{}) []byte {
+	switch v := value.(type) {
+	case nil:
+		return append(b, 0xc0)
+	case bool:
+		if v {
+			return append(b, 0xc3)
+		}
+		return append(b, 0xc2)
+	case string:
+		return appendString(b, v)
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return appendInt(b, i)
+		}
+		f, _ := v.Float64()
+		return appendFloat(b, f)
+	case float64:
+		return appendFloat(b, v)
+	case []interface{}:
+		b = appendArrayHeader(b, len(v))
+		for _, item := range v {
+			b = appendValue(b, item)
+		}
+		return b
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		b = appendMapHeader(b, len(keys))
+		for _, key := range keys {
+			b = appendValue(appendString(b, key), v[key])
+		}
+		return b
+	}
+	return appendString(b, fmt.Sprint(value))
+}
+
+// appendStringMap writes a map of strings with the keys in sorted order.
+func appendStringMap(b []byte, m map[string]string) []byte {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	b = appendMapHeader(b, len(keys))
+	for _, key := range keys {
+		b = appendString(appendString(b, key), m[key])
+	}
+	return b
+}
+
+// appendEventTime writes the fluentd EventTime extension which keeps the
+// nanoseconds of the time.
+func appendEventTime(b []byte, t time.Time) []byte {
+	b = append(b, 0xd7, 0x00)
+	b = binary.BigEndian.AppendUint32(b, uint32(t.Unix()))
+	return binary.BigEndian.AppendUint32(b, uint32(t.Nanosecond()))
+}
+
+// readStringMap reads a map with string keys and values. It is used to read
+// the ack response.
+func readStringMap(r *bufio.Reader) (map[string]string, error) {
+	marker, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	var size int
+	switch {
+	case marker&0xf0 == 0x80:
+		size = int(marker & 0x0f)
+	case marker == 0xde:
+		n, err := readUint(r, 2)
+		if err != nil {
+			return nil, err
+		}
+		size = int(n)
+	default:
+		return nil, fmt.Errorf("expected a map. Got type 0x%x", marker)
+	}
+
+	out := make(map[string]string, size)
+	for i := 0; i < size; i++ {
+		key, err := readString(r)
+		if err != nil {
+			return nil, err
+		}
+		value, err := readString(r)
+		if err != nil {
+			return nil, err
+		}
+		out[key] = value
+	}
+	return out, nil
+}
+
+func readString(r *bufio.Reader) (string, error) {
+	marker, err := r.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	var size uint64
+	switch {
+	case marker&0xe0 == 0xa0:
+		size = uint64(marker & 0x1f)
+	case marker == 0xd9 || marker == 0xc4:
+		size, err = readUint(r, 1)
+	case marker == 0xda || marker == 0xc5:
+		size, err = readUint(r, 2)
+	case marker == 0xdb || marker == 0xc6:
+		size, err = readUint(r, 4)
+	default:
+		return "", fmt.Errorf("expected a string. Got type 0x%x", marker)
+	}
+	if err != nil {
+		return "", err
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func readUint(r *bufio.Reader, size int) (uint64, error) {
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return 0, err
+	}
+	var n uint64
+	for _, b := range data {
+		n = n<<8 | uint64(b)
+	}
+	return n, nil
+}
@@ -0,0 +1,429 @@
+// Package gelf ships logs to Graylog using GELF 1.1 messages.
+// Messages can be sent over udp, where they are compressed and chunked, or
+// over tcp, where they are null delimited.
+// See https://go2docs.graylog.org/current/getting_in_log_data/gelf.html
+package gelf
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/morfien101/launch/configfile"
+	"github.com/morfien101/launch/processlogger"
+	"github.com/morfien101/launch/processlogger/batcher"
+	"github.com/morfien101/launch/processlogger/lineformat"
+	"github.com/morfien101/launch/processlogger/loglevel"
+)
+
+const (
+	// LoggerTag is used to identify the logger
+	LoggerTag = "gelf"
+
+	udpProtocol = "udp"
+	tcpProtocol = "tcp"
+
+	compressionGzip = "gzip"
+	compressionZlib = "zlib"
+	compressionNone = "none"
+
+	gelfVersion = "1.1"
+	// defaultChunkSize fits inside the MTU of most networks.
+	defaultChunkSize = 1420
+	// chunkHeaderSize is the magic bytes, message id, sequence number and count.
+	chunkHeaderSize = 12
+	// maxChunks is the most chunks that Graylog will put back together.
+	maxChunks      = 128
+	defaultTimeout = time.Second * 10
+)
+
+var (
+	// fieldNamePattern is the characters that GELF allows in field names.
+	fieldNamePattern = regexp.MustCompile(`^[\w\.\-]+$`)
+	chunkMagic       = []byte{0x1e, 0x0f}
+)
+
+func init() {
+	processlogger.RegisterLogger(LoggerTag, func() processlogger.Logger {
+		return &GELF{}
+	})
+}
+
+// GELF sends log messages to a Graylog GELF input.
+type GELF struct {
+	config   configfile.GELF
+	hostname string
+	timeout  time.Duration
+
+	// formatters are used to work out the level of each message.
+	formatters map[string]*lineformat.Formatter
+	// extraFields holds the additional fields for each process keyed by
+	// process name. The keys already have the _ prefix.
+	extraFields map[string]map[string]string
+
+	connLock sync.Mutex
+	conn     net.Conn
+}
+
+// RegisterConfig validates the connection settings and works out the
+// additional fields for the process. Only extra_fields can be set on a process.
+func (g *GELF) RegisterConfig(conf configfile.LoggingConfig, defaults configfile.DefaultLoggerDetails) error {
+	shared := conf.GELF
+	shared.ExtraFields = nil
+	if !reflect.DeepEqual(shared, configfile.GELF{}) {
+		return fmt.Errorf("process %s has gelf connection settings. Only extra_fields can be set on a process", conf.ProcessName)
+	}
+	if err := g.configure(defaults.Config.GELF); err != nil {
+		return fmt.Errorf("the gelf logger configuration is invalid. Error: %s", err)
+	}
+
+	detector, err := loglevel.NewDetector(conf.LevelDetection, defaults.Config.LevelDetection)
+	if err != nil {
+		return fmt.Errorf("process %s has an invalid level_detection configuration. Error: %s", conf.ProcessName, err)
+	}
+	formatter, err := lineformat.New(lineformat.Raw, detector)
+	if err != nil {
+		return err
+	}
+
+	for key := range conf.Fields {
+		if _, err := fieldName(key); err != nil {
+			return fmt.Errorf("process %s has a field that can not be sent to gelf. Error: %s", conf.ProcessName, err)
+		}
+	}
+
+	fields := make(map[string]string)
+	for _, extra := range []map[string]string{defaults.Config.GELF.ExtraFields, conf.GELF.ExtraFields} {
+		for key, value := range extra {
+			name, err := fieldName(key)
+			if err != nil {
+				return fmt.Errorf("process %s has an invalid gelf extra field. Error: %s", conf.ProcessName, err)
+			}
+			fields[name] = value
+		}
+	}
+
+	if g.formatters == nil {
+		g.formatters = make(map[string]*lineformat.Formatter)
+		g.extraFields = make(map[string]map[string]string)
+	}
+	g.formatters[conf.ProcessName] = formatter
+	g.extraFields[conf.ProcessName] = fields
+	return nil
+}
+
+// fieldName adds the _ prefix to an additional field name and checks that
+// GELF will accept it.
+func fieldName(key string) (string, error) {
+	name := key
+	if !strings.HasPrefix(name, "_") {
+		name = "_" + name
+	}
+	if !fieldNamePattern.MatchString(name) {
+		return "", fmt.Errorf("%s contains characters that are not allowed in field names", key)
+	}
+	if name == "_id" {
+		return "", fmt.Errorf("%s is reserved by GELF", key)
+	}
+	return name, nil
+}
+
+// configure checks the connection settings and fills in the defaults.
+func (g *GELF) configure(config configfile.GELF) error {
+	if config.Address == "" {
+		return fmt.Errorf("address is required")
+	}
+	if config.Protocol == "" {
+		config.Protocol = udpProtocol
+	}
+	if config.Protocol != udpProtocol && config.Protocol != tcpProtocol {
+		return fmt.Errorf("protocol must be %s or %s. Got: %s", udpProtocol, tcpProtocol, config.Protocol)
+	}
+	switch {
+	case config.Protocol == tcpProtocol:
+		// GELF over tcp does not support compression.
+		if config.Compression != "" && config.Compression != compressionNone {
+			return fmt.Errorf("compression can not be used over %s", tcpProtocol)
+		}
+		config.Compression = compressionNone
+	case config.Compression == "":
+		config.Compression = compressionGzip
+	}
+	switch config.Compression {
+	case compressionGzip, compressionZlib, compressionNone:
+	default:
+		return fmt.Errorf("compression must be gzip, zlib or none. Got: %s", config.Compression)
+	}
+	if config.ChunkSize == 0 {
+		config.ChunkSize = defaultChunkSize
+	}
+	if config.ChunkSize <= chunkHeaderSize {
+		return fmt.Errorf("chunk_size must be more than %d. Got: %d", chunkHeaderSize, config.ChunkSize)
+	}
+	timeout, err := batcher.ParseDuration("timeout", config.Timeout, defaultTimeout)
+	if err != nil {
+		return err
+	}
+
+	g.config = config
+	g.timeout = timeout
+	g.hostname = config.Host
+	if g.hostname == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			hostname = "not_available"
+		}
+		g.hostname = hostname
+	}
+	return nil
+}
+
+// Start does nothing as the connection is made when the first message is
+// sent. This stops a missing Graylog from stopping Launch starting.
+func (g *GELF) Start() error {
+	return nil
+}
+
+// Shutdown closes the connection.
+func (g *GELF) Shutdown() chan error {
+	c := make(chan error, 1)
+	go func() {
+		defer close(c)
+		g.connLock.Lock()
+		defer g.connLock.Unlock()
+		if g.conn == nil {
+			c <- nil
+			return
+		}
+		err := g.conn.Close()
+		g.conn = nil
+		if err != nil {
+			c <- fmt.Errorf("failed to close the gelf connection. Error: %s", err)
+			return
+		}
+		c <- nil
+	}()
+	return c
+}
+
+// Submit turns the message into GELF and sends it.
+func (g *GELF) Submit(msg processlogger.LogMessage) {
+	message, ok := g.message(msg)
+	if !ok {
+		return
+	}
+	if err := g.send(message); err != nil {
+		processlogger.ReportError("failed to send gelf message. Error: %s\n", err)
+	}
+}
+
+// message creates the GELF JSON for a log message. Empty lines are not sent
+// as GELF requires a short_message.
+func (g *GELF) message(msg processlogger.LogMessage) ([]byte, bool) {
+	formatter, ok := g.formatters[msg.Config.ProcessName]
+	if !ok {
+		formatter, _ = lineformat.New(lineformat.Raw, nil)
+	}
+	text := strings.TrimRight(msg.Message, "\n")
+	if strings.TrimSpace(text) == "" {
+		return nil, false
+	}
+	timestamp := msg.Time
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+
+	level := formatter.Level(msg, text)
+
+	// The keys of parsed messages are added first so that the details from
+	// Launch win. Keys that GELF does not allow are left out.
+	fields := map[string]interface{}{}
+	if msg.Parsed != nil {
+		for key, value := range msg.Parsed.Fields {
+			if key == msg.Parsed.MessageKey {
+				continue
+			}
+			if name, err := fieldName(key); err == nil {
+				fields[name] = fieldValue(value)
+			}
+		}
+		if msg.Parsed.MessageKey != "" && strings.TrimSpace(msg.Parsed.Message) != "" {
+			text = msg.Parsed.Message
+		}
+	}
+	fields["version"] = gelfVersion
+	fields["host"] = g.hostname
+	fields["short_message"] = text
+	fields["timestamp"] = float64(timestamp.UnixNano()/int64(time.Millisecond)) / 1000
+	fields["level"] = int(level)
+	fields["_process"] = msg.Source
+	fields["_pipe"] = msg.Pipe.Name()
+	if newline := strings.Index(text, "\n"); newline >= 0 {
+		fields["short_message"] = text[:newline]
+		fields["full_message"] = text
+	}
+	if msg.PID != 0 {
+		fields["_pid"] = msg.PID
+	}
+	if msg.ProcessType != "" {
+		fields["_process_type"] = msg.ProcessType
+	}
+	if msg.File != "" {
+		fields["_file"] = msg.File
+	}
+	if msg.Sequence != 0 {
+		fields["_sequence"] = msg.Sequence
+	}
+	for key, value := range msg.Fields {
+		fields["_"+key] = value
+	}
+	for key, value := range g.extraFields[msg.Config.ProcessName] {
+		fields[key] = value
+	}
+
+	out, err := json.Marshal(fields)
+	if err != nil {
+		return nil, false
+	}
+	return out, true
+}
+
+// fieldValue returns a parsed value in a form that GELF accepts. Additional
+// fields can only be strings or numbers.
+func fieldValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number, float64:
+		return v
+	}
+	return processlogger.ValueText(value)
+}
+
+// send writes the message with the framing needed for the protocol.
+func (g *GELF) send(message []byte) error {
+	g.connLock.Lock()
+	defer g.connLock.Unlock()
+	if g.config.Protocol == tcpProtocol {
+		return g.sendTCP(message)
+	}
+	return g.sendUDP(message)
+}
+
+// sendTCP writes the null delimited message. The connection is remade once
+// if the write fails. The caller must hold connLock.
+func (g *GELF) sendTCP(message []byte) error {
+	frame := append(message, 0)
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if err = g.connect(); err != nil {
+			continue
+		}
+		g.conn.SetWriteDeadline(time.Now().Add(g.timeout))
+		if _, err = g.conn.Write(frame); err == nil {
+			return nil
+		}
+		g.conn.Close()
+		g.conn = nil
+	}
+	return err
+}
+
+// sendUDP compresses the message and sends it in chunks if it does not fit in
+// a single packet. The caller must hold connLock.
+func (g *GELF) sendUDP(message []byte) error {
+	payload, err := compress(g.config.Compression, message)
+	if err != nil {
+		return err
+	}
+	if err := g.connect(); err != nil {
+		return err
+	}
+	g.conn.SetWriteDeadline(time.Now().Add(g.timeout))
+	if len(payload) <= g.config.ChunkSize {
+		_, err := g.conn.Write(payload)
+		return err
+	}
+
+	chunks, err := chunk(payload, g.config.ChunkSize)
+	if err != nil {
+		return err
+	}
+	for _, c := range chunks {
+		if _, err := g.conn.Write(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// connect dials Graylog if there is no connection. The caller must hold connLock.
+func (g *GELF) connect() error {
+	if g.conn != nil {
+		return nil
+	}
+	conn, err := net.DialTimeout(g.config.Protocol, g.config.Address, g.timeout)
+	if err != nil {
+		return err
+	}
+	g.conn = conn
+	return nil
+}
+
+func compress(compression string, message []byte) ([]byte, error) {
+	if compression == compressionNone {
+		return message, nil
+	}
+	out := &bytes.Buffer{}
+	var writer io.WriteCloser
+	if compression == compressionZlib {
+		writer = zlib.NewWriter(out)
+	} else {
+		writer = gzip.NewWriter(out)
+	}
+	if _, err := writer.Write(message); err != nil {
+		return nil, fmt.Errorf("failed to compress gelf message. Error: %s", err)
+	}
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress gelf message. Error: %s", err)
+	}
+	return out.Bytes(), nil
+}
+
+// chunk splits the payload into GELF chunks that are at most size bytes.
+func chunk(payload []byte, size int) ([][]byte, error) {
+	dataSize := size - chunkHeaderSize
+	count := (len(payload) + dataSize - 1) / dataSize
+	if count > maxChunks {
+		return nil, fmt.Errorf("message is too large to send over udp. It needs %d chunks, the limit is %d", count, maxChunks)
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("failed to create a chunk id. Error: %s", err)
+	}
+
+	chunks := make([][]byte, 0, count)
+	for sequence := 0; sequence < count; sequence++ {
+		start := sequence * dataSize
+		end := start + dataSize
+		if end > len(payload) {
+			end = len(payload)
+		}
+		c := make([]byte, 0, chunkHeaderSize+end-start)
+		c = append(c, chunkMagic...)
+		c = append(c, id...)
+		c = append(c, byte(sequence), byte(count))
+		c = append(c, payload[start:end]...)
+		chunks = append(chunks, c)
+	}
+	return chunks, nil
+}
@@ -0,0 +1,360 @@
+package gelf
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/morfien101/launch/configfile"
+	"github.com/morfien101/launch/processlogger"
+)
+
+func newLogger(t *testing.T, config configfile.GELF, conf configfile.LoggingConfig) *GELF {
+	defaults := configfile.DefaultLoggerDetails{Config: configfile.LoggingConfig{GELF: config}}
+	g := &GELF{}
+	if err := g.RegisterConfig(conf, defaults); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Start(); err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+func decodeMessage(t *testing.T, data []byte) map[string]interface{} {
+	decoded := map[string]interface{}{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Message is not JSON. Got: %s", data)
+	}
+	return decoded
+}
+
+func TestMessageFields(t *testing.T) {
+	conf := configfile.LoggingConfig{
+		ProcessName: "web",
+		GELF:        configfile.GELF{ExtraFields: map[string]string{"team": "payments"}},
+	}
+	defaults := configfile.DefaultLoggerDetails{
+		Config: configfile.LoggingConfig{
+			GELF: configfile.GELF{
+				Address:     "127.0.0.1:12201",
+				Host:        "container1",
+				ExtraFields: map[string]string{"env": "prod", "_team": "default"},
+			},
+		},
+	}
+	g := &GELF{}
+	if err := g.RegisterConfig(conf, defaults); err != nil {
+		t.Fatal(err)
+	}
+
+	out, ok := g.message(processlogger.LogMessage{
+		Source:  "web",
+		Pipe:    processlogger.STDOUT,
+		Config:  conf,
+		Message: "{\"level\":\"warn\",\"msg\":\"careful\"}\n",
+		Time:    time.Unix(1577934245, 123000000),
+		PID:     42,
+		Fields:  map[string]string{"version": "1.2.3", "team": "overridden"},
+	})
+	if !ok {
+		t.Fatal("Message was not created")
+	}
+	decoded := decodeMessage(t, out)
+	want := map[string]interface{}{
+		"version":       "1.1",
+		"host":          "container1",
+		"short_message": `{"level":"warn","msg":"careful"}`,
+		"timestamp":     1577934245.123,
+		"level":         float64(4),
+		"_process":      "web",
+		"_pipe":         "stdout",
+		"_pid":          float64(42),
+		"_env":          "prod",
+		"_team":         "payments",
+		"_version":      "1.2.3",
+	}
+	for key, value := range want {
+		if decoded[key] != value {
+			t.Logf("%s is not as expected. Want: %v, Got: %v", key, value, decoded[key])
+			t.Fail()
+		}
+	}
+
+	out, _ = g.message(processlogger.LogMessage{Source: "web", Pipe: processlogger.STDERR, Config: conf, Message: "first\nsecond\n"})
+	decoded = decodeMessage(t, out)
+	if decoded["short_message"] != "first" || decoded["full_message"] != "first\nsecond" || decoded["level"] != float64(3) {
+		t.Logf("Multi line message is not as expected. Got: %s", out)
+		t.Fail()
+	}
+
+	if _, ok := g.message(processlogger.LogMessage{Config: conf, Message: "\n"}); ok {
+		t.Logf("Empty messages should not be sent")
+		t.Fail()
+	}
+}
+
+func TestChunkedUDP(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	conf := configfile.LoggingConfig{ProcessName: "web"}
+	g := newLogger(t, configfile.GELF{Address: listener.LocalAddr().String(), ChunkSize: 100}, conf)
+	defer func() { <-g.Shutdown() }()
+
+	// Random looking text so that it does not compress into a single packet.
+	text := &strings.Builder{}
+	for i := 0; i < 200; i++ {
+		text.WriteString(time.Duration(i * 7919).String())
+	}
+	g.Submit(processlogger.LogMessage{Source: "web", Pipe: processlogger.STDOUT, Config: conf, Message: text.String()})
+
+	listener.SetReadDeadline(time.Now().Add(time.Second * 2))
+	chunks := map[byte][]byte{}
+	var count byte
+	for count == 0 || len(chunks) < int(count) {
+		packet := make([]byte, 200)
+		n, _, err := listener.ReadFrom(packet)
+		if err != nil {
+			t.Fatalf("Failed to read all the chunks. Error: %s", err)
+		}
+		if n > 100 || !bytes.Equal(packet[:2], chunkMagic) {
+			t.Fatalf("Packet is not a valid chunk. Size: %d", n)
+		}
+		count = packet[11]
+		chunks[packet[10]] = append([]byte{}, packet[chunkHeaderSize:n]...)
+	}
+
+	payload := []byte{}
+	for i := byte(0); i < count; i++ {
+		payload = append(payload, chunks[i]...)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	message, _ := ioutil.ReadAll(zr)
+	if decodeMessage(t, message)["short_message"] != text.String() {
+		t.Logf("Message was not put back together")
+		t.Fail()
+	}
+}
+
+func TestTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		messages := []string{}
+		for len(messages) < 2 {
+			message, err := reader.ReadString(0)
+			if err != nil {
+				return
+			}
+			messages = append(messages, strings.TrimSuffix(message, "\x00"))
+		}
+		received <- messages
+	}()
+
+	conf := configfile.LoggingConfig{ProcessName: "web"}
+	g := newLogger(t, configfile.GELF{Address: listener.Addr().String(), Protocol: tcpProtocol}, conf)
+	defer func() { <-g.Shutdown() }()
+	g.Submit(processlogger.LogMessage{Source: "web", Pipe: processlogger.STDOUT, Config: conf, Message: "one\n"})
+	g.Submit(processlogger.LogMessage{Source: "web", Pipe: processlogger.STDOUT, Config: conf, Message: "two\n"})
+
+	select {
+	case messages := <-received:
+		for i, want := range []string{"one", "two"} {
+			if decodeMessage(t, []byte(messages[i]))["short_message"] != want {
+				t.Logf("Message %d is not as expected. Got: %s", i, messages[i])
+				t.Fail()
+			}
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatal("Timed out waiting for the tcp messages")
+	}
+}
+
+func TestStartWithoutGraylog(t *testing.T) {
+	// Reserve a port and close it so that nothing is listening when Launch starts.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	conf := configfile.LoggingConfig{ProcessName: "web"}
+	g := newLogger(t, configfile.GELF{Address: address, Protocol: tcpProtocol, Timeout: "1s"}, conf)
+	defer func() { <-g.Shutdown() }()
+	g.Submit(processlogger.LogMessage{Source: "web", Pipe: processlogger.STDOUT, Config: conf, Message: "lost\n"})
+
+	listener, err = net.Listen("tcp", address)
+	if err != nil {
+		t.Skipf("Could not listen on %s again. Error: %s", address, err)
+	}
+	defer listener.Close()
+	received := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		message, _ := bufio.NewReader(conn).ReadString(0)
+		received <- strings.TrimSuffix(message, "\x00")
+	}()
+	g.Submit(processlogger.LogMessage{Source: "web", Pipe: processlogger.STDOUT, Config: conf, Message: "found\n"})
+
+	select {
+	case message := <-received:
+		if decodeMessage(t, []byte(message))["short_message"] != "found" {
+			t.Logf("Message is not as expected. Got: %s", message)
+			t.Fail()
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatal("The message was not sent once Graylog was listening")
+	}
+}
+
+func TestWriteTimeout(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	// The connection is accepted but never read so the writes fill the buffers.
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		accepted <- conn
+	}()
+	defer func() {
+		select {
+		case conn := <-accepted:
+			conn.Close()
+		default:
+		}
+	}()
+
+	conf := configfile.LoggingConfig{ProcessName: "web"}
+	g := newLogger(t, configfile.GELF{Address: listener.Addr().String(), Protocol: tcpProtocol, Timeout: "100ms"}, conf)
+	done := make(chan bool)
+	go func() {
+		g.Submit(processlogger.LogMessage{Source: "web", Pipe: processlogger.STDOUT, Config: conf, Message: strings.Repeat("a", 64*1024*1024) + "\n"})
+		close(done)
+	}()
+	select {
+	case <-done:
+		<-g.Shutdown()
+	case <-time.After(time.Second * 5):
+		t.Fatal("Submit should give up once the write timeout passes")
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	tests := []struct {
+		config configfile.GELF
+		conf   configfile.LoggingConfig
+	}{
+		{config: configfile.GELF{}},
+		{config: configfile.GELF{Address: "graylog:12201", Protocol: "http"}},
+		{config: configfile.GELF{Address: "graylog:12201", Compression: "lz4"}},
+		{config: configfile.GELF{Address: "graylog:12201", Protocol: tcpProtocol, Compression: compressionGzip}},
+		{config: configfile.GELF{Address: "graylog:12201", ChunkSize: 10}},
+		{config: configfile.GELF{Address: "graylog:12201", Timeout: "soon"}},
+		{config: configfile.GELF{Address: "graylog:12201", ExtraFields: map[string]string{"id": "1"}}},
+		{
+			config: configfile.GELF{Address: "graylog:12201"},
+			conf:   configfile.LoggingConfig{GELF: configfile.GELF{ExtraFields: map[string]string{"bad field": "1"}}},
+		},
+		{
+			config: configfile.GELF{Address: "graylog:12201"},
+			conf:   configfile.LoggingConfig{Fields: map[string]string{"id": "1"}},
+		},
+		{
+			config: configfile.GELF{Address: "graylog:12201"},
+			conf:   configfile.LoggingConfig{GELF: configfile.GELF{Address: "other:12201"}},
+		},
+	}
+	for _, test := range tests {
+		g := &GELF{}
+		defaults := configfile.DefaultLoggerDetails{Config: configfile.LoggingConfig{GELF: test.config}}
+		if err := g.RegisterConfig(test.conf, defaults); err == nil {
+			t.Logf("Config should be rejected: %+v %+v", test.config, test.conf.GELF)
+			t.Fail()
+		}
+	}
+}
+
+func TestParsedMessage(t *testing.T) {
+	conf := configfile.LoggingConfig{ProcessName: "web"}
+	g := &GELF{}
+	defaults := configfile.DefaultLoggerDetails{Config: configfile.LoggingConfig{GELF: configfile.GELF{Address: "127.0.0.1:12201"}}}
+	if err := g.RegisterConfig(conf, defaults); err != nil {
+		t.Fatal(err)
+	}
+
+	out, ok := g.message(processlogger.LogMessage{
+		Source:  "web",
+		Pipe:    processlogger.STDOUT,
+		Config:  conf,
+		Message: `{"msg":"done","level":"warn","status":200,"ok":true,"bad key":"x","process":"app"}` + "\n",
+		Parsed: &processlogger.Parsed{
+			Fields: map[string]interface{}{
+				"msg":     "done",
+				"level":   "warn",
+				"status":  json.Number("200"),
+				"ok":      true,
+				"bad key": "x",
+				"process": "app",
+			},
+			MessageKey: "msg",
+			Message:    "done",
+		},
+	})
+	if !ok {
+		t.Fatal("Message was not created")
+	}
+	decoded := decodeMessage(t, out)
+	want := map[string]interface{}{
+		"short_message": "done",
+		"level":         float64(4),
+		"_status":       float64(200),
+		"_ok":           "true",
+		"_level":        "warn",
+		"_process":      "web",
+	}
+	for key, value := range want {
+		if decoded[key] != value {
+			t.Logf("%s is not as expected. Want: %v, Got: %v", key, value, decoded[key])
+			t.Fail()
+		}
+	}
+	if _, ok := decoded["_msg"]; ok {
+		t.Logf("The message key should not be repeated. Got: %s", out)
+		t.Fail()
+	}
+}
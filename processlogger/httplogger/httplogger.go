@@ -0,0 +1,268 @@
+// Package httplogger ships logs to an HTTP end point in batches.
+// Each message is turned into a JSON object and the batch is posted as
+// newline delimited JSON or as a JSON array.
+// The connection and batching settings are taken from the default logger
+// configuration as all the processes share the same batches.
+package httplogger
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/morfien101/launch/configfile"
+	"github.com/morfien101/launch/processlogger"
+	"github.com/morfien101/launch/processlogger/batcher"
+	"github.com/morfien101/launch/processlogger/lineformat"
+	"github.com/morfien101/launch/processlogger/loglevel"
+)
+
+const (
+	// LoggerTag is used to identify the logger
+	LoggerTag = "http"
+
+	formatNDJSON    = "ndjson"
+	formatJSONArray = "json_array"
+
+	defaultBatchSize     = 500
+	defaultBatchBytes    = 1024 * 1024
+	defaultFlushInterval = time.Second * 5
+	defaultTimeout       = time.Second * 10
+	defaultMaxRetries    = 3
+	defaultRetryBackoff  = time.Millisecond * 500
+)
+
+var (
+	contentTypes = map[string]string{
+		formatNDJSON:    "application/x-ndjson",
+		formatJSONArray: "application/json",
+	}
+)
+
+func init() {
+	processlogger.RegisterLogger(LoggerTag, func() processlogger.Logger {
+		return &HTTPLogger{}
+	})
+}
+
+// HTTPLogger batches log messages and posts them to an HTTP end point.
+type HTTPLogger struct {
+	config  configfile.HTTP
+	client  *http.Client
+	batcher *batcher.Batcher
+
+	flushInterval time.Duration
+	retryBackoff  time.Duration
+	maxRetries    int
+
+	// formatters holds the JSON formatter for each process keyed by process name.
+	formatters map[string]*lineformat.Formatter
+	// sleep is used to wait between retries. It is replaced in tests.
+	sleep func(time.Duration)
+}
+
+// RegisterConfig validates the HTTP settings and creates the formatter for
+// the process. The settings can not be set on a process as all the processes
+// share the same batches.
+func (hl *HTTPLogger) RegisterConfig(conf configfile.LoggingConfig, defaults configfile.DefaultLoggerDetails) error {
+	if !reflect.DeepEqual(conf.HTTP, configfile.HTTP{}) {
+		return fmt.Errorf("process %s has http settings. The http logger settings can only be set in the default_logger_config", conf.ProcessName)
+	}
+	if err := hl.configure(defaults.Config.HTTP); err != nil {
+		return fmt.Errorf("the http logger configuration is invalid. Error: %s", err)
+	}
+
+	detector, err := loglevel.NewDetector(conf.LevelDetection, defaults.Config.LevelDetection)
+	if err != nil {
+		return fmt.Errorf("process %s has an invalid level_detection configuration. Error: %s", conf.ProcessName, err)
+	}
+	formatter, err := lineformat.New(lineformat.JSON, detector)
+	if err != nil {
+		return err
+	}
+	if hl.formatters == nil {
+		hl.formatters = make(map[string]*lineformat.Formatter)
+	}
+	hl.formatters[conf.ProcessName] = formatter
+	return nil
+}
+
+// configure checks the settings and fills in the defaults.
+func (hl *HTTPLogger) configure(config configfile.HTTP) error {
+	if config.URL == "" {
+		return fmt.Errorf("url is required")
+	}
+	endpoint, err := url.Parse(config.URL)
+	if err != nil {
+		return fmt.Errorf("url is not valid. Error: %s", err)
+	}
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" {
+		return fmt.Errorf("url must use http or https. Got: %s", config.URL)
+	}
+
+	if config.Format == "" {
+		config.Format = formatNDJSON
+	}
+	if _, ok := contentTypes[config.Format]; !ok {
+		return fmt.Errorf("format must be %s or %s. Got: %s", formatNDJSON, formatJSONArray, config.Format)
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaultBatchSize
+	}
+	if config.BatchBytes == 0 {
+		config.BatchBytes = defaultBatchBytes
+	}
+	maxRetries := defaultMaxRetries
+	if config.MaxRetries != nil {
+		maxRetries = *config.MaxRetries
+	}
+	if maxRetries < 0 {
+		return fmt.Errorf("max_retries can not be negative. Got: %d", maxRetries)
+	}
+
+	flushInterval, err := batcher.ParseDuration("flush_interval", config.FlushInterval, defaultFlushInterval)
+	if err != nil {
+		return err
+	}
+	timeout, err := batcher.ParseDuration("timeout", config.Timeout, defaultTimeout)
+	if err != nil {
+		return err
+	}
+	retryBackoff, err := batcher.ParseDuration("retry_backoff", config.RetryBackoff, defaultRetryBackoff)
+	if err != nil {
+		return err
+	}
+
+	hl.config = config
+	hl.flushInterval = flushInterval
+	hl.retryBackoff = retryBackoff
+	hl.maxRetries = maxRetries
+	hl.client = &http.Client{Timeout: timeout}
+	return nil
+}
+
+// Start starts the batching of messages.
+func (hl *HTTPLogger) Start() error {
+	if hl.sleep == nil {
+		hl.sleep = time.Sleep
+	}
+	hl.batcher = batcher.New(
+		batcher.Config{
+			MaxItems: hl.config.BatchSize,
+			MaxBytes: int(hl.config.BatchBytes.Bytes()),
+			Interval: hl.flushInterval,
+			Name:     "http logger",
+		},
+		hl.send,
+	)
+	hl.batcher.Start()
+	return nil
+}
+
+// Shutdown sends the messages that are waiting and stops the logger.
+func (hl *HTTPLogger) Shutdown() chan error {
+	c := make(chan error, 1)
+	go func() {
+		defer close(c)
+		if hl.batcher == nil {
+			c <- nil
+			return
+		}
+		if err := hl.batcher.Stop(); err != nil {
+			c <- fmt.Errorf("failed to send the last batch of logs. Error: %s", err)
+			return
+		}
+		c <- nil
+	}()
+	return c
+}
+
+// Submit turns the message into JSON and adds it to the batch.
+func (hl *HTTPLogger) Submit(msg processlogger.LogMessage) {
+	formatter, ok := hl.formatters[msg.Config.ProcessName]
+	if !ok {
+		formatter, _ = lineformat.New(lineformat.JSON, nil)
+	}
+	line := strings.TrimSuffix(formatter.Format(msg), "\n")
+	hl.batcher.Add([]byte(line))
+}
+
+// send posts a batch. Requests that fail to connect or get a 5xx or 429
+// response are retried with an increasing wait between each try.
+func (hl *HTTPLogger) send(batch [][]byte) error {
+	body, err := hl.body(batch)
+	if err != nil {
+		return err
+	}
+
+	retry := batcher.Retry{MaxRetries: hl.maxRetries, Backoff: hl.retryBackoff, Sleep: hl.sleep}
+	return retry.Send(len(batch), func() (bool, error) {
+		return hl.post(body)
+	})
+}
+
+// body joins the lines in the configured format and compresses them if
+// required.
+func (hl *HTTPLogger) body(batch [][]byte) ([]byte, error) {
+	var joined []byte
+	if hl.config.Format == formatJSONArray {
+		joined = append([]byte("["), bytes.Join(batch, []byte(","))...)
+		joined = append(joined, ']')
+	} else {
+		joined = append(bytes.Join(batch, []byte("\n")), '\n')
+	}
+	if !hl.config.Gzip {
+		return joined, nil
+	}
+
+	compressed := &bytes.Buffer{}
+	zw := gzip.NewWriter(compressed)
+	if _, err := zw.Write(joined); err != nil {
+		return nil, fmt.Errorf("failed to compress batch. Error: %s", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress batch. Error: %s", err)
+	}
+	return compressed.Bytes(), nil
+}
+
+// post makes a single request. It tells the caller if the request can be
+// tried again.
+func (hl *HTTPLogger) post(body []byte) (bool, error) {
+	request, err := http.NewRequest(http.MethodPost, hl.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Content-Type", contentTypes[hl.config.Format])
+	request.Header.Set("User-Agent", "launch")
+	if hl.config.Gzip {
+		request.Header.Set("Content-Encoding", "gzip")
+	}
+	for key, value := range hl.config.Headers {
+		request.Header.Set(key, value)
+	}
+
+	response, err := hl.client.Do(request)
+	if err != nil {
+		return true, err
+	}
+	defer response.Body.Close()
+	// Read the body so that the connection can be reused.
+	io.Copy(ioutil.Discard, response.Body)
+
+	switch {
+	case response.StatusCode < 300:
+		return false, nil
+	case response.StatusCode >= 500 || response.StatusCode == http.StatusTooManyRequests:
+		return true, fmt.Errorf("got status %s", response.Status)
+	default:
+		return false, fmt.Errorf("got status %s", response.Status)
+	}
+}
@@ -0,0 +1,246 @@
+package httplogger
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/morfien101/launch/configfile"
+	"github.com/morfien101/launch/processlogger"
+)
+
+// receiver is a test server that records the requests it is sent.
+type receiver struct {
+	sync.Mutex
+	bodies   []string
+	headers  []http.Header
+	statuses []int
+	server   *httptest.Server
+}
+
+func newReceiver(t *testing.T, statuses ...int) *receiver {
+	r := &receiver{statuses: statuses}
+	r.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var reader io.Reader = req.Body
+		if req.Header.Get("Content-Encoding") == "gzip" {
+			zr, err := gzip.NewReader(req.Body)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			reader = zr
+		}
+		body, _ := ioutil.ReadAll(reader)
+
+		r.Lock()
+		defer r.Unlock()
+		r.bodies = append(r.bodies, string(body))
+		r.headers = append(r.headers, req.Header.Clone())
+		status := http.StatusOK
+		if len(r.statuses) > 0 {
+			status = r.statuses[0]
+			r.statuses = r.statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	return r
+}
+
+func (r *receiver) received() ([]string, []http.Header) {
+	r.Lock()
+	defer r.Unlock()
+	return append([]string{}, r.bodies...), append([]http.Header{}, r.headers...)
+}
+
+func startLogger(t *testing.T, config configfile.HTTP) (*HTTPLogger, configfile.LoggingConfig) {
+	conf := configfile.LoggingConfig{Engine: LoggerTag, ProcessName: "web"}
+	defaults := configfile.DefaultLoggerDetails{
+		Config: configfile.LoggingConfig{HTTP: config},
+	}
+	hl := &HTTPLogger{sleep: func(time.Duration) {}}
+	if err := hl.RegisterConfig(conf, defaults); err != nil {
+		t.Fatal(err)
+	}
+	if err := hl.Start(); err != nil {
+		t.Fatal(err)
+	}
+	return hl, conf
+}
+
+func submit(hl *HTTPLogger, conf configfile.LoggingConfig, messages ...string) {
+	for _, message := range messages {
+		hl.Submit(processlogger.LogMessage{
+			Source:  "web",
+			Pipe:    processlogger.STDOUT,
+			Config:  conf,
+			Message: message + "\n",
+		})
+	}
+}
+
+func TestNDJSONBatches(t *testing.T) {
+	r := newReceiver(t)
+	defer r.server.Close()
+
+	hl, conf := startLogger(t, configfile.HTTP{
+		URL:           r.server.URL,
+		BatchSize:     2,
+		FlushInterval: "1h",
+		Headers:       map[string]string{"Authorization": "Bearer token"},
+	})
+	submit(hl, conf, "one", "two", "three")
+	if err := <-hl.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+
+	bodies, headers := r.received()
+	if len(bodies) != 2 {
+		t.Fatalf("Expected 2 requests. Got: %d", len(bodies))
+	}
+	lines := strings.Split(strings.TrimSuffix(bodies[0], "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines in the first batch. Got: %q", bodies[0])
+	}
+	decoded := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(lines[0]), &decoded); err != nil {
+		t.Fatalf("Line is not JSON. Got: %s", lines[0])
+	}
+	if decoded["message"] != "one" || decoded["source"] != "web" {
+		t.Logf("Line is not as expected. Got: %s", lines[0])
+		t.Fail()
+	}
+	if headers[0].Get("Authorization") != "Bearer token" {
+		t.Logf("Custom header was not sent. Got: %v", headers[0])
+		t.Fail()
+	}
+	if headers[0].Get("Content-Type") != contentTypes[formatNDJSON] {
+		t.Logf("Content type is not as expected. Got: %s", headers[0].Get("Content-Type"))
+		t.Fail()
+	}
+}
+
+func TestGzipJSONArray(t *testing.T) {
+	r := newReceiver(t)
+	defer r.server.Close()
+
+	hl, conf := startLogger(t, configfile.HTTP{
+		URL:    r.server.URL,
+		Format: formatJSONArray,
+		Gzip:   true,
+	})
+	submit(hl, conf, "one", "{\"level\": \"warn\", \"msg\": \"two\"}")
+	if err := <-hl.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+
+	bodies, headers := r.received()
+	if len(bodies) != 1 {
+		t.Fatalf("Expected 1 request. Got: %d", len(bodies))
+	}
+	if headers[0].Get("Content-Encoding") != "gzip" {
+		t.Logf("Body was not marked as gzip")
+		t.Fail()
+	}
+	decoded := []map[string]interface{}{}
+	if err := json.Unmarshal([]byte(bodies[0]), &decoded); err != nil {
+		t.Fatalf("Body is not a JSON array. Got: %s", bodies[0])
+	}
+	if len(decoded) != 2 || decoded[1]["level"] != "warning" {
+		t.Logf("JSON array is not as expected. Got: %s", bodies[0])
+		t.Fail()
+	}
+}
+
+func TestRetries(t *testing.T) {
+	r := newReceiver(t, http.StatusServiceUnavailable, http.StatusTooManyRequests)
+	defer r.server.Close()
+
+	hl, conf := startLogger(t, configfile.HTTP{URL: r.server.URL, MaxRetries: retries(2)})
+	waits := make([]time.Duration, 0)
+	hl.sleep = func(d time.Duration) { waits = append(waits, d) }
+	submit(hl, conf, "one")
+	if err := <-hl.Shutdown(); err != nil {
+		t.Fatalf("The batch should be sent after retrying. Error: %s", err)
+	}
+
+	bodies, _ := r.received()
+	if len(bodies) != 3 {
+		t.Logf("Expected 3 attempts. Got: %d", len(bodies))
+		t.Fail()
+	}
+	if len(waits) != 2 || waits[0] != defaultRetryBackoff || waits[1] != defaultRetryBackoff*2 {
+		t.Logf("Backoff is not as expected. Got: %v", waits)
+		t.Fail()
+	}
+}
+
+func retries(n int) *int {
+	return &n
+}
+
+func TestRetriesTurnedOff(t *testing.T) {
+	r := newReceiver(t, http.StatusServiceUnavailable)
+	defer r.server.Close()
+
+	hl, conf := startLogger(t, configfile.HTTP{URL: r.server.URL, MaxRetries: retries(0)})
+	submit(hl, conf, "one")
+	if err := <-hl.Shutdown(); err == nil {
+		t.Logf("A failed batch should return an error")
+		t.Fail()
+	}
+	if bodies, _ := r.received(); len(bodies) != 1 {
+		t.Logf("max_retries of 0 should not retry. Got: %d attempts", len(bodies))
+		t.Fail()
+	}
+}
+
+func TestProcessSettingsRejected(t *testing.T) {
+	hl := &HTTPLogger{}
+	defaults := configfile.DefaultLoggerDetails{Config: configfile.LoggingConfig{HTTP: configfile.HTTP{URL: "http://example.com"}}}
+	conf := configfile.LoggingConfig{ProcessName: "web", HTTP: configfile.HTTP{BatchSize: 10}}
+	if err := hl.RegisterConfig(conf, defaults); err == nil {
+		t.Logf("http settings on a process should be rejected")
+		t.Fail()
+	}
+}
+
+func TestNoRetryOnClientError(t *testing.T) {
+	r := newReceiver(t, http.StatusBadRequest)
+	defer r.server.Close()
+
+	hl, conf := startLogger(t, configfile.HTTP{URL: r.server.URL})
+	submit(hl, conf, "one")
+	if err := <-hl.Shutdown(); err == nil {
+		t.Logf("A rejected batch should return an error")
+		t.Fail()
+	}
+	if bodies, _ := r.received(); len(bodies) != 1 {
+		t.Logf("Client errors should not be retried. Got: %d attempts", len(bodies))
+		t.Fail()
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	configs := []configfile.HTTP{
+		{},
+		{URL: "ftp://example.com"},
+		{URL: "http://example.com", Format: "xml"},
+		{URL: "http://example.com", FlushInterval: "often"},
+		{URL: "http://example.com", MaxRetries: retries(-1)},
+	}
+	for _, config := range configs {
+		hl := &HTTPLogger{}
+		defaults := configfile.DefaultLoggerDetails{Config: configfile.LoggingConfig{HTTP: config}}
+		if err := hl.RegisterConfig(configfile.LoggingConfig{}, defaults); err == nil {
+			t.Logf("Config should be rejected: %+v", config)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,320 @@
+// Package journald writes logs to the local systemd journal using the native
+// journal protocol. Each message is sent as a datagram of journal fields to
+// the journal socket.
+// See https://systemd.io/JOURNAL_NATIVE_PROTOCOL/
+package journald
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/morfien101/launch/configfile"
+	"github.com/morfien101/launch/processlogger"
+	"github.com/morfien101/launch/processlogger/lineformat"
+	"github.com/morfien101/launch/processlogger/loglevel"
+)
+
+const (
+	// LoggerTag is used to identify the logger
+	LoggerTag = "journald"
+
+	defaultSocket = "/run/systemd/journal/socket"
+	// processManagerIdentifier is used for the process manager as it has no process name.
+	processManagerIdentifier = "launch"
+	// maxMessageSize keeps the datagram inside the default socket buffer size.
+	// Larger messages are truncated.
+	maxMessageSize = 128 * 1024
+)
+
+var (
+	// fieldNamePattern is the field names that the journal accepts. Names
+	// starting with _ are trusted fields that only journald can set.
+	fieldNamePattern = regexp.MustCompile(`^[A-Z][A-Z0-9_]{0,63}$`)
+	// launchFields are written by Launch so parsed keys and extra fields can
+	// not use them.
+	launchFields = map[string]bool{
+		"MESSAGE":             true,
+		"PRIORITY":            true,
+		"SYSLOG_IDENTIFIER":   true,
+		"SYSLOG_PID":          true,
+		"LAUNCH_PROCESS":      true,
+		"LAUNCH_PIPE":         true,
+		"LAUNCH_PROCESS_TYPE": true,
+		"LAUNCH_FILE":         true,
+		"LAUNCH_SEQUENCE":     true,
+	}
+)
+
+func init() {
+	processlogger.RegisterLogger(LoggerTag, func() processlogger.Logger {
+		return &Journald{}
+	})
+}
+
+// Journald sends log messages to the systemd journal.
+type Journald struct {
+	socket string
+
+	// formatters are used to work out the level of each message.
+	formatters map[string]*lineformat.Formatter
+	// identifiers holds the SYSLOG_IDENTIFIER for each process keyed by process name.
+	identifiers map[string]string
+	// fields holds the extra fields for each process keyed by process name.
+	fields map[string]map[string]string
+
+	connLock sync.Mutex
+	conn     *net.UnixConn
+}
+
+// RegisterConfig validates the extra fields and works out the identifier for
+// the process.
+func (j *Journald) RegisterConfig(conf configfile.LoggingConfig, defaults configfile.DefaultLoggerDetails) error {
+	j.socket = defaults.Config.Journald.Socket
+	if j.socket == "" {
+		j.socket = defaultSocket
+	}
+
+	detector, err := loglevel.NewDetector(conf.LevelDetection, defaults.Config.LevelDetection)
+	if err != nil {
+		return fmt.Errorf("process %s has an invalid level_detection configuration. Error: %s", conf.ProcessName, err)
+	}
+	formatter, err := lineformat.New(lineformat.Raw, detector)
+	if err != nil {
+		return err
+	}
+
+	fields := make(map[string]string)
+	for _, extra := range []map[string]string{defaults.Config.Journald.Fields, conf.Journald.Fields} {
+		for key, value := range extra {
+			name, err := fieldName(key)
+			if err != nil {
+				return fmt.Errorf("process %s has an invalid journald field. Error: %s", conf.ProcessName, err)
+			}
+			fields[name] = value
+		}
+	}
+
+	if j.formatters == nil {
+		j.formatters = make(map[string]*lineformat.Formatter)
+		j.identifiers = make(map[string]string)
+		j.fields = make(map[string]map[string]string)
+	}
+	j.formatters[conf.ProcessName] = formatter
+	j.identifiers[conf.ProcessName] = identifier(conf, defaults.Config.Journald)
+	j.fields[conf.ProcessName] = fields
+	return nil
+}
+
+// identifier works out the SYSLOG_IDENTIFIER for a process. The process
+// configuration wins over the default configuration.
+func identifier(conf configfile.LoggingConfig, defaults configfile.Journald) string {
+	switch {
+	case conf.Journald.SyslogIdentifier != "":
+		return conf.Journald.SyslogIdentifier
+	case defaults.SyslogIdentifier != "":
+		return defaults.SyslogIdentifier
+	case conf.ProcessName != "":
+		return conf.ProcessName
+	}
+	return processManagerIdentifier
+}
+
+// fieldName upper cases an extra field name and checks that the journal will
+// accept it and that Launch does not already write it.
+func fieldName(key string) (string, error) {
+	name := strings.ToUpper(key)
+	if !fieldNamePattern.MatchString(name) {
+		return "", fmt.Errorf("%s is not a valid journal field name. Use letters, numbers and _ and start with a letter", key)
+	}
+	if launchFields[name] {
+		return "", fmt.Errorf("%s is written by Launch and can not be used as a journal field", key)
+	}
+	return name, nil
+}
+
+// truncate cuts text down to at most size bytes without splitting a UTF-8
+// character.
+func truncate(text string, size int) string {
+	for size > 0 && !utf8.RuneStart(text[size]) {
+		size--
+	}
+	return text[:size]
+}
+
+// Start connects to the journal socket.
+func (j *Journald) Start() error {
+	j.connLock.Lock()
+	defer j.connLock.Unlock()
+	if err := j.connect(); err != nil {
+		return fmt.Errorf("failed to connect to the journal socket %s. Error: %s", j.socket, err)
+	}
+	return nil
+}
+
+// Shutdown closes the connection to the journal.
+func (j *Journald) Shutdown() chan error {
+	c := make(chan error, 1)
+	go func() {
+		defer close(c)
+		j.connLock.Lock()
+		defer j.connLock.Unlock()
+		if j.conn == nil {
+			c <- nil
+			return
+		}
+		err := j.conn.Close()
+		j.conn = nil
+		if err != nil {
+			c <- fmt.Errorf("failed to close the journal connection. Error: %s", err)
+			return
+		}
+		c <- nil
+	}()
+	return c
+}
+
+// Submit turns the message into journal fields and sends it.
+func (j *Journald) Submit(msg processlogger.LogMessage) {
+	if err := j.send(j.entry(msg)); err != nil {
+		processlogger.ReportError("failed to send message to the journal. Error: %s\n", err)
+	}
+}
+
+// entry encodes the fields of a log message in the native journal format.
+func (j *Journald) entry(msg processlogger.LogMessage) []byte {
+	formatter, ok := j.formatters[msg.Config.ProcessName]
+	if !ok {
+		formatter, _ = lineformat.New(lineformat.Raw, nil)
+	}
+	name, ok := j.identifiers[msg.Config.ProcessName]
+	if !ok {
+		name = msg.Source
+	}
+	text := strings.TrimRight(msg.Message, "\n")
+	level := formatter.Level(msg, text)
+	if msg.Parsed != nil && msg.Parsed.MessageKey != "" {
+		text = msg.Parsed.Message
+	}
+	if len(text) > maxMessageSize {
+		text = truncate(text, maxMessageSize)
+	}
+
+	b := &bytes.Buffer{}
+	writeField(b, "MESSAGE", text)
+	writeField(b, "PRIORITY", strconv.Itoa(int(level)))
+	writeField(b, "SYSLOG_IDENTIFIER", name)
+	writeField(b, "LAUNCH_PROCESS", msg.Source)
+	writeField(b, "LAUNCH_PIPE", msg.Pipe.Name())
+	if msg.PID != 0 {
+		writeField(b, "SYSLOG_PID", strconv.Itoa(msg.PID))
+	}
+	if msg.ProcessType != "" {
+		writeField(b, "LAUNCH_PROCESS_TYPE", msg.ProcessType)
+	}
+	if msg.File != "" {
+		writeField(b, "LAUNCH_FILE", msg.File)
+	}
+	if msg.Sequence != 0 {
+		writeField(b, "LAUNCH_SEQUENCE", strconv.FormatUint(msg.Sequence, 10))
+	}
+
+	// The journald fields win over the fields from the logging config, which
+	// win over the keys of a parsed message.
+	fields := make(map[string]string)
+	if msg.Parsed != nil {
+		for key, value := range msg.Parsed.Fields {
+			name := parsedFieldName(key)
+			if key != msg.Parsed.MessageKey && fieldNamePattern.MatchString(name) && !launchFields[name] {
+				fields[name] = processlogger.ValueText(value)
+			}
+		}
+	}
+	for key, value := range msg.Fields {
+		if name, err := fieldName(key); err == nil {
+			fields[name] = value
+		}
+	}
+	for name, value := range j.fields[msg.Config.ProcessName] {
+		fields[name] = value
+	}
+
+	// Sorted so that the entries are the same each time.
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		writeField(b, name, fields[name])
+	}
+	return b.Bytes()
+}
+
+// parsedFieldName turns the key of a parsed message into a journal field name
+// by upper casing it and replacing the characters that are not allowed.
+func parsedFieldName(key string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		}
+		return '_'
+	}, key)
+}
+
+// writeField writes NAME=value. Values with new lines are written as the
+// name, a new line, the length as a little endian uint64 and then the value.
+func writeField(b *bytes.Buffer, name, value string) {
+	b.WriteString(name)
+	if strings.Contains(value, "\n") {
+		b.WriteByte('\n')
+		binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	} else {
+		b.WriteByte('=')
+	}
+	b.WriteString(value)
+	b.WriteByte('\n')
+}
+
+// send writes the entry to the journal. The connection is remade once if the
+// write fails as journald might have been restarted.
+func (j *Journald) send(entry []byte) error {
+	j.connLock.Lock()
+	defer j.connLock.Unlock()
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if err = j.connect(); err != nil {
+			continue
+		}
+		if _, err = j.conn.Write(entry); err == nil {
+			return nil
+		}
+		j.conn.Close()
+		j.conn = nil
+	}
+	return err
+}
+
+// connect dials the journal socket if there is no connection. The caller must
+// hold connLock.
+func (j *Journald) connect() error {
+	if j.conn != nil {
+		return nil
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: j.socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	j.conn = conn
+	return nil
+}
@@ -0,0 +1,242 @@
+package journald
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/morfien101/launch/configfile"
+	"github.com/morfien101/launch/processlogger"
+)
+
+// parseEntry reads the fields of a native journal entry.
+func parseEntry(t *testing.T, data []byte) map[string]string {
+	fields := map[string]string{}
+	for len(data) > 0 {
+		end := bytes.IndexByte(data, '\n')
+		if end < 0 {
+			t.Fatalf("Field is not terminated. Got: %q", data)
+		}
+		line := data[:end]
+		if equals := bytes.IndexByte(line, '='); equals >= 0 {
+			fields[string(line[:equals])] = string(line[equals+1:])
+			data = data[end+1:]
+			continue
+		}
+		data = data[end+1:]
+		size := binary.LittleEndian.Uint64(data[:8])
+		fields[string(line)] = string(data[8 : 8+size])
+		data = data[8+size+1:]
+	}
+	return fields
+}
+
+// listen creates a stand in for the journal socket.
+func listen(t *testing.T) *net.UnixConn {
+	socket := filepath.Join(t.TempDir(), "socket")
+	listener, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return listener
+}
+
+func TestNativeProtocol(t *testing.T) {
+	listener := listen(t)
+	defer listener.Close()
+
+	conf := configfile.LoggingConfig{
+		ProcessName: "web",
+		Journald:    configfile.Journald{Fields: map[string]string{"team": "payments"}},
+	}
+	defaults := configfile.DefaultLoggerDetails{
+		Config: configfile.LoggingConfig{
+			Journald: configfile.Journald{
+				Socket: listener.LocalAddr().String(),
+				Fields: map[string]string{"ENV": "prod", "TEAM": "default"},
+			},
+		},
+	}
+	j := &Journald{}
+	if err := j.RegisterConfig(conf, defaults); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { <-j.Shutdown() }()
+
+	j.Submit(processlogger.LogMessage{Source: "web", Pipe: processlogger.STDERR, Config: conf, Message: "first\nsecond\n", PID: 42, Fields: map[string]string{"version": "1.2.3", "env": "dev"}})
+	j.Submit(processlogger.LogMessage{Source: "web", Pipe: processlogger.STDOUT, Config: conf, Message: "{\"level\":\"warn\"}\n"})
+
+	tests := []map[string]string{
+		{
+			"MESSAGE":           "first\nsecond",
+			"PRIORITY":          "3",
+			"SYSLOG_IDENTIFIER": "web",
+			"SYSLOG_PID":        "42",
+			"LAUNCH_PROCESS":    "web",
+			"LAUNCH_PIPE":       "stderr",
+			"ENV":               "prod",
+			"TEAM":              "payments",
+			"VERSION":           "1.2.3",
+		},
+		{
+			"MESSAGE":  `{"level":"warn"}`,
+			"PRIORITY": "4",
+		},
+	}
+	listener.SetReadDeadline(time.Now().Add(time.Second * 2))
+	for i, want := range tests {
+		packet := make([]byte, 4096)
+		n, err := listener.Read(packet)
+		if err != nil {
+			t.Fatalf("Failed to read entry %d. Error: %s", i, err)
+		}
+		fields := parseEntry(t, packet[:n])
+		for key, value := range want {
+			if fields[key] != value {
+				t.Logf("Entry %d %s is not as expected. Want: %q, Got: %q", i, key, value, fields[key])
+				t.Fail()
+			}
+		}
+	}
+}
+
+func TestIdentifier(t *testing.T) {
+	tests := []struct {
+		conf     configfile.LoggingConfig
+		defaults configfile.Journald
+		want     string
+	}{
+		{conf: configfile.LoggingConfig{ProcessName: "web"}, want: "web"},
+		{conf: configfile.LoggingConfig{}, want: "launch"},
+		{conf: configfile.LoggingConfig{ProcessName: "web"}, defaults: configfile.Journald{SyslogIdentifier: "app"}, want: "app"},
+		{
+			conf:     configfile.LoggingConfig{ProcessName: "web", Journald: configfile.Journald{SyslogIdentifier: "svc"}},
+			defaults: configfile.Journald{SyslogIdentifier: "app"},
+			want:     "svc",
+		},
+	}
+	for _, test := range tests {
+		if got := identifier(test.conf, test.defaults); got != test.want {
+			t.Logf("Identifier is not as expected. Want: %s, Got: %s", test.want, got)
+			t.Fail()
+		}
+	}
+}
+
+func TestInvalidFields(t *testing.T) {
+	for _, name := range []string{"_PID", "1ST", "bad field", "a-b", "message", "LAUNCH_PIPE"} {
+		j := &Journald{}
+		conf := configfile.LoggingConfig{Journald: configfile.Journald{Fields: map[string]string{name: "x"}}}
+		if err := j.RegisterConfig(conf, configfile.DefaultLoggerDetails{}); err == nil {
+			t.Logf("Field %s should be rejected", name)
+			t.Fail()
+		}
+	}
+}
+
+func TestStartWithoutJournal(t *testing.T) {
+	j := &Journald{}
+	defaults := configfile.DefaultLoggerDetails{
+		Config: configfile.LoggingConfig{Journald: configfile.Journald{Socket: filepath.Join(t.TempDir(), "missing")}},
+	}
+	if err := j.RegisterConfig(configfile.LoggingConfig{}, defaults); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Start(); err == nil {
+		t.Logf("Start should fail when the journal socket does not exist")
+		t.Fail()
+	}
+}
+
+func TestParsedEntry(t *testing.T) {
+	j := &Journald{}
+	msg := processlogger.LogMessage{
+		Source:  "web",
+		Pipe:    processlogger.STDOUT,
+		Message: `{"msg":"done","level":"warn","http.status":200,"user":{"id":"42"},"priority":"high"}` + "\n",
+		Fields:  map[string]string{"user": "from fields"},
+		Parsed: &processlogger.Parsed{
+			Fields: map[string]interface{}{
+				"msg":         "done",
+				"level":       "warn",
+				"http.status": json.Number("200"),
+				"user":        map[string]interface{}{"id": "42"},
+				"priority":    "high",
+				"_private":    "x",
+			},
+			MessageKey: "msg",
+			Message:    "done",
+		},
+	}
+	fields := parseEntry(t, j.entry(msg))
+	want := map[string]string{
+		"MESSAGE":     "done",
+		"PRIORITY":    "4",
+		"LEVEL":       "warn",
+		"HTTP_STATUS": "200",
+		"USER":        "from fields",
+	}
+	for key, value := range want {
+		if fields[key] != value {
+			t.Logf("%s is not as expected. Want: %q, Got: %q", key, value, fields[key])
+			t.Fail()
+		}
+	}
+	for _, key := range []string{"MSG", "_PRIVATE"} {
+		if _, ok := fields[key]; ok {
+			t.Logf("%s should not be written", key)
+			t.Fail()
+		}
+	}
+}
+
+func TestLaunchFieldsWin(t *testing.T) {
+	j := &Journald{}
+	msg := processlogger.LogMessage{
+		Source:  "web",
+		Pipe:    processlogger.STDERR,
+		Message: "real\n",
+		Fields:  map[string]string{"message": "spoofed", "priority": "7", "app": "shop"},
+	}
+	entry := j.entry(msg)
+	fields := parseEntry(t, entry)
+	want := map[string]string{"MESSAGE": "real", "PRIORITY": "3", "APP": "shop"}
+	for key, value := range want {
+		if fields[key] != value {
+			t.Logf("%s is not as expected. Want: %q, Got: %q", key, value, fields[key])
+			t.Fail()
+		}
+	}
+	if count := bytes.Count(entry, []byte("PRIORITY=")); count != 1 {
+		t.Logf("PRIORITY should be written once. Got: %d", count)
+		t.Fail()
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	// é is two bytes so cutting at 4 would split the second one.
+	tests := []struct {
+		text string
+		size int
+		want string
+	}{
+		{text: "abcdef", size: 4, want: "abcd"},
+		{text: "aééb", size: 4, want: "aé"},
+		{text: "aééb", size: 5, want: "aéé"},
+	}
+	for _, test := range tests {
+		got := truncate(test.text, test.size)
+		if got != test.want || !utf8.ValidString(got) {
+			t.Logf("Truncated text is not as expected. Want: %q, Got: %q", test.want, got)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,484 @@
+// Package kafka produces logs to a Kafka topic. Each message is sent as a JSON
+// record with the same fields as the json line format. Batching, compression
+// and retries are handled by the producer.
+// The connection settings are taken from the default logger configuration as
+// all the processes share the same producer. The topic and key can be set on
+// each process.
+package kafka
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/morfien101/launch/configfile"
+	"github.com/morfien101/launch/processlogger"
+	"github.com/morfien101/launch/processlogger/batcher"
+	"github.com/morfien101/launch/processlogger/lineformat"
+	"github.com/morfien101/launch/processlogger/loglevel"
+)
+
+const (
+	// LoggerTag is used to identify the logger
+	LoggerTag = "kafka"
+
+	keyProcess  = "process"
+	keyPipe     = "pipe"
+	keyHostname = "hostname"
+	keyNone     = "none"
+
+	defaultClientID      = "launch"
+	defaultBatchSize     = 500
+	defaultBatchBytes    = 1024 * 1024
+	defaultFlushInterval = time.Millisecond * 500
+	defaultTimeout       = time.Second * 10
+	defaultMaxRetries    = 3
+	defaultConnectWait   = time.Second * 5
+	// processManagerName is used for the process manager as it has no process name.
+	processManagerName = "launch"
+)
+
+var (
+	validKeys = map[string]bool{
+		keyProcess:  true,
+		keyPipe:     true,
+		keyHostname: true,
+		keyNone:     true,
+	}
+	acks = map[string]sarama.RequiredAcks{
+		"":       sarama.WaitForAll,
+		"all":    sarama.WaitForAll,
+		"leader": sarama.WaitForLocal,
+		"none":   sarama.NoResponse,
+	}
+	compressions = map[string]sarama.CompressionCodec{
+		"":       sarama.CompressionNone,
+		"none":   sarama.CompressionNone,
+		"gzip":   sarama.CompressionGZIP,
+		"snappy": sarama.CompressionSnappy,
+		"lz4":    sarama.CompressionLZ4,
+		"zstd":   sarama.CompressionZSTD,
+	}
+)
+
+func init() {
+	processlogger.RegisterLogger(LoggerTag, func() processlogger.Logger {
+		return &Kafka{}
+	})
+}
+
+// Kafka produces log messages to Kafka topics.
+type Kafka struct {
+	// dropped counts the messages that did not fit in input before the
+	// producer was created. It is first so that it is 64 bit aligned.
+	dropped int64
+
+	config       configfile.Kafka
+	saramaConfig *sarama.Config
+	hostname     string
+
+	// input queues the messages for the producer.
+	input chan *sarama.ProducerMessage
+	// connected is closed once the producer has been created.
+	connected chan bool
+	// stop is closed when Launch shuts down.
+	stop chan bool
+	// done is closed once the waiting messages have been sent.
+	done    chan bool
+	lock    sync.RWMutex
+	stopped bool
+	// connectWait is how long to wait before trying to create the producer again.
+	connectWait time.Duration
+
+	// topics holds the topic for each process keyed by process name.
+	topics map[string]string
+	// keys holds the key selection for each process keyed by process name.
+	keys map[string]string
+	// formatters holds the JSON formatter for each process keyed by process name.
+	formatters map[string]*lineformat.Formatter
+	// newProducer creates the producer. It is replaced in tests.
+	newProducer func(brokers []string, config *sarama.Config) (sarama.AsyncProducer, error)
+}
+
+// topicData is given to the topic template.
+type topicData struct {
+	ProcessName string
+	Hostname    string
+}
+
+// RegisterConfig validates the producer settings and works out the topic and
+// key for the process. Only the topic and key can be set on a process.
+func (k *Kafka) RegisterConfig(conf configfile.LoggingConfig, defaults configfile.DefaultLoggerDetails) error {
+	shared := conf.Kafka
+	shared.Topic, shared.Key = "", ""
+	if !reflect.DeepEqual(shared, configfile.Kafka{}) {
+		return fmt.Errorf("process %s has kafka producer settings. Only topic and key can be set on a process", conf.ProcessName)
+	}
+	if err := k.configure(defaults.Config.Kafka); err != nil {
+		return fmt.Errorf("the kafka logger configuration is invalid. Error: %s", err)
+	}
+
+	name := conf.ProcessName
+	if name == "" {
+		name = processManagerName
+	}
+	topic, err := renderTopic(conf.Kafka.Topic, defaults.Config.Kafka.Topic, topicData{ProcessName: name, Hostname: k.hostname})
+	if err != nil {
+		return fmt.Errorf("process %s has an invalid kafka topic. Error: %s", conf.ProcessName, err)
+	}
+	key := conf.Kafka.Key
+	if key == "" {
+		key = defaults.Config.Kafka.Key
+	}
+	if key == "" {
+		key = keyProcess
+	}
+	if !validKeys[key] {
+		return fmt.Errorf("process %s has an invalid kafka key %s. Use %s, %s, %s or %s", conf.ProcessName, key, keyProcess, keyPipe, keyHostname, keyNone)
+	}
+
+	detector, err := loglevel.NewDetector(conf.LevelDetection, defaults.Config.LevelDetection)
+	if err != nil {
+		return fmt.Errorf("process %s has an invalid level_detection configuration. Error: %s", conf.ProcessName, err)
+	}
+	formatter, err := lineformat.New(lineformat.JSON, detector)
+	if err != nil {
+		return err
+	}
+
+	if k.topics == nil {
+		k.topics = make(map[string]string)
+		k.keys = make(map[string]string)
+		k.formatters = make(map[string]*lineformat.Formatter)
+	}
+	k.topics[conf.ProcessName] = topic
+	k.keys[conf.ProcessName] = key
+	k.formatters[conf.ProcessName] = formatter
+	return nil
+}
+
+// renderTopic works out the topic for a process. The process topic wins over
+// the default topic.
+func renderTopic(topic, fallback string, data topicData) (string, error) {
+	if topic == "" {
+		topic = fallback
+	}
+	if topic == "" {
+		return "", fmt.Errorf("topic is required")
+	}
+	tplt, err := template.New("topic").Option("missingkey=error").Parse(topic)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse the topic template. Error: %s", err)
+	}
+	out := &bytes.Buffer{}
+	if err := tplt.Execute(out, data); err != nil {
+		return "", fmt.Errorf("failed to render the topic template. Error: %s", err)
+	}
+	rendered := strings.TrimSpace(out.String())
+	if rendered == "" {
+		return "", fmt.Errorf("topic %s rendered to an empty string", topic)
+	}
+	return rendered, nil
+}
+
+// configure checks the producer settings and creates the sarama configuration.
+func (k *Kafka) configure(config configfile.Kafka) error {
+	if len(config.Brokers) == 0 {
+		return fmt.Errorf("brokers are required")
+	}
+
+	sc := sarama.NewConfig()
+	sc.ClientID = config.ClientID
+	if sc.ClientID == "" {
+		sc.ClientID = defaultClientID
+	}
+	if config.Version != "" {
+		version, err := sarama.ParseKafkaVersion(config.Version)
+		if err != nil {
+			return fmt.Errorf("version is not valid. Error: %s", err)
+		}
+		sc.Version = version
+	}
+
+	requiredAcks, ok := acks[config.Acks]
+	if !ok {
+		return fmt.Errorf("acks must be none, leader or all. Got: %s", config.Acks)
+	}
+	sc.Producer.RequiredAcks = requiredAcks
+	compression, ok := compressions[config.Compression]
+	if !ok {
+		return fmt.Errorf("compression must be none, gzip, snappy, lz4 or zstd. Got: %s", config.Compression)
+	}
+	sc.Producer.Compression = compression
+
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaultBatchSize
+	}
+	if config.BatchBytes == 0 {
+		config.BatchBytes = defaultBatchBytes
+	}
+	maxRetries := defaultMaxRetries
+	if config.MaxRetries != nil {
+		maxRetries = *config.MaxRetries
+	}
+	if maxRetries < 0 {
+		return fmt.Errorf("max_retries can not be negative. Got: %d", maxRetries)
+	}
+	flushInterval, err := batcher.ParseDuration("flush_interval", config.FlushInterval, defaultFlushInterval)
+	if err != nil {
+		return err
+	}
+	timeout, err := batcher.ParseDuration("timeout", config.Timeout, defaultTimeout)
+	if err != nil {
+		return err
+	}
+	sc.Producer.Flush.Messages = config.BatchSize
+	sc.Producer.Flush.Bytes = int(config.BatchBytes.Bytes())
+	sc.Producer.Flush.Frequency = flushInterval
+	sc.Producer.Retry.Max = maxRetries
+	sc.Producer.Timeout = timeout
+	sc.Producer.Return.Successes = false
+	sc.Producer.Return.Errors = true
+	sc.Net.DialTimeout = timeout
+	sc.Net.ReadTimeout = timeout
+	sc.Net.WriteTimeout = timeout
+	sc.Metadata.Timeout = timeout
+
+	if err := configureTLS(sc, config); err != nil {
+		return err
+	}
+	if err := configureSASL(sc, config); err != nil {
+		return err
+	}
+	if err := sc.Validate(); err != nil {
+		return err
+	}
+
+	k.config = config
+	k.saramaConfig = sc
+	k.hostname, err = os.Hostname()
+	if err != nil {
+		k.hostname = "not_available"
+	}
+	return nil
+}
+
+// configureTLS reads the certificates used to connect to the brokers. The
+// system roots are used if no bundle is given.
+func configureTLS(sc *sarama.Config, config configfile.Kafka) error {
+	if !config.TLS {
+		if config.CertificateBundlePath != "" || config.ClientCertificatePath != "" {
+			return fmt.Errorf("tls must be turned on to use certificates")
+		}
+		return nil
+	}
+	if (config.ClientCertificatePath == "") != (config.ClientKeyPath == "") {
+		return fmt.Errorf("client_cert_path and client_key_path must be set together")
+	}
+
+	tlsConfig := &tls.Config{ServerName: config.ServerName, MinVersion: tls.VersionTLS12}
+	if config.CertificateBundlePath != "" {
+		bundle, err := ioutil.ReadFile(config.CertificateBundlePath)
+		if err != nil {
+			return fmt.Errorf("failed to read the certificate bundle. Error: %s", err)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(bundle) {
+			return fmt.Errorf("failed to parse the given certificate bundle")
+		}
+		tlsConfig.RootCAs = roots
+	}
+	if config.ClientCertificatePath != "" {
+		cert, err := tls.LoadX509KeyPair(config.ClientCertificatePath, config.ClientKeyPath)
+		if err != nil {
+			return fmt.Errorf("failed to load the client certificate and key. Error: %s", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	sc.Net.TLS.Enable = true
+	sc.Net.TLS.Config = tlsConfig
+	return nil
+}
+
+// configureSASL sets up the SASL mechanism used to log in to the brokers.
+func configureSASL(sc *sarama.Config, config configfile.Kafka) error {
+	if config.SASLMechanism == "" {
+		return nil
+	}
+	if config.Username == "" || config.Password == "" {
+		return fmt.Errorf("username and password are required for SASL")
+	}
+	sc.Net.SASL.Enable = true
+	sc.Net.SASL.User = config.Username
+	sc.Net.SASL.Password = config.Password
+	switch config.SASLMechanism {
+	case sarama.SASLTypePlaintext:
+		sc.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+	case sarama.SASLTypeSCRAMSHA256:
+		sc.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+		sc.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient { return &scramClient{hash: sha256Hash} }
+	case sarama.SASLTypeSCRAMSHA512:
+		sc.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+		sc.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient { return &scramClient{hash: sha512Hash} }
+	default:
+		return fmt.Errorf("sasl_mechanism must be %s, %s or %s. Got: %s", sarama.SASLTypePlaintext, sarama.SASLTypeSCRAMSHA256, sarama.SASLTypeSCRAMSHA512, config.SASLMechanism)
+	}
+	return nil
+}
+
+// Start creates the producer in the background so that Launch can start when
+// the brokers can not be reached.
+func (k *Kafka) Start() error {
+	if k.newProducer == nil {
+		k.newProducer = sarama.NewAsyncProducer
+	}
+	if k.connectWait == 0 {
+		k.connectWait = defaultConnectWait
+	}
+	k.input = make(chan *sarama.ProducerMessage, k.saramaConfig.ChannelBufferSize)
+	k.connected = make(chan bool)
+	k.stop = make(chan bool)
+	k.done = make(chan bool)
+	go k.run()
+	return nil
+}
+
+// run creates the producer and passes it the messages until Launch shuts down.
+func (k *Kafka) run() {
+	defer close(k.done)
+	producer := k.connect()
+	if producer == nil {
+		dropped := atomic.LoadInt64(&k.dropped)
+		for range k.input {
+			dropped++
+		}
+		processlogger.ReportError("dropped %d kafka messages as the producer could not be created\n", dropped)
+		return
+	}
+	close(k.connected)
+
+	errorsDone := make(chan bool)
+	go readErrors(producer, errorsDone)
+	for message := range k.input {
+		producer.Input() <- message
+	}
+	// Close flushes the messages that are waiting. Errors are still
+	// reported on the errors channel.
+	producer.AsyncClose()
+	<-errorsDone
+}
+
+// connect tries to create the producer until it works or Launch shuts down.
+// nil is returned if Launch shuts down first.
+func (k *Kafka) connect() sarama.AsyncProducer {
+	for {
+		producer, err := k.newProducer(k.config.Brokers, k.saramaConfig)
+		if err == nil {
+			if dropped := atomic.SwapInt64(&k.dropped, 0); dropped > 0 {
+				processlogger.ReportError("dropped %d kafka messages while the producer was being created\n", dropped)
+			}
+			return producer
+		}
+		processlogger.ReportError("failed to create the kafka producer, trying again in %s. Error: %s\n", k.connectWait, err)
+		select {
+		case <-k.stop:
+			return nil
+		case <-time.After(k.connectWait):
+		}
+	}
+}
+
+// readErrors reports the messages that could not be produced. Loggers should
+// not stop the processes so the messages are dropped.
+func readErrors(producer sarama.AsyncProducer, done chan bool) {
+	defer close(done)
+	for err := range producer.Errors() {
+		processlogger.ReportError("failed to produce kafka message to %s. Error: %s\n", err.Msg.Topic, err.Err)
+	}
+}
+
+// Shutdown sends the messages that are waiting and closes the producer.
+func (k *Kafka) Shutdown() chan error {
+	c := make(chan error, 1)
+	go func() {
+		defer close(c)
+		k.lock.Lock()
+		if k.stopped || k.input == nil {
+			k.stopped = true
+			k.lock.Unlock()
+			c <- nil
+			return
+		}
+		k.stopped = true
+		close(k.stop)
+		close(k.input)
+		k.lock.Unlock()
+		<-k.done
+		c <- nil
+	}()
+	return c
+}
+
+// Submit turns the message into a JSON record and passes it to the producer.
+func (k *Kafka) Submit(msg processlogger.LogMessage) {
+	topic, ok := k.topics[msg.Config.ProcessName]
+	if !ok {
+		return
+	}
+	line := strings.TrimSuffix(k.formatters[msg.Config.ProcessName].Format(msg), "\n")
+	message := &sarama.ProducerMessage{
+		Topic: topic,
+		Value: sarama.StringEncoder(line),
+	}
+	if key := k.key(msg); key != "" {
+		message.Key = sarama.StringEncoder(key)
+	}
+	if !msg.Time.IsZero() {
+		message.Timestamp = msg.Time
+	}
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+	if k.stopped || k.input == nil {
+		return
+	}
+	select {
+	case k.input <- message:
+		return
+	default:
+	}
+	// Processes should not wait for the brokers to come up so messages are
+	// dropped until the producer is created.
+	select {
+	case <-k.connected:
+		k.input <- message
+	default:
+		atomic.AddInt64(&k.dropped, 1)
+	}
+}
+
+// key works out the message key. Messages with the same key go to the same
+// partition which keeps them in order.
+func (k *Kafka) key(msg processlogger.LogMessage) string {
+	switch k.keys[msg.Config.ProcessName] {
+	case keyProcess:
+		if msg.Config.ProcessName == "" {
+			return processManagerName
+		}
+		return msg.Config.ProcessName
+	case keyPipe:
+		return msg.Config.ProcessName + "." + msg.Pipe.Name()
+	case keyHostname:
+		return k.hostname
+	}
+	return ""
+}
@@ -0,0 +1,272 @@
+package kafka
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/morfien101/launch/configfile"
+	"github.com/morfien101/launch/processlogger"
+)
+
+func registerAll(t *testing.T, k *Kafka, config configfile.Kafka, confs ...configfile.LoggingConfig) {
+	defaults := configfile.DefaultLoggerDetails{Config: configfile.LoggingConfig{Kafka: config}}
+	for _, conf := range confs {
+		if err := k.RegisterConfig(conf, defaults); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func retries(n int) *int {
+	return &n
+}
+
+// checkMessage returns a checker that compares the topic, key and record of a
+// produced message.
+func checkMessage(topic, key, message string) mocks.MessageChecker {
+	return func(msg *sarama.ProducerMessage) error {
+		if msg.Topic != topic {
+			return fmt.Errorf("topic is not as expected. Want: %s, Got: %s", topic, msg.Topic)
+		}
+		var gotKey string
+		if msg.Key != nil {
+			encoded, _ := msg.Key.Encode()
+			gotKey = string(encoded)
+		}
+		if gotKey != key {
+			return fmt.Errorf("key is not as expected. Want: %s, Got: %s", key, gotKey)
+		}
+		value, _ := msg.Value.Encode()
+		record := map[string]interface{}{}
+		if err := json.Unmarshal(value, &record); err != nil {
+			return fmt.Errorf("value is not a JSON record. Got: %s", value)
+		}
+		if record["message"] != message || record["source"] == nil || record["level"] == nil {
+			return fmt.Errorf("record is not as expected. Got: %s", value)
+		}
+		return nil
+	}
+}
+
+func TestProducedMessages(t *testing.T) {
+	web := configfile.LoggingConfig{ProcessName: "web"}
+	worker := configfile.LoggingConfig{ProcessName: "worker", Kafka: configfile.Kafka{Topic: "jobs", Key: keyPipe}}
+	k := &Kafka{}
+	registerAll(t, k, configfile.Kafka{Brokers: []string{"kafka:9092"}, Topic: "logs.{{ .ProcessName }}"}, web, worker)
+
+	var producer *mocks.AsyncProducer
+	k.newProducer = func(brokers []string, config *sarama.Config) (sarama.AsyncProducer, error) {
+		producer = mocks.NewAsyncProducer(t, config)
+		producer.ExpectInputWithMessageCheckerFunctionAndSucceed(checkMessage("logs.web", "web", "one"))
+		producer.ExpectInputWithMessageCheckerFunctionAndSucceed(checkMessage("jobs", "worker.stderr", "two"))
+		return producer, nil
+	}
+	if err := k.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	captured := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	k.Submit(processlogger.LogMessage{Source: "web", Pipe: processlogger.STDOUT, Config: web, Message: "one\n", Time: captured})
+	k.Submit(processlogger.LogMessage{Source: "worker", Pipe: processlogger.STDERR, Config: worker, Message: "two\n", Time: captured})
+	if err := <-k.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUnreachableBrokers(t *testing.T) {
+	conf := configfile.LoggingConfig{ProcessName: "web"}
+	k := &Kafka{connectWait: time.Millisecond}
+	registerAll(t, k, configfile.Kafka{Brokers: []string{"kafka:9092"}, Topic: "logs"}, conf)
+
+	attempts := 0
+	var producer *mocks.AsyncProducer
+	k.newProducer = func(brokers []string, config *sarama.Config) (sarama.AsyncProducer, error) {
+		attempts++
+		if attempts < 3 {
+			return nil, sarama.ErrOutOfBrokers
+		}
+		producer = mocks.NewAsyncProducer(t, config)
+		producer.ExpectInputWithMessageCheckerFunctionAndSucceed(checkMessage("logs", "web", "after"))
+		return producer, nil
+	}
+	if err := k.Start(); err != nil {
+		t.Logf("Start should not fail when the brokers can not be reached. Error: %s", err)
+		t.FailNow()
+	}
+	select {
+	case <-k.connected:
+	case <-time.After(time.Second * 5):
+		t.Fatal("The producer was not created after the brokers came up")
+	}
+	k.Submit(processlogger.LogMessage{Source: "web", Pipe: processlogger.STDOUT, Config: conf, Message: "after\n"})
+	if err := <-k.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 3 {
+		t.Logf("The producer should be created on the third attempt. Got: %d attempts", attempts)
+		t.Fail()
+	}
+}
+
+func TestShutdownWithoutBrokers(t *testing.T) {
+	conf := configfile.LoggingConfig{ProcessName: "web"}
+	k := &Kafka{}
+	registerAll(t, k, configfile.Kafka{Brokers: []string{"kafka:9092"}, Topic: "logs"}, conf)
+	k.newProducer = func(brokers []string, config *sarama.Config) (sarama.AsyncProducer, error) {
+		return nil, sarama.ErrOutOfBrokers
+	}
+	if err := k.Start(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < k.saramaConfig.ChannelBufferSize*2; i++ {
+		k.Submit(processlogger.LogMessage{Source: "web", Pipe: processlogger.STDOUT, Config: conf, Message: "dropped\n"})
+	}
+	select {
+	case err := <-k.Shutdown():
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Logf("Shutdown should not wait for the brokers")
+		t.Fail()
+	}
+}
+
+func TestMockBroker(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("logs", 0, broker.BrokerID()),
+		"ProduceRequest": sarama.NewMockProduceResponse(t),
+	})
+
+	conf := configfile.LoggingConfig{ProcessName: "web"}
+	k := &Kafka{}
+	registerAll(t, k, configfile.Kafka{Brokers: []string{broker.Addr()}, Topic: "logs", Acks: "leader", Compression: "gzip"}, conf)
+	if err := k.Start(); err != nil {
+		t.Fatal(err)
+	}
+	k.Submit(processlogger.LogMessage{Source: "web", Pipe: processlogger.STDOUT, Config: conf, Message: "hello\n"})
+	if err := <-k.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+
+	produced := false
+	for _, rr := range broker.History() {
+		if request, ok := rr.Request.(*sarama.ProduceRequest); ok {
+			produced = true
+			if request.RequiredAcks != sarama.WaitForLocal {
+				t.Logf("Acks are not as expected. Got: %d", request.RequiredAcks)
+				t.Fail()
+			}
+		}
+	}
+	if !produced {
+		t.Logf("The message was not produced to the broker")
+		t.Fail()
+	}
+}
+
+func TestRenderTopic(t *testing.T) {
+	data := topicData{ProcessName: "web", Hostname: "host1"}
+	tests := []struct {
+		topic, fallback, want string
+	}{
+		{topic: "", fallback: "logs", want: "logs"},
+		{topic: "app", fallback: "logs", want: "app"},
+		{topic: "", fallback: "logs.{{ .ProcessName }}", want: "logs.web"},
+		{topic: "{{ .Hostname }}-{{ .ProcessName }}", want: "host1-web"},
+	}
+	for _, test := range tests {
+		got, err := renderTopic(test.topic, test.fallback, data)
+		if err != nil || got != test.want {
+			t.Logf("Topic is not as expected. Want: %s, Got: %s, Error: %v", test.want, got, err)
+			t.Fail()
+		}
+	}
+	for _, topic := range []string{"", "{{ .Missing }}", "{{ .ProcessName", "{{ \"\" }}"} {
+		if _, err := renderTopic(topic, "", data); err == nil {
+			t.Logf("Topic %s should be rejected", topic)
+			t.Fail()
+		}
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	brokers := []string{"kafka:9092"}
+	tests := []struct {
+		config configfile.Kafka
+		conf   configfile.LoggingConfig
+	}{
+		{config: configfile.Kafka{Topic: "logs"}},
+		{config: configfile.Kafka{Brokers: brokers}},
+		{config: configfile.Kafka{Brokers: brokers, Topic: "logs", Key: "random"}},
+		{config: configfile.Kafka{Brokers: brokers, Topic: "logs", Acks: "some"}},
+		{config: configfile.Kafka{Brokers: brokers, Topic: "logs", Compression: "brotli"}},
+		{config: configfile.Kafka{Brokers: brokers, Topic: "logs", Version: "latest"}},
+		{config: configfile.Kafka{Brokers: brokers, Topic: "logs", FlushInterval: "soon"}},
+		{config: configfile.Kafka{Brokers: brokers, Topic: "logs", MaxRetries: retries(-1)}},
+		{config: configfile.Kafka{Brokers: brokers, Topic: "logs", SASLMechanism: "PLAIN"}},
+		{config: configfile.Kafka{Brokers: brokers, Topic: "logs", SASLMechanism: "GSSAPI", Username: "u", Password: "p"}},
+		{config: configfile.Kafka{Brokers: brokers, Topic: "logs", CertificateBundlePath: "/ca.pem"}},
+		{config: configfile.Kafka{Brokers: brokers, Topic: "logs", TLS: true, ClientCertificatePath: "/cert.pem"}},
+		{config: configfile.Kafka{Brokers: brokers, Topic: "logs"}, conf: configfile.LoggingConfig{Kafka: configfile.Kafka{Key: "random"}}},
+		{config: configfile.Kafka{Brokers: brokers, Topic: "logs"}, conf: configfile.LoggingConfig{Kafka: configfile.Kafka{Brokers: []string{"other:9092"}}}},
+	}
+	for _, test := range tests {
+		k := &Kafka{}
+		defaults := configfile.DefaultLoggerDetails{Config: configfile.LoggingConfig{Kafka: test.config}}
+		if err := k.RegisterConfig(test.conf, defaults); err == nil {
+			t.Logf("Config should be rejected: %+v %+v", test.config, test.conf.Kafka)
+			t.Fail()
+		}
+	}
+}
+
+func TestMaxRetries(t *testing.T) {
+	tests := []struct {
+		maxRetries *int
+		want       int
+	}{
+		{maxRetries: nil, want: defaultMaxRetries},
+		{maxRetries: retries(0), want: 0},
+		{maxRetries: retries(5), want: 5},
+	}
+	for _, test := range tests {
+		k := &Kafka{}
+		registerAll(t, k, configfile.Kafka{Brokers: []string{"kafka:9092"}, Topic: "logs", MaxRetries: test.maxRetries}, configfile.LoggingConfig{})
+		if got := k.saramaConfig.Producer.Retry.Max; got != test.want {
+			t.Logf("Producer retries are not as expected. Want: %d, Got: %d", test.want, got)
+			t.Fail()
+		}
+	}
+}
+
+func TestSASL(t *testing.T) {
+	for _, mechanism := range []string{sarama.SASLTypePlaintext, sarama.SASLTypeSCRAMSHA256, sarama.SASLTypeSCRAMSHA512} {
+		k := &Kafka{}
+		config := configfile.Kafka{Brokers: []string{"kafka:9092"}, Topic: "logs", SASLMechanism: mechanism, Username: "u", Password: "p", TLS: true}
+		registerAll(t, k, config, configfile.LoggingConfig{})
+		if !k.saramaConfig.Net.SASL.Enable || !k.saramaConfig.Net.TLS.Enable || string(k.saramaConfig.Net.SASL.Mechanism) != mechanism {
+			t.Logf("SASL is not set up for %s", mechanism)
+			t.Fail()
+		}
+		if generate := k.saramaConfig.Net.SASL.SCRAMClientGeneratorFunc; generate != nil {
+			client := generate()
+			if err := client.Begin("u", "p", ""); err != nil {
+				t.Logf("Failed to start the SCRAM conversation. Error: %s", err)
+				t.Fail()
+			}
+			if first, err := client.Step(""); err != nil || first == "" {
+				t.Logf("Failed to create the first SCRAM message. Error: %v", err)
+				t.Fail()
+			}
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package kafka
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+
+	"github.com/xdg-go/scram"
+)
+
+var (
+	sha256Hash scram.HashGeneratorFcn = sha256.New
+	sha512Hash scram.HashGeneratorFcn = sha512.New
+)
+
+// scramClient lets sarama log in using SCRAM.
+type scramClient struct {
+	hash         scram.HashGeneratorFcn
+	conversation *scram.ClientConversation
+}
+
+// Begin starts a SCRAM conversation.
+func (sc *scramClient) Begin(userName, password, authzID string) error {
+	client, err := sc.hash.NewClient(userName, password, authzID)
+	if err != nil {
+		return err
+	}
+	sc.conversation = client.NewConversation()
+	return nil
+}
+
+// Step answers a challenge from the broker.
+func (sc *scramClient) Step(challenge string) (string, error) {
+	return sc.conversation.Step(challenge)
+}
+
+// Done tells sarama if the conversation has finished.
+func (sc *scramClient) Done() bool {
+	return sc.conversation.Done()
+}
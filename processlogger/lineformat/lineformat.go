@@ -0,0 +1,378 @@
+// Package lineformat turns log messages into lines of text for the loggers that
+// write lines, such as the console and file loggers.
+// A format is either the name of a built in preset or a Go text/template that is
+// given a Record for each message.
+package lineformat
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/morfien101/launch/processlogger"
+	"github.com/morfien101/launch/processlogger/loglevel"
+)
+
+const (
+	// Raw writes the message exactly as it was received.
+	Raw = "raw"
+	// Plain is the same as Raw.
+	Plain = "plain"
+	// Prefixed writes the source of the message in front of the message.
+	Prefixed = "prefixed"
+	// Logfmt writes the record as key=value pairs.
+	Logfmt = "logfmt"
+	// JSON writes the record as a JSON object.
+	JSON = "json"
+
+	prefixedTemplate = "{{ .Source }}{{ with fields .Fields }} [{{ . }}]{{ end }}: {{ .Message }}"
+	// TimeLayout is the layout used for times in the logfmt and json presets.
+	TimeLayout = time.RFC3339Nano
+)
+
+var (
+	hostname = func() string {
+		name, err := os.Hostname()
+		if err != nil {
+			return "not_available"
+		}
+		return name
+	}()
+
+	// logfmtKeys are written by the logfmt preset for every record.
+	logfmtKeys = map[string]bool{"time": true, "level": true, "source": true, "pipe": true, "hostname": true, "pid": true, "msg": true}
+
+	funcMap = template.FuncMap{
+		"json":   jsonValue,
+		"quote":  logfmtValue,
+		"fields": logfmtFields,
+	}
+)
+
+// Record holds the fields that are available to templates.
+type Record struct {
+	Time        time.Time
+	Source      string
+	Pipe        string
+	Level       string
+	Message     string
+	Hostname    string
+	PID         int
+	ProcessType string
+	Generation  int
+	Sequence    uint64
+	Fields      map[string]string
+	// Parsed is the decoded message. It is nil if the message was not parsed.
+	Parsed *processlogger.Parsed
+	// File is the log file the message was read from. It is empty for stdout and stderr.
+	File string
+}
+
+// jsonLine is the object written for each message by the json preset.
+type jsonLine struct {
+	Timestamp   string            `json:"timestamp"`
+	Source      string            `json:"source"`
+	Pipe        string            `json:"pipe"`
+	File        string            `json:"file,omitempty"`
+	Level       string            `json:"level"`
+	Hostname    string            `json:"hostname"`
+	PID         int               `json:"pid,omitempty"`
+	ProcessType string            `json:"process_type,omitempty"`
+	Sequence    uint64            `json:"sequence,omitempty"`
+	Fields      map[string]string `json:"fields,omitempty"`
+	Message     json.RawMessage   `json:"message"`
+}
+
+// Formatter renders log messages as lines.
+type Formatter struct {
+	preset   string
+	tmpl     *template.Template
+	detector *loglevel.Detector
+}
+
+// New creates a Formatter for the format. The detector is used to find the
+// level of JSON messages and can be nil.
+func New(format string, detector *loglevel.Detector) (*Formatter, error) {
+	if detector == nil {
+		detector = loglevel.Default()
+	}
+	f := &Formatter{detector: detector}
+
+	switch format {
+	case Raw, Plain:
+		f.preset = Raw
+	case Logfmt, JSON:
+		f.preset = format
+	case Prefixed:
+		format = prefixedTemplate
+		fallthrough
+	default:
+		if !strings.Contains(format, "{{") {
+			return nil, fmt.Errorf("%s is not a valid format. Use raw, logfmt, json or a template", format)
+		}
+		tmpl, err := template.New("format").Funcs(funcMap).Parse(format)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse format template. Error: %s", err)
+		}
+		f.tmpl = tmpl
+	}
+	return f, nil
+}
+
+// Format renders the message. The line returned always ends with a new line.
+func (f *Formatter) Format(msg processlogger.LogMessage) string {
+	var line string
+	switch {
+	case f.preset == Raw:
+		line = msg.Message
+	case f.preset == Logfmt:
+		line = formatLogfmt(f.Record(msg))
+	case f.preset == JSON:
+		line = formatJSON(f.Record(msg))
+	default:
+		record := f.Record(msg)
+		out := &bytes.Buffer{}
+		if err := f.tmpl.Execute(out, record); err != nil {
+			// Loggers should not lose messages because of a bad template.
+			line = fmt.Sprintf("%s: %s (format error: %s)", record.Source, record.Message, err)
+		} else {
+			line = out.String()
+		}
+	}
+
+	if !strings.HasSuffix(line, "\n") {
+		line = line + "\n"
+	}
+	return line
+}
+
+// Record creates the Record for a message.
+func (f *Formatter) Record(msg processlogger.LogMessage) Record {
+	text := strings.TrimRight(msg.Message, "\n")
+	timestamp := msg.Time
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+	return Record{
+		Time:        timestamp,
+		Source:      msg.Source,
+		Pipe:        msg.Pipe.Name(),
+		Level:       f.Level(msg, text).String(),
+		Message:     text,
+		Hostname:    hostname,
+		PID:         msg.PID,
+		ProcessType: msg.ProcessType,
+		Generation:  msg.Generation,
+		Sequence:    msg.Sequence,
+		Fields:      msg.Fields,
+		Parsed:      msg.Parsed,
+		File:        msg.File,
+	}
+}
+
+// Level works out the level of the message. Parsed and JSON messages are
+// checked for a level, otherwise STDOUT is info and STDERR is err.
+func (f *Formatter) Level(msg processlogger.LogMessage, text string) loglevel.Level {
+	if msg.Parsed != nil {
+		if level, err := f.detector.FromMap(msg.Parsed.Fields); err == nil {
+			return level
+		}
+	} else if isJSONObject(text) {
+		if level, err := f.detector.FromJSON([]byte(text)); err == nil {
+			return level
+		}
+	}
+	if msg.Pipe == processlogger.STDERR {
+		return loglevel.Error
+	}
+	return loglevel.Info
+}
+
+// formatJSON creates a single line JSON object for the record. If the message
+// is a JSON object itself it is embedded rather than encoded as a string.
+// Parsed messages are merged into the object instead.
+func formatJSON(record Record) string {
+	if record.Parsed != nil {
+		return formatParsedJSON(record)
+	}
+	line := jsonLine{
+		Timestamp:   record.Time.Format(TimeLayout),
+		Source:      record.Source,
+		Pipe:        record.Pipe,
+		File:        record.File,
+		Level:       record.Level,
+		Hostname:    record.Hostname,
+		PID:         record.PID,
+		ProcessType: record.ProcessType,
+		Sequence:    record.Sequence,
+		Fields:      record.Fields,
+	}
+
+	if isJSONObject(record.Message) {
+		compacted := &bytes.Buffer{}
+		if err := json.Compact(compacted, []byte(record.Message)); err == nil {
+			line.Message = compacted.Bytes()
+		}
+	}
+	if line.Message == nil {
+		line.Message = json.RawMessage(jsonValue(record.Message))
+	}
+
+	out, err := json.Marshal(line)
+	if err != nil {
+		// Should never happen, the message is always valid JSON at this point.
+		return fmt.Sprintf("%s: %s", record.Source, record.Message)
+	}
+	return string(out)
+}
+
+// formatParsedJSON writes the keys of a parsed message with the details of the
+// record added. The details win if the message uses the same keys. The text of
+// the message is always written as message.
+func formatParsedJSON(record Record) string {
+	merged := make(map[string]interface{}, len(record.Parsed.Fields)+10)
+	for key, value := range record.Parsed.Fields {
+		if key != record.Parsed.MessageKey {
+			merged[key] = value
+		}
+	}
+	merged["timestamp"] = record.Time.Format(TimeLayout)
+	merged["source"] = record.Source
+	merged["pipe"] = record.Pipe
+	if record.File != "" {
+		merged["file"] = record.File
+	}
+	merged["level"] = record.Level
+	merged["hostname"] = record.Hostname
+	if record.PID != 0 {
+		merged["pid"] = record.PID
+	}
+	if record.ProcessType != "" {
+		merged["process_type"] = record.ProcessType
+	}
+	if record.Sequence != 0 {
+		merged["sequence"] = record.Sequence
+	}
+	if len(record.Fields) > 0 {
+		merged["fields"] = record.Fields
+	}
+	if record.Parsed.MessageKey != "" {
+		merged["message"] = record.Parsed.Message
+	}
+
+	out, err := json.Marshal(merged)
+	if err != nil {
+		return fmt.Sprintf("%s: %s", record.Source, record.Message)
+	}
+	return string(out)
+}
+
+// formatLogfmt writes the record as logfmt key=value pairs. The keys of parsed
+// messages are written as pairs too.
+func formatLogfmt(record Record) string {
+	pairs := []string{
+		"time=" + record.Time.Format(TimeLayout),
+		"level=" + record.Level,
+		"source=" + logfmtValue(record.Source),
+		"pipe=" + record.Pipe,
+		"hostname=" + logfmtValue(record.Hostname),
+	}
+	if record.File != "" {
+		pairs = append(pairs, "file="+logfmtValue(record.File))
+	}
+	if record.PID != 0 {
+		pairs = append(pairs, "pid="+strconv.Itoa(record.PID))
+	}
+	if len(record.Fields) > 0 {
+		pairs = append(pairs, logfmtFields(record.Fields))
+	}
+	if record.Parsed != nil {
+		pairs = append(pairs, parsedPairs(record)...)
+		return strings.Join(append(pairs, "msg="+logfmtValue(record.Parsed.Message)), " ")
+	}
+	pairs = append(pairs, "msg="+logfmtValue(record.Message))
+	return strings.Join(pairs, " ")
+}
+
+// logfmtFields writes the fields as logfmt pairs sorted by name. It is
+// available to templates as fields.
+func logfmtFields(fields map[string]string) string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, name+"="+logfmtValue(fields[name]))
+	}
+	return strings.Join(pairs, " ")
+}
+
+// parsedPairs writes the keys of a parsed message sorted by name. The key that
+// holds the text of the message and keys already written by the record are
+// left out.
+func parsedPairs(record Record) []string {
+	parsed := record.Parsed
+	keys := make([]string, 0, len(parsed.Fields))
+	for key := range parsed.Fields {
+		if _, ok := record.Fields[key]; ok || logfmtKeys[key] || key == parsed.MessageKey {
+			continue
+		}
+		if key == "file" && record.File != "" {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, logfmtKey(key)+"="+logfmtValue(processlogger.ValueText(parsed.Fields[key])))
+	}
+	return pairs
+}
+
+// logfmtKey replaces the characters that can not be used in a logfmt key.
+func logfmtKey(key string) string {
+	key = strings.Map(func(r rune) rune {
+		if r <= ' ' || r == '=' || r == '"' {
+			return '_'
+		}
+		return r
+	}, key)
+	if key == "" {
+		return "_"
+	}
+	return key
+}
+
+func isJSONObject(text string) bool {
+	return strings.HasPrefix(strings.TrimSpace(text), "{") && json.Valid([]byte(text))
+}
+
+// jsonValue encodes the value as JSON. It is available to templates as json.
+func jsonValue(value interface{}) string {
+	out, err := json.Marshal(value)
+	if err != nil {
+		return `""`
+	}
+	return string(out)
+}
+
+// logfmtValue quotes the value if it contains characters that would break a
+// logfmt pair. It is available to templates as quote.
+func logfmtValue(value string) string {
+	if value == "" {
+		return `""`
+	}
+	if strings.ContainsAny(value, " =\"\t\\") || strings.IndexFunc(value, func(r rune) bool { return r < ' ' }) >= 0 {
+		return strconv.Quote(value)
+	}
+	return value
+}
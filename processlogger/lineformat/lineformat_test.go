@@ -0,0 +1,213 @@
+package lineformat
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/morfien101/launch/processlogger"
+)
+
+func testMessage(pipe processlogger.Pipe, message string) processlogger.LogMessage {
+	return processlogger.LogMessage{
+		Source:      "web",
+		Pipe:        pipe,
+		Message:     message,
+		Time:        time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		PID:         42,
+		ProcessType: "main",
+		Sequence:    3,
+	}
+}
+
+func TestPresets(t *testing.T) {
+	tests := []struct {
+		format string
+		pipe   processlogger.Pipe
+		want   string
+	}{
+		{format: Raw, pipe: processlogger.STDOUT, want: "hello world\n"},
+		{format: Plain, pipe: processlogger.STDOUT, want: "hello world\n"},
+		{format: Prefixed, pipe: processlogger.STDOUT, want: "web: hello world\n"},
+		{
+			format: Logfmt,
+			pipe:   processlogger.STDERR,
+			want:   `time=2020-01-02T03:04:05Z level=err source=web pipe=stderr hostname=` + logfmtValue(hostname) + ` pid=42 msg="hello world"` + "\n",
+		},
+		{
+			format: "{{ .Time.Format \"2006-01-02\" }} {{ .Pipe }} {{ .ProcessType }}/{{ .PID }}#{{ .Sequence }} {{ .Message | quote }}",
+			pipe:   processlogger.STDOUT,
+			want:   "2020-01-02 stdout main/42#3 \"hello world\"\n",
+		},
+		{format: "{{ json .Message }}", pipe: processlogger.STDOUT, want: "\"hello world\"\n"},
+	}
+
+	for _, test := range tests {
+		f, err := New(test.format, nil)
+		if err != nil {
+			t.Fatalf("Format %q was rejected. Error: %s", test.format, err)
+		}
+		got := f.Format(testMessage(test.pipe, "hello world\n"))
+		if got != test.want {
+			t.Logf("Format %q is not as expected.\nWant: %q\nGot:  %q", test.format, test.want, got)
+			t.Fail()
+		}
+	}
+}
+
+func TestRawKeepsMessage(t *testing.T) {
+	f, _ := New(Raw, nil)
+	if got := f.Format(testMessage(processlogger.STDOUT, "no new line")); got != "no new line\n" {
+		t.Logf("Raw lines should always end with a new line. Got: %q", got)
+		t.Fail()
+	}
+}
+
+func TestJSONEmbedsObjects(t *testing.T) {
+	f, _ := New(JSON, nil)
+	out := f.Format(testMessage(processlogger.STDOUT, "{\"level\": \"debug\", \"msg\": \"hi\"}\n"))
+	decoded := map[string]json.RawMessage{}
+	if err := json.Unmarshal([]byte(out), &decoded); err != nil {
+		t.Fatalf("JSON preset did not produce JSON. Got: %s", out)
+	}
+	if string(decoded["message"]) != `{"level":"debug","msg":"hi"}` {
+		t.Logf("JSON message was not embedded. Got: %s", decoded["message"])
+		t.Fail()
+	}
+	if string(decoded["level"]) != `"debug"` {
+		t.Logf("Level was not read from the JSON message. Got: %s", decoded["level"])
+		t.Fail()
+	}
+	if !strings.HasSuffix(out, "}\n") {
+		t.Logf("JSON line does not end with a new line. Got: %q", out)
+		t.Fail()
+	}
+}
+
+func TestInvalidFormats(t *testing.T) {
+	for _, format := range []string{"xml", "{{ .Missing", ""} {
+		if _, err := New(format, nil); err == nil {
+			t.Logf("Format %q should be rejected", format)
+			t.Fail()
+		}
+	}
+}
+
+func TestTemplateErrorKeepsMessage(t *testing.T) {
+	f, err := New("{{ .Unknown }}", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := f.Format(testMessage(processlogger.STDOUT, "hello\n"))
+	if !strings.HasPrefix(got, "web: hello") {
+		t.Logf("A failed template should still write the message. Got: %q", got)
+		t.Fail()
+	}
+}
+
+func TestFields(t *testing.T) {
+	msg := testMessage(processlogger.STDOUT, "hello world\n")
+	msg.Fields = map[string]string{"version": "1.2.3", "env": "prod west"}
+	tests := map[string]string{
+		Prefixed:                   "web [env=\"prod west\" version=1.2.3]: hello world\n",
+		"{{ .Fields.version }}":    "1.2.3\n",
+		"{{ fields .Fields }} msg": "env=\"prod west\" version=1.2.3 msg\n",
+	}
+	for format, want := range tests {
+		f, err := New(format, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := f.Format(msg); got != want {
+			t.Logf("Format %q is not as expected.\nWant: %q\nGot:  %q", format, want, got)
+			t.Fail()
+		}
+	}
+
+	f, _ := New(Logfmt, nil)
+	if got := f.Format(msg); !strings.Contains(got, ` pid=42 env="prod west" version=1.2.3 msg=`) {
+		t.Logf("Logfmt fields are not as expected. Got: %q", got)
+		t.Fail()
+	}
+
+	f, _ = New(JSON, nil)
+	decoded := map[string]json.RawMessage{}
+	if err := json.Unmarshal([]byte(f.Format(msg)), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if string(decoded["fields"]) != `{"env":"prod west","version":"1.2.3"}` {
+		t.Logf("JSON fields are not as expected. Got: %s", decoded["fields"])
+		t.Fail()
+	}
+}
+
+func TestParsedMessages(t *testing.T) {
+	msg := testMessage(processlogger.STDOUT, `{"msg":"done","level":"warn","status":200,"source":"app"}`+"\n")
+	msg.Parsed = &processlogger.Parsed{
+		Fields: map[string]interface{}{
+			"msg":    "done",
+			"level":  "warn",
+			"status": json.Number("200"),
+			"source": "app",
+		},
+		MessageKey: "msg",
+		Message:    "done",
+	}
+
+	f, _ := New(JSON, nil)
+	decoded := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(f.Format(msg)), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"message": "done",
+		"level":   "warning",
+		"status":  float64(200),
+		"source":  "web",
+		"pipe":    "stdout",
+	}
+	for key, value := range want {
+		if decoded[key] != value {
+			t.Logf("%s is not as expected. Want: %v, Got: %v", key, value, decoded[key])
+			t.Fail()
+		}
+	}
+	if _, ok := decoded["msg"]; ok {
+		t.Logf("The message key should not be repeated. Got: %v", decoded)
+		t.Fail()
+	}
+
+	f, _ = New(Logfmt, nil)
+	if got := f.Format(msg); !strings.HasSuffix(got, ` pid=42 status=200 msg=done`+"\n") || !strings.Contains(got, "level=warning source=web") {
+		t.Logf("Parsed logfmt is not as expected. Got: %q", got)
+		t.Fail()
+	}
+
+	f, _ = New(Raw, nil)
+	if got := f.Format(msg); got != msg.Message {
+		t.Logf("Raw lines should not change. Got: %q", got)
+		t.Fail()
+	}
+}
+
+func TestFileMessages(t *testing.T) {
+	msg := testMessage(processlogger.FILE, "GET / 200\n")
+	msg.File = "/var/log/app/access.log"
+
+	f, _ := New(JSON, nil)
+	decoded := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(f.Format(msg)), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded["pipe"] != "file" || decoded["file"] != msg.File || decoded["level"] != "info" {
+		t.Logf("JSON line is not as expected. Got: %v", decoded)
+		t.Fail()
+	}
+
+	f, _ = New(Logfmt, nil)
+	if got := f.Format(msg); !strings.Contains(got, " pipe=file ") || !strings.Contains(got, " file=/var/log/app/access.log ") {
+		t.Logf("logfmt line is not as expected. Got: %s", got)
+		t.Fail()
+	}
+}
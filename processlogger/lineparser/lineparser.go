@@ -0,0 +1,131 @@
+// Package lineparser decodes the lines of a process once, before they are
+// given to the loggers. Loggers can then use the keys of the line rather than
+// treating it as plain text.
+// Lines that can not be decoded are logged as plain text.
+package lineparser
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/morfien101/launch/configfile"
+	"github.com/morfien101/launch/processlogger"
+)
+
+const (
+	// JSON decodes lines that hold a single JSON object.
+	JSON = "json"
+	// Logfmt decodes lines of key=value pairs.
+	Logfmt = "logfmt"
+	// Regex uses the named groups of a pattern as the keys.
+	Regex = "regex"
+)
+
+var (
+	// messageKeys are checked in order for the text of the message.
+	messageKeys = []string{"message", "msg"}
+	// numberPattern matches the values of logfmt and regex lines that are
+	// sent as numbers.
+	numberPattern = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+)
+
+// Parser decodes lines. A nil Parser does not parse anything.
+type Parser struct {
+	parse func(line string) map[string]interface{}
+}
+
+// New returns a Parser for the logging config. nil is returned if lines should
+// not be parsed.
+func New(conf configfile.LoggingConfig) (*Parser, error) {
+	name := conf.Parser
+	if name == "" && conf.ParseJSON {
+		name = JSON
+	}
+	switch name {
+	case "":
+		return nil, nil
+	case JSON:
+		return &Parser{parse: parseJSON}, nil
+	case Logfmt:
+		return &Parser{parse: parseLogfmt}, nil
+	case Regex:
+		pattern, err := regexp.Compile(conf.ParserPattern)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile parser_pattern %s. Error: %s", conf.ParserPattern, err)
+		}
+		return &Parser{parse: regexParser(pattern)}, nil
+	}
+	return nil, fmt.Errorf("parser must be %s, %s or %s. Got: %s", JSON, Logfmt, Regex, name)
+}
+
+// Parse decodes the line. nil is returned if the line can not be decoded.
+func (p *Parser) Parse(line string) *processlogger.Parsed {
+	if p == nil {
+		return nil
+	}
+	fields := p.parse(line)
+	if fields == nil {
+		return nil
+	}
+	return newParsed(fields)
+}
+
+// parseJSON decodes a line that holds a single JSON object. Numbers are kept
+// as they were written so that large ids do not lose precision.
+func parseJSON(line string) map[string]interface{} {
+	trimmed := strings.TrimSpace(line)
+	if !strings.HasPrefix(trimmed, "{") {
+		return nil
+	}
+	decoder := json.NewDecoder(strings.NewReader(trimmed))
+	decoder.UseNumber()
+	fields := map[string]interface{}{}
+	if err := decoder.Decode(&fields); err != nil || decoder.More() {
+		return nil
+	}
+	return fields
+}
+
+// regexParser returns a parse func that uses the named groups of the pattern
+// as keys. Groups that did not take part in the match are left out.
+func regexParser(pattern *regexp.Regexp) func(string) map[string]interface{} {
+	names := pattern.SubexpNames()
+	return func(line string) map[string]interface{} {
+		match := pattern.FindStringSubmatchIndex(line)
+		if match == nil {
+			return nil
+		}
+		fields := map[string]interface{}{}
+		for i, name := range names {
+			if name == "" || match[i*2] < 0 {
+				continue
+			}
+			fields[name] = value(line[match[i*2]:match[i*2+1]])
+		}
+		return fields
+	}
+}
+
+// value turns text that looks like a number into a json.Number so that the
+// loggers can send it as a number.
+func value(text string) interface{} {
+	if numberPattern.MatchString(text) {
+		return json.Number(text)
+	}
+	return text
+}
+
+// newParsed finds the text of the message in the fields.
+func newParsed(fields map[string]interface{}) *processlogger.Parsed {
+	parsed := &processlogger.Parsed{Fields: fields}
+	for _, key := range messageKeys {
+		if text, ok := fields[key].(string); ok {
+			parsed.MessageKey = key
+			parsed.Message = text
+			break
+		}
+	}
+	return parsed
+}
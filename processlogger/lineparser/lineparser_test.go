@@ -0,0 +1,141 @@
+package lineparser
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/morfien101/launch/configfile"
+)
+
+func newParser(t *testing.T, conf configfile.LoggingConfig) *Parser {
+	p, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestParseJSON(t *testing.T) {
+	p := newParser(t, configfile.LoggingConfig{ParseJSON: true})
+	parsed := p.Parse(`{"msg":"done","level":"warn","id":12345678901234567890}`)
+	if parsed == nil {
+		t.Fatal("Line was not parsed")
+	}
+	if parsed.Message != "done" || parsed.MessageKey != "msg" || parsed.Fields["level"] != "warn" {
+		t.Logf("Parsed line is not as expected. Got: %+v", parsed)
+		t.Fail()
+	}
+	if parsed.Fields["id"] != json.Number("12345678901234567890") {
+		t.Logf("Numbers should keep their precision. Got: %v", parsed.Fields["id"])
+		t.Fail()
+	}
+
+	parsed = p.Parse(`{"message":"first","msg":"second"}`)
+	if parsed == nil || parsed.MessageKey != "message" {
+		t.Logf("message should be used before msg. Got: %+v", parsed)
+		t.Fail()
+	}
+	parsed = p.Parse(`{"status":200}`)
+	if parsed == nil || parsed.MessageKey != "" || parsed.Message != "" {
+		t.Logf("Lines without a message should still be parsed. Got: %+v", parsed)
+		t.Fail()
+	}
+}
+
+func TestParseFallsBack(t *testing.T) {
+	p := newParser(t, configfile.LoggingConfig{ParseJSON: true})
+	for _, line := range []string{"plain text", `{"broken":`, `["a","b"]`, `{"a":1} {"b":2}`, ""} {
+		if parsed := p.Parse(line); parsed != nil {
+			t.Logf("%q should not be parsed. Got: %+v", line, parsed)
+			t.Fail()
+		}
+	}
+
+	off := newParser(t, configfile.LoggingConfig{})
+	if parsed := off.Parse(`{"msg":"done"}`); parsed != nil {
+		t.Logf("Lines should not be parsed unless a parser is set")
+		t.Fail()
+	}
+}
+
+func TestParseLogfmt(t *testing.T) {
+	p := newParser(t, configfile.LoggingConfig{Parser: Logfmt})
+	parsed := p.Parse(`time=2020-01-02T03:04:05Z level=warn msg="request \"done\"" status=200 latency=0.25 path=/a=b empty= quoted="12"`)
+	if parsed == nil {
+		t.Fatal("Line was not parsed")
+	}
+	want := map[string]interface{}{
+		"time":    "2020-01-02T03:04:05Z",
+		"level":   "warn",
+		"msg":     `request "done"`,
+		"status":  json.Number("200"),
+		"latency": json.Number("0.25"),
+		"path":    "/a=b",
+		"empty":   "",
+		"quoted":  "12",
+	}
+	if len(parsed.Fields) != len(want) {
+		t.Logf("Parsed fields are not as expected. Got: %v", parsed.Fields)
+		t.Fail()
+	}
+	for key, value := range want {
+		if parsed.Fields[key] != value {
+			t.Logf("%s is not as expected. Want: %#v, Got: %#v", key, value, parsed.Fields[key])
+			t.Fail()
+		}
+	}
+	if parsed.MessageKey != "msg" || parsed.Message != `request "done"` {
+		t.Logf("Message is not as expected. Got: %+v", parsed)
+		t.Fail()
+	}
+
+	for _, line := range []string{"plain text", "level=info some words", `msg="not closed`, `msg="a"b`, "=value", ""} {
+		if parsed := p.Parse(line); parsed != nil {
+			t.Logf("%q should not be parsed. Got: %+v", line, parsed)
+			t.Fail()
+		}
+	}
+}
+
+func TestParseRegex(t *testing.T) {
+	p := newParser(t, configfile.LoggingConfig{
+		Parser:        Regex,
+		ParserPattern: `^(?P<remote>\S+) "(?P<request>[^"]*)" (?P<status>\d{3}) (?P<latency>\S+)(?: (?P<level>\w+))?`,
+	})
+	parsed := p.Parse(`10.0.0.1 "GET / HTTP/1.1" 503 12ms`)
+	if parsed == nil {
+		t.Fatal("Line was not parsed")
+	}
+	want := map[string]interface{}{
+		"remote":  "10.0.0.1",
+		"request": "GET / HTTP/1.1",
+		"status":  json.Number("503"),
+		"latency": "12ms",
+	}
+	if len(parsed.Fields) != len(want) {
+		t.Logf("Groups that did not match should be left out. Got: %v", parsed.Fields)
+		t.Fail()
+	}
+	for key, value := range want {
+		if parsed.Fields[key] != value {
+			t.Logf("%s is not as expected. Want: %#v, Got: %#v", key, value, parsed.Fields[key])
+			t.Fail()
+		}
+	}
+	if parsed := p.Parse("does not match"); parsed != nil {
+		t.Logf("Lines that do not match should not be parsed. Got: %+v", parsed)
+		t.Fail()
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	for _, conf := range []configfile.LoggingConfig{
+		{Parser: "xml"},
+		{Parser: Regex, ParserPattern: "(?P<broken"},
+	} {
+		if _, err := New(conf); err == nil {
+			t.Logf("Config should be rejected: %+v", conf)
+			t.Fail()
+		}
+	}
+}
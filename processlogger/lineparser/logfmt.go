@@ -0,0 +1,65 @@
+package lineparser
+
+import (
+	"strconv"
+	"strings"
+)
+
+// parseLogfmt decodes a line of key=value pairs. Values can be quoted to hold
+// spaces. Lines with words that are not pairs are treated as plain text.
+// Quoted values are always strings, other values that look like numbers are
+// sent as numbers.
+func parseLogfmt(line string) map[string]interface{} {
+	fields := map[string]interface{}{}
+	rest := strings.TrimSpace(line)
+	for rest != "" {
+		end := strings.IndexAny(rest, "= \t\"")
+		if end <= 0 || rest[end] != '=' {
+			return nil
+		}
+		key := rest[:end]
+		rest = rest[end+1:]
+
+		if strings.HasPrefix(rest, `"`) {
+			end = closingQuote(rest)
+			if end < 0 {
+				return nil
+			}
+			text, err := strconv.Unquote(rest[:end+1])
+			if err != nil {
+				return nil
+			}
+			fields[key] = text
+			rest = rest[end+1:]
+			if rest != "" && rest[0] != ' ' && rest[0] != '\t' {
+				return nil
+			}
+		} else {
+			end = strings.IndexAny(rest, " \t")
+			if end < 0 {
+				end = len(rest)
+			}
+			fields[key] = value(rest[:end])
+			rest = rest[end:]
+		}
+		rest = strings.TrimLeft(rest, " \t")
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	return fields
+}
+
+// closingQuote finds the quote that ends the value at the start of s. -1 is
+// returned if the value is not closed.
+func closingQuote(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
@@ -8,13 +8,15 @@
 package processlogger
 
 import (
+	"encoding/json"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/morfien101/launch/configfile"
 )
 
-// Pipe describes if the message came out of stdout or stderr
+// Pipe describes if the message came out of stdout, stderr or a log file
 type Pipe string
 
 const (
@@ -22,11 +24,27 @@ const (
 	STDERR = Pipe("e")
 	// STDOUT is used to indicate a message was from stdout
 	STDOUT = Pipe("o")
+	// FILE is used to indicate a message was read from a log file written by the process
+	FILE = Pipe("f")
 	// logBufferSize is how many logs can be in queue for each logging end point before we
 	// start dropping messages
 	logBufferSize = 10
 )
 
+// Name returns a human readable name for the pipe.
+func (p Pipe) Name() string {
+	switch p {
+	case STDOUT:
+		return "stdout"
+	case STDERR:
+		return "stderr"
+	case FILE:
+		return "file"
+	default:
+		return string(p)
+	}
+}
+
 // LogMessage is the box that needs to be created to ship a message to a
 // log forwarder.
 type LogMessage struct {
@@ -34,6 +52,48 @@ type LogMessage struct {
 	Pipe    Pipe
 	Config  configfile.LoggingConfig
 	Message string
+	// Time is when the message was captured. It also carries a monotonic
+	// clock reading which can be used to order messages.
+	Time time.Time
+	// PID is the process id of the process that wrote the message.
+	PID int
+	// ProcessType is the type of process that wrote the message. Eg. init or main.
+	ProcessType string
+	// Generation is the number of times the process has been restarted.
+	Generation int
+	// Sequence is incremented for each message captured from a process.
+	Sequence uint64
+	// Fields are extra details that the loggers attach to the message.
+	Fields map[string]string
+	// Parsed is the decoded message. It is nil if the message was not parsed.
+	Parsed *Parsed
+	// File is the path of the log file that the message was read from. It is
+	// empty for messages from stdout and stderr.
+	File string
+}
+
+// Parsed is a message that has been decoded into keys and values.
+type Parsed struct {
+	// Fields holds every key of the message.
+	Fields map[string]interface{}
+	// MessageKey is the key that the text of the message was found in. It is
+	// empty if the message does not have one.
+	MessageKey string
+	// Message is the text of the message.
+	Message string
+}
+
+// ValueText returns a parsed value as text. Strings are returned as they are
+// and anything else is written as JSON.
+func ValueText(value interface{}) string {
+	if text, ok := value.(string); ok {
+		return text
+	}
+	out, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(out)
 }
 
 // LogManager is used to collect, route and submit logs to the correct logging engines.
@@ -142,6 +202,12 @@ func (lm *LogManager) Submit(log LogMessage) {
 	if lm.terminated {
 		return
 	}
+	if log.Time.IsZero() {
+		log.Time = time.Now()
+	}
+	if log.Fields == nil {
+		log.Fields = log.Config.Fields
+	}
 	lm.activeLoggerQ[log.Config.Engine] <- &log
 }
 
@@ -0,0 +1,246 @@
+// Package logfilter applies the filters of a process to each line that it
+// writes before the line is passed to the log manager.
+// Lines are checked against the keep and drop patterns, then the sample
+// rules and finally secrets, redact rules and JSON keys are masked.
+package logfilter
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/morfien101/launch/configfile"
+	"github.com/morfien101/launch/secrets"
+)
+
+const (
+	// defaultReplacement is used by redact rules without a replacement.
+	defaultReplacement = secrets.Mask
+	defaultPer         = time.Second
+)
+
+// Filter holds the compiled filters of a process. A nil Filter lets every
+// line through unchanged.
+type Filter struct {
+	keep          []*regexp.Regexp
+	drop          []*regexp.Regexp
+	samplers      []*sampler
+	redactors     []redactor
+	maskKeys      map[string]bool
+	redactSecrets bool
+
+	// lock protects the sample counters as stdout and stderr share the filter.
+	lock sync.Mutex
+	// now is used by the rate limits. It is replaced in tests.
+	now func() time.Time
+}
+
+type redactor struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+// sampler keeps some of the lines that match its pattern.
+type sampler struct {
+	pattern *regexp.Regexp
+	every   uint64
+	limit   int
+	per     time.Duration
+
+	seen        uint64
+	windowStart time.Time
+	windowCount int
+}
+
+// New compiles the filters. Secrets are redacted unless redact_secrets is
+// false. nil is returned if that leaves nothing to do.
+// The configuration is expected to have been validated by the configfile package.
+func New(conf configfile.Filters) (*Filter, error) {
+	if conf.Empty() {
+		return &Filter{redactSecrets: true, now: time.Now}, nil
+	}
+	if !conf.SecretsRedacted() && len(conf.Keep) == 0 && len(conf.Drop) == 0 &&
+		len(conf.Sample) == 0 && len(conf.Redact) == 0 && len(conf.MaskJSONKeys) == 0 {
+		return nil, nil
+	}
+	f := &Filter{
+		redactSecrets: conf.SecretsRedacted(),
+		now:           time.Now,
+	}
+	var err error
+	if f.keep, err = compileAll(conf.Keep); err != nil {
+		return nil, err
+	}
+	if f.drop, err = compileAll(conf.Drop); err != nil {
+		return nil, err
+	}
+	for _, rule := range conf.Sample {
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		per := defaultPer
+		if rule.Per != "" {
+			if per, err = time.ParseDuration(rule.Per); err != nil {
+				return nil, err
+			}
+		}
+		f.samplers = append(f.samplers, &sampler{pattern: pattern, every: uint64(rule.Every), limit: rule.Limit, per: per})
+	}
+	for _, rule := range conf.Redact {
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		replacement := rule.Replacement
+		if replacement == "" {
+			replacement = defaultReplacement
+		}
+		f.redactors = append(f.redactors, redactor{pattern: pattern, replacement: replacement})
+	}
+	if len(conf.MaskJSONKeys) > 0 {
+		f.maskKeys = make(map[string]bool)
+		for _, key := range conf.MaskJSONKeys {
+			f.maskKeys[strings.ToLower(key)] = true
+		}
+	}
+	return f, nil
+}
+
+func compileAll(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// Apply runs the line through the filters. The line that should be logged is
+// returned along with false if the line should be dropped.
+func (f *Filter) Apply(line string) (string, bool) {
+	if f == nil {
+		return line, true
+	}
+	if len(f.keep) > 0 && !matchAny(f.keep, line) {
+		return "", false
+	}
+	if matchAny(f.drop, line) {
+		return "", false
+	}
+	if !f.sample(line) {
+		return "", false
+	}
+
+	if f.redactSecrets {
+		line = secrets.Scrub(line)
+	}
+	for _, r := range f.redactors {
+		line = r.pattern.ReplaceAllString(line, r.replacement)
+	}
+	if f.maskKeys != nil {
+		line = f.maskJSON(line)
+	}
+	return line, true
+}
+
+func matchAny(patterns []*regexp.Regexp, line string) bool {
+	for _, re := range patterns {
+		if re.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// sample finds the first sample rule that matches the line and asks it if
+// the line should be kept. Lines that do not match a rule are kept.
+func (f *Filter) sample(line string) bool {
+	for _, s := range f.samplers {
+		if !s.pattern.MatchString(line) {
+			continue
+		}
+		f.lock.Lock()
+		defer f.lock.Unlock()
+		return s.keep(f.now())
+	}
+	return true
+}
+
+// keep counts the line and tells us if it is the 1 in every N and if it fits
+// inside the rate limit. The caller must hold the filter lock.
+func (s *sampler) keep(now time.Time) bool {
+	s.seen++
+	if s.every > 0 && (s.seen-1)%s.every != 0 {
+		return false
+	}
+	if s.limit > 0 {
+		if now.Sub(s.windowStart) >= s.per {
+			s.windowStart = now
+			s.windowCount = 0
+		}
+		if s.windowCount >= s.limit {
+			return false
+		}
+		s.windowCount++
+	}
+	return true
+}
+
+// maskJSON replaces the values of the masked keys in a JSON object at any
+// depth. Lines that are not JSON objects or have nothing to mask are left as
+// they are, otherwise the keys are written in sorted order.
+func (f *Filter) maskJSON(line string) string {
+	trimmed := strings.TrimSpace(line)
+	if !strings.HasPrefix(trimmed, "{") {
+		return line
+	}
+	decoder := json.NewDecoder(strings.NewReader(trimmed))
+	decoder.UseNumber()
+	var object interface{}
+	if err := decoder.Decode(&object); err != nil || decoder.More() {
+		return line
+	}
+	if !f.mask(object) {
+		return line
+	}
+
+	out := &bytes.Buffer{}
+	encoder := json.NewEncoder(out)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(object); err != nil {
+		return line
+	}
+	return strings.TrimSuffix(out.String(), "\n")
+}
+
+// mask walks the value and reports if anything was masked.
+func (f *Filter) mask(value interface{}) bool {
+	masked := false
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if f.maskKeys[strings.ToLower(key)] {
+				v[key] = defaultReplacement
+				masked = true
+				continue
+			}
+			if f.mask(child) {
+				masked = true
+			}
+		}
+	case []interface{}:
+		for _, child := range v {
+			if f.mask(child) {
+				masked = true
+			}
+		}
+	}
+	return masked
+}
@@ -0,0 +1,155 @@
+package logfilter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/morfien101/launch/configfile"
+	"github.com/morfien101/launch/secrets"
+)
+
+func newFilter(t *testing.T, conf configfile.Filters) *Filter {
+	f, err := New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestNoFilters(t *testing.T) {
+	off := false
+	f := newFilter(t, configfile.Filters{RedactSecrets: &off})
+	if f != nil {
+		t.Logf("Filters with secrets not redacted should not create a filter")
+		t.Fail()
+	}
+	if line, keep := f.Apply("hello"); !keep || line != "hello" {
+		t.Logf("A nil filter should keep lines. Got: %s, %t", line, keep)
+		t.Fail()
+	}
+}
+
+func TestKeepAndDrop(t *testing.T) {
+	f := newFilter(t, configfile.Filters{
+		Keep: []string{"^GET", "^POST"},
+		Drop: []string{"/health"},
+	})
+	tests := map[string]bool{
+		"GET /users":   true,
+		"POST /users":  true,
+		"GET /health":  false,
+		"DELETE /user": false,
+	}
+	for line, want := range tests {
+		if _, keep := f.Apply(line); keep != want {
+			t.Logf("%s should be kept: %t", line, want)
+			t.Fail()
+		}
+	}
+}
+
+func TestSampleEvery(t *testing.T) {
+	f := newFilter(t, configfile.Filters{
+		Sample: []configfile.SampleRule{{Pattern: "debug", Every: 3}},
+	})
+	kept := 0
+	for i := 0; i < 9; i++ {
+		if _, keep := f.Apply("debug line"); keep {
+			kept++
+		}
+	}
+	if kept != 3 {
+		t.Logf("1 in 3 lines should be kept. Got: %d of 9", kept)
+		t.Fail()
+	}
+	if _, keep := f.Apply("info line"); !keep {
+		t.Logf("Lines that do not match a sample rule should be kept")
+		t.Fail()
+	}
+}
+
+func TestSampleLimit(t *testing.T) {
+	f := newFilter(t, configfile.Filters{
+		Sample: []configfile.SampleRule{{Pattern: "retry", Limit: 2, Per: "1m"}},
+	})
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	f.now = func() time.Time { return now }
+
+	count := func() int {
+		kept := 0
+		for i := 0; i < 5; i++ {
+			if _, keep := f.Apply("retry connecting"); keep {
+				kept++
+			}
+		}
+		return kept
+	}
+	if kept := count(); kept != 2 {
+		t.Logf("2 lines should be kept in the window. Got: %d", kept)
+		t.Fail()
+	}
+	now = now.Add(30 * time.Second)
+	if kept := count(); kept != 0 {
+		t.Logf("No lines should be kept until the window ends. Got: %d", kept)
+		t.Fail()
+	}
+	now = now.Add(30 * time.Second)
+	if kept := count(); kept != 2 {
+		t.Logf("2 lines should be kept in the next window. Got: %d", kept)
+		t.Fail()
+	}
+}
+
+func TestRedact(t *testing.T) {
+	defer secrets.Forget()
+	secrets.Remember("s3cr3t-value")
+	f := newFilter(t, configfile.Filters{
+		Redact: []configfile.RedactRule{
+			{Pattern: `\b\d{4}-\d{4}-\d{4}-\d{4}\b`},
+			{Pattern: `(password=)\S+`, Replacement: "${1}***"},
+		},
+	})
+	tests := map[string]string{
+		"card 1234-5678-9012-3456 used": "card [REDACTED] used",
+		"login password=hunter2 ok":     "login password=*** ok",
+		"token is s3cr3t-value":         "token is [REDACTED]",
+	}
+	for line, want := range tests {
+		if got, _ := f.Apply(line); got != want {
+			t.Logf("Line is not redacted as expected. Want: %s, Got: %s", want, got)
+			t.Fail()
+		}
+	}
+}
+
+func TestRedactSecretsByDefault(t *testing.T) {
+	defer secrets.Forget()
+	secrets.Remember("s3cr3t-value")
+	if got, _ := newFilter(t, configfile.Filters{}).Apply("token is s3cr3t-value"); got != "token is [REDACTED]" {
+		t.Logf("Secrets should be redacted when there are no filters. Got: %s", got)
+		t.Fail()
+	}
+	off := false
+	f := newFilter(t, configfile.Filters{Drop: []string{"health"}, RedactSecrets: &off})
+	if got, _ := f.Apply("token is s3cr3t-value"); got != "token is s3cr3t-value" {
+		t.Logf("Secrets should not be redacted when redact_secrets is false. Got: %s", got)
+		t.Fail()
+	}
+}
+
+func TestMaskJSONKeys(t *testing.T) {
+	f := newFilter(t, configfile.Filters{MaskJSONKeys: []string{"password", "Token"}})
+	tests := map[string]string{
+		`{"user":"bob","password":"hunter2","count":12345678901234567890}`:   `{"count":12345678901234567890,"password":"[REDACTED]","user":"bob"}`,
+		`{"auth":{"token":"abc","type":"<bearer>"},"list":[{"PASSWORD":1}]}`: `{"auth":{"token":"[REDACTED]","type":"<bearer>"},"list":[{"PASSWORD":"[REDACTED]"}]}`,
+		`{"user":"bob"}`:    `{"user":"bob"}`,
+		`password: hunter2`: `password: hunter2`,
+		`{"broken":`:        `{"broken":`,
+	}
+	for line, want := range tests {
+		if got, _ := f.Apply(line); got != want {
+			t.Logf("JSON is not masked as expected. Want: %s, Got: %s", want, got)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,302 @@
+// Package loglevel is used by the logging engines to work out the level of a log
+// message. Levels follow the syslog severities so that every logger can map them
+// onto whatever their backend understands.
+// Levels can be read from JSON logs using configurable key paths, numeric level
+// schemes such as the ones used by pino, bunyan and zap, and user defined aliases.
+package loglevel
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/morfien101/launch/configfile"
+)
+
+// Level is the severity of a log message. The values match the syslog severities.
+type Level int
+
+const (
+	// Emergency means the system is unusable
+	Emergency Level = iota
+	// Alert means action must be taken immediately
+	Alert
+	// Critical is for critical conditions
+	Critical
+	// Error is for error conditions
+	Error
+	// Warning is for warning conditions
+	Warning
+	// Notice is for normal but significant conditions
+	Notice
+	// Info is for informational messages
+	Info
+	// Debug is for debug messages
+	Debug
+)
+
+const (
+	defaultJSONKey = "level"
+
+	numericPino   = "pino"
+	numericBunyan = "bunyan"
+	numericZap    = "zap"
+	numericSyslog = "syslog"
+)
+
+var (
+	levelNames = map[Level]string{
+		Emergency: "emerg",
+		Alert:     "alert",
+		Critical:  "crit",
+		Error:     "err",
+		Warning:   "warning",
+		Notice:    "notice",
+		Info:      "info",
+		Debug:     "debug",
+	}
+
+	// knownNames are the level names that are understood without aliases.
+	knownNames = map[string]Level{
+		"emerg":     Emergency,
+		"emergency": Emergency,
+		"panic":     Emergency,
+		"alert":     Alert,
+		"crit":      Critical,
+		"critical":  Critical,
+		"fatal":     Critical,
+		"err":       Error,
+		"error":     Error,
+		"warning":   Warning,
+		"warn":      Warning,
+		"notice":    Notice,
+		"info":      Info,
+		"debug":     Debug,
+		"trace":     Debug,
+	}
+
+	numericSchemes = map[string]func(float64) Level{
+		numericPino:   pinoLevel,
+		numericBunyan: pinoLevel,
+		numericZap:    zapLevel,
+		numericSyslog: syslogLevel,
+	}
+)
+
+// String returns the syslog name of the level.
+func (l Level) String() string {
+	if name, ok := levelNames[l]; ok {
+		return name
+	}
+	return fmt.Sprintf("level(%d)", int(l))
+}
+
+// ParseName returns the level for a known level name. The name is not case sensitive.
+func ParseName(name string) (Level, bool) {
+	level, ok := knownNames[strings.ToLower(name)]
+	return level, ok
+}
+
+// Detector reads levels out of log messages.
+type Detector struct {
+	keys    [][]string
+	numeric func(float64) Level
+	aliases map[string]Level
+}
+
+// Default returns a Detector that looks for the level key only.
+func Default() *Detector {
+	return &Detector{
+		keys: [][]string{{defaultJSONKey}},
+	}
+}
+
+// NewDetector creates a Detector from the configuration. The process configuration
+// wins over the default configuration. Aliases from both are merged.
+// An error is returned if the configuration is not valid.
+func NewDetector(conf, defaults configfile.LevelDetection) (*Detector, error) {
+	d := Default()
+
+	keys := conf.JSONKeys
+	if len(keys) == 0 {
+		keys = defaults.JSONKeys
+	}
+	if len(keys) > 0 {
+		d.keys = make([][]string, len(keys))
+		for i, key := range keys {
+			if key == "" {
+				return nil, fmt.Errorf("json_keys can not contain an empty key")
+			}
+			d.keys[i] = strings.Split(key, ".")
+		}
+	}
+
+	numeric := conf.NumericLevels
+	if numeric == "" {
+		numeric = defaults.NumericLevels
+	}
+	if numeric != "" {
+		scheme, ok := numericSchemes[numeric]
+		if !ok {
+			return nil, fmt.Errorf("%s is not a valid numeric_levels value", numeric)
+		}
+		d.numeric = scheme
+	}
+
+	for _, aliases := range []map[string]string{defaults.Aliases, conf.Aliases} {
+		for alias, name := range aliases {
+			level, ok := ParseName(name)
+			if !ok {
+				return nil, fmt.Errorf("alias %s points to %s which is not a known level", alias, name)
+			}
+			if d.aliases == nil {
+				d.aliases = make(map[string]Level)
+			}
+			d.aliases[strings.ToLower(alias)] = level
+		}
+	}
+
+	return d, nil
+}
+
+// FromName returns the level for a name, checking the aliases first.
+// Unknown names are reported as Info.
+func (d *Detector) FromName(name string) Level {
+	name = strings.ToLower(name)
+	if level, ok := d.aliases[name]; ok {
+		return level
+	}
+	if level, ok := knownNames[name]; ok {
+		return level
+	}
+	return Info
+}
+
+// FromJSON reads the level from a JSON log. The key paths are tried in order
+// and the first one that is present is used.
+func (d *Detector) FromJSON(jsonlog []byte) (Level, error) {
+	decoded := map[string]interface{}{}
+	if err := json.Unmarshal(jsonlog, &decoded); err != nil {
+		return Info, fmt.Errorf("Failed to read json log. Error: %s", err)
+	}
+	return d.FromMap(decoded)
+}
+
+// FromMap reads the level from an already decoded JSON log.
+func (d *Detector) FromMap(decoded map[string]interface{}) (Level, error) {
+	for _, path := range d.keys {
+		value, ok := lookup(decoded, path)
+		if !ok {
+			continue
+		}
+		switch v := value.(type) {
+		case string:
+			if v == "" {
+				continue
+			}
+			return d.FromName(v), nil
+		case float64:
+			if d.numeric == nil {
+				return Info, fmt.Errorf("Found numeric level %v but numeric_levels is not set", v)
+			}
+			return d.numeric(v), nil
+		case json.Number:
+			if d.numeric == nil {
+				return Info, fmt.Errorf("Found numeric level %v but numeric_levels is not set", v)
+			}
+			number, err := v.Float64()
+			if err != nil {
+				return Info, fmt.Errorf("Failed to read numeric level %v. Error: %s", v, err)
+			}
+			return d.numeric(number), nil
+		}
+	}
+
+	return Info, fmt.Errorf("Failed to detect level in json log")
+}
+
+// FromRegex uses the regex to find the level in a plain text log line.
+// The level is taken from the capture group named level, or the first capture
+// group if there is no group with that name.
+func (d *Detector) FromRegex(re *regexp.Regexp, line string) (Level, error) {
+	matches := re.FindStringSubmatch(line)
+	if matches == nil {
+		return Info, fmt.Errorf("Failed to match level in log line")
+	}
+	index := re.SubexpIndex("level")
+	if index < 0 {
+		index = 1
+	}
+	if index >= len(matches) || matches[index] == "" {
+		return Info, fmt.Errorf("Failed to detect level in log line")
+	}
+
+	return d.FromName(matches[index]), nil
+}
+
+// lookup walks the decoded JSON following the path.
+func lookup(decoded map[string]interface{}, path []string) (interface{}, bool) {
+	var current interface{} = decoded
+	for _, key := range path {
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		current, ok = object[key]
+		if !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// pinoLevel translates pino and bunyan levels.
+// 10 trace, 20 debug, 30 info, 40 warn, 50 error, 60 fatal
+func pinoLevel(value float64) Level {
+	switch {
+	case value <= 20:
+		return Debug
+	case value <= 30:
+		return Info
+	case value <= 40:
+		return Warning
+	case value <= 50:
+		return Error
+	default:
+		return Critical
+	}
+}
+
+// zapLevel translates zap levels.
+// -1 debug, 0 info, 1 warn, 2 error, 3 dpanic, 4 panic, 5 fatal
+func zapLevel(value float64) Level {
+	switch {
+	case value < 0:
+		return Debug
+	case value < 1:
+		return Info
+	case value < 2:
+		return Warning
+	case value < 3:
+		return Error
+	case value < 4:
+		return Critical
+	case value < 5:
+		return Alert
+	default:
+		return Emergency
+	}
+}
+
+// syslogLevel translates numeric syslog severities.
+func syslogLevel(value float64) Level {
+	switch {
+	case value <= float64(Emergency):
+		return Emergency
+	case value >= float64(Debug):
+		return Debug
+	default:
+		return Level(value)
+	}
+}
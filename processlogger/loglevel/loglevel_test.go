@@ -0,0 +1,305 @@
+package loglevel
+
+import (
+	"encoding/json"
+	"regexp"
+	"testing"
+
+	"github.com/morfien101/launch/configfile"
+)
+
+func TestDetectorFromJSON(t *testing.T) {
+	tests := []struct {
+		name          string
+		conf          configfile.LevelDetection
+		defaults      configfile.LevelDetection
+		JSONBlob      []byte
+		expectedLevel Level
+		expectError   bool
+	}{
+		{
+			name:          "default key",
+			JSONBlob:      []byte(`{"msg":"hi","level":"WARN"}`),
+			expectedLevel: Warning,
+		},
+		{
+			name:          "severity key",
+			conf:          configfile.LevelDetection{JSONKeys: []string{"severity"}},
+			JSONBlob:      []byte(`{"severity":"WARNING"}`),
+			expectedLevel: Warning,
+		},
+		{
+			name:          "nested numeric pino",
+			conf:          configfile.LevelDetection{JSONKeys: []string{"log.level"}, NumericLevels: "pino"},
+			JSONBlob:      []byte(`{"log":{"level":30}}`),
+			expectedLevel: Info,
+		},
+		{
+			name:          "bunyan fatal",
+			conf:          configfile.LevelDetection{NumericLevels: "bunyan"},
+			JSONBlob:      []byte(`{"level":60}`),
+			expectedLevel: Critical,
+		},
+		{
+			name:          "zap numeric",
+			conf:          configfile.LevelDetection{NumericLevels: "zap"},
+			JSONBlob:      []byte(`{"level":2}`),
+			expectedLevel: Error,
+		},
+		{
+			name:          "alias from defaults",
+			conf:          configfile.LevelDetection{JSONKeys: []string{"lvl"}},
+			defaults:      configfile.LevelDetection{Aliases: map[string]string{"eror": "error"}},
+			JSONBlob:      []byte(`{"lvl":"eror"}`),
+			expectedLevel: Error,
+		},
+		{
+			name:          "first present key wins",
+			conf:          configfile.LevelDetection{JSONKeys: []string{"missing", "severity", "level"}},
+			JSONBlob:      []byte(`{"level":"debug","severity":"crit"}`),
+			expectedLevel: Critical,
+		},
+		{
+			name:        "numeric without scheme",
+			JSONBlob:    []byte(`{"level":30}`),
+			expectError: true,
+		},
+		{
+			name:        "not json",
+			JSONBlob:    []byte(`level=info`),
+			expectError: true,
+		},
+		{
+			name:        "key missing",
+			JSONBlob:    []byte(`{"msg":"no level here"}`),
+			expectError: true,
+		},
+	}
+
+	for _, test := range tests {
+		d, err := NewDetector(test.conf, test.defaults)
+		if err != nil {
+			t.Fatalf("Test %s failed to create a detector. Error: %s", test.name, err)
+		}
+		out, err := d.FromJSON(test.JSONBlob)
+		if test.expectError {
+			if err == nil {
+				t.Logf("Test %s should have returned an error", test.name)
+				t.Fail()
+			}
+			continue
+		}
+		if err != nil {
+			t.Logf("Test %s failed to see level, error %s", test.name, err)
+			t.Fail()
+		}
+		if test.expectedLevel != out {
+			t.Logf("Log level for test %s returned is not expected. Want: %v, Got: %v", test.name, test.expectedLevel, out)
+			t.Fail()
+		}
+	}
+}
+
+func TestInvalidDetectorConfig(t *testing.T) {
+	tests := []configfile.LevelDetection{
+		{NumericLevels: "log4j"},
+		{Aliases: map[string]string{"eror": "broken"}},
+		{JSONKeys: []string{""}},
+	}
+	for _, test := range tests {
+		if _, err := NewDetector(test, configfile.LevelDetection{}); err == nil {
+			t.Logf("Configuration %+v should have been rejected", test)
+			t.Fail()
+		}
+	}
+}
+
+func TestLevelString(t *testing.T) {
+	if Warning.String() != "warning" {
+		t.Logf("Warning should be named warning. Got: %s", Warning)
+		t.Fail()
+	}
+	if level, ok := ParseName(Critical.String()); !ok || level != Critical {
+		t.Logf("Level names should parse back to the level. Got: %s", level)
+		t.Fail()
+	}
+}
+
+func TestDetectorFromMapNumbers(t *testing.T) {
+	d, err := NewDetector(configfile.LevelDetection{NumericLevels: "pino"}, configfile.LevelDetection{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	level, err := d.FromMap(map[string]interface{}{"level": json.Number("40")})
+	if err != nil || level != Warning {
+		t.Logf("Numeric level is not as expected. Got: %s, Error: %v", level, err)
+		t.Fail()
+	}
+}
+
+func TestDefaultDetectorFromJSON(t *testing.T) {
+	tests := []struct {
+		name          string
+		JSONBlob      []byte
+		expectedLevel Level
+	}{
+		{
+			name:          "info",
+			JSONBlob:      []byte(`{"test_key":"test_value","level":"info"}`),
+			expectedLevel: Info,
+		},
+		{
+			name:          "INFO",
+			JSONBlob:      []byte(`{"test_key":"test_value","level":"INFO"}`),
+			expectedLevel: Info,
+		},
+		{
+			name:          "Info",
+			JSONBlob:      []byte(`{"test_key":"test_value","level":"Info"}`),
+			expectedLevel: Info,
+		},
+		{
+			name:          "notice",
+			JSONBlob:      []byte(`{"test_key":"test_value","level":"notice"}`),
+			expectedLevel: Notice,
+		},
+		{
+			name:          "warning",
+			JSONBlob:      []byte(`{"test_key":"test_value","level":"warning"}`),
+			expectedLevel: Warning,
+		},
+		{
+			name:          "warn",
+			JSONBlob:      []byte(`{"test_key":"test_value","level":"warn"}`),
+			expectedLevel: Warning,
+		},
+		{
+			name:          "err",
+			JSONBlob:      []byte(`{"test_key":"test_value","level":"err"}`),
+			expectedLevel: Error,
+		},
+		{
+			name:          "error",
+			JSONBlob:      []byte(`{"test_key":"test_value","level":"error"}`),
+			expectedLevel: Error,
+		},
+		{
+			name:          "crit",
+			JSONBlob:      []byte(`{"test_key":"test_value","level":"crit"}`),
+			expectedLevel: Critical,
+		},
+		{
+			name:          "alert",
+			JSONBlob:      []byte(`{"test_key":"test_value","level":"alert"}`),
+			expectedLevel: Alert,
+		},
+		{
+			name:          "emerg",
+			JSONBlob:      []byte(`{"test_key":"test_value","level":"emerg"}`),
+			expectedLevel: Emergency,
+		},
+		{
+			name:          "debug",
+			JSONBlob:      []byte(`{"test_key":"test_value","level":"debug"}`),
+			expectedLevel: Debug,
+		},
+		{
+			name:          "unknown",
+			JSONBlob:      []byte(`{"test_key":"test_value","level":"not_valid"}`),
+			expectedLevel: Info,
+		},
+	}
+
+	for _, test := range tests {
+		out, err := Default().FromJSON(test.JSONBlob)
+		if err != nil {
+			t.Logf("Test failed to see level, error %s", err)
+			t.Fail()
+		}
+		if test.expectedLevel != out {
+			t.Logf("Log level for test %s returned is not expected. Want: %v, Got: %v", test.name, test.expectedLevel, out)
+			t.Fail()
+		}
+		t.Logf("%s translates to log level: %v", test.name, out)
+	}
+}
+
+func benchExtractJSON(b *testing.B, JSONBlob []byte) Level {
+	var lvl Level
+	for n := 0; n < b.N; n++ {
+		lvl, _ = Default().FromJSON(JSONBlob)
+	}
+	return lvl
+}
+
+func BenchmarkExtractJSONLevelSmall(b *testing.B) {
+	JSONBlob := []byte(`{"test_key_1":"test_value_1","test_key_2":"test_value_2","level":"crit"}`)
+	result := benchExtractJSON(b, JSONBlob)
+	b.Logf("Blob size: %d. Last result: %v", len(JSONBlob), result)
+}
+
+func BenchmarkExtractJSONLevelMed(b *testing.B) {
+	JSONBlob := []byte(`{"test_key_1":"test_value_1","test_key_2":"test_value_2","test_key_text":"this is a log messsage. It needs to be a bit long to make sure that we don't slow down too much while extracting the level","level":"crit"}`)
+	result := benchExtractJSON(b, JSONBlob)
+	b.Logf("Blob size: %d. Last result: %v", len(JSONBlob), result)
+}
+
+func BenchmarkExtractJSONLevelLarge(b *testing.B) {
+	JSONBlob := []byte(`{"test_key_1":"test_value_1","test_key_2":"test_value_2","test_key_text_1":"this is a log messsage. It needs to be a bit long to make sure that we don't slow down too much while extracting the level","test_key_text_2":"this is a log messsage. It needs to be a bit long to make sure that we don't slow down too much while extracting the level","test_key_text_3":"this is a log messsage. It needs to be a bit long to make sure that we don't slow down too much while extracting the level","level":"crit"}`)
+	result := benchExtractJSON(b, JSONBlob)
+	b.Logf("Blob size: %d. Last result: %v", len(JSONBlob), result)
+}
+
+func TestDefaultDetectorFromRegex(t *testing.T) {
+	tests := []struct {
+		name          string
+		regex         string
+		line          string
+		expectedLevel Level
+		expectError   bool
+	}{
+		{
+			name:          "bracket warn",
+			regex:         `^\[(WARN|ERROR)\]`,
+			line:          "[WARN] disk is nearly full",
+			expectedLevel: Warning,
+		},
+		{
+			name:          "bracket error",
+			regex:         `^\[(WARN|ERROR)\]`,
+			line:          "[ERROR] disk is full",
+			expectedLevel: Error,
+		},
+		{
+			name:          "named group",
+			regex:         `^(?P<date>\S+) (?P<level>\w+):`,
+			line:          "2020-01-01 debug: starting",
+			expectedLevel: Debug,
+		},
+		{
+			name:        "no match",
+			regex:       `^\[(WARN|ERROR)\]`,
+			line:        "[INFO] all good",
+			expectError: true,
+		},
+	}
+
+	for _, test := range tests {
+		out, err := Default().FromRegex(regexp.MustCompile(test.regex), test.line)
+		if test.expectError {
+			if err == nil {
+				t.Logf("Test %s should have failed to find a level", test.name)
+				t.Fail()
+			}
+			continue
+		}
+		if err != nil {
+			t.Logf("Test %s failed to see level, error %s", test.name, err)
+			t.Fail()
+		}
+		if test.expectedLevel != out {
+			t.Logf("Log level for test %s returned is not expected. Want: %v, Got: %v", test.name, test.expectedLevel, out)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,445 @@
+// Package loki ships logs to Grafana Loki using the push API.
+// Messages are grouped into streams by their labels and pushed in batches as
+// JSON or as snappy compressed protobuf.
+// The connection and batching settings are taken from the default logger
+// configuration as all the processes share the same batches.
+// See https://grafana.com/docs/loki/latest/reference/api/#push-log-entries-to-loki
+package loki
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/morfien101/launch/configfile"
+	"github.com/morfien101/launch/processlogger"
+	"github.com/morfien101/launch/processlogger/batcher"
+)
+
+const (
+	// LoggerTag is used to identify the logger
+	LoggerTag = "loki"
+
+	formatJSON     = "json"
+	formatProtobuf = "protobuf"
+
+	labelProcess = "process"
+	labelPipe    = "pipe"
+	labelHost    = "host"
+
+	pushPath = "/loki/api/v1/push"
+	// processManagerName is used for the process manager as it has no process name.
+	processManagerName = "launch"
+
+	defaultBatchSize     = 1000
+	defaultBatchBytes    = 1024 * 1024
+	defaultFlushInterval = time.Second
+	defaultTimeout       = time.Second * 10
+	defaultMaxRetries    = 3
+	defaultRetryBackoff  = time.Millisecond * 500
+)
+
+var (
+	contentTypes = map[string]string{
+		formatJSON:     "application/json",
+		formatProtobuf: "application/x-protobuf",
+	}
+	validStreamLabels = map[string]bool{
+		labelProcess: true,
+		labelPipe:    true,
+		labelHost:    true,
+	}
+	defaultStreamLabels = []string{labelProcess, labelPipe, labelHost}
+	// labelNamePattern is the label names that Loki accepts.
+	labelNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+func init() {
+	processlogger.RegisterLogger(LoggerTag, func() processlogger.Logger {
+		return &Loki{}
+	})
+}
+
+// Loki batches log messages and pushes them to Loki.
+type Loki struct {
+	config   configfile.Loki
+	endpoint string
+	client   *http.Client
+	batcher  *batcher.Batcher
+	hostname string
+
+	flushInterval time.Duration
+	retryBackoff  time.Duration
+	maxRetries    int
+
+	// labels holds the static labels for each process keyed by process name.
+	labels map[string]map[string]string
+	// streamLabels holds the labels taken from each message keyed by process name.
+	streamLabels map[string][]string
+	// streams caches the encoded labels of each process and pipe.
+	streams map[string]map[processlogger.Pipe][]byte
+	// sleep is used to wait between retries. It is replaced in tests.
+	sleep func(time.Duration)
+}
+
+// entry is a single log line in a stream.
+type entry struct {
+	time time.Time
+	line string
+}
+
+// RegisterConfig validates the Loki settings and works out the labels for the
+// process. Only the label settings can be set on a process.
+func (l *Loki) RegisterConfig(conf configfile.LoggingConfig, defaults configfile.DefaultLoggerDetails) error {
+	shared := conf.Loki
+	shared.Labels, shared.StreamLabels = nil, nil
+	if !reflect.DeepEqual(shared, configfile.Loki{}) {
+		return fmt.Errorf("process %s has loki connection settings. Only labels and stream_labels can be set on a process", conf.ProcessName)
+	}
+	if err := l.configure(defaults.Config.Loki); err != nil {
+		return fmt.Errorf("the loki logger configuration is invalid. Error: %s", err)
+	}
+
+	streamLabels := conf.Loki.StreamLabels
+	if streamLabels == nil {
+		streamLabels = defaults.Config.Loki.StreamLabels
+	}
+	if streamLabels == nil {
+		streamLabels = defaultStreamLabels
+	}
+	for _, name := range streamLabels {
+		if !validStreamLabels[name] {
+			return fmt.Errorf("process %s has an invalid loki stream label %s. Use %s, %s or %s", conf.ProcessName, name, labelProcess, labelPipe, labelHost)
+		}
+	}
+
+	// The fields of the logging config become labels and the loki labels win.
+	labels := make(map[string]string)
+	for _, static := range []map[string]string{conf.Fields, defaults.Config.Loki.Labels, conf.Loki.Labels} {
+		for name, value := range static {
+			if !labelNamePattern.MatchString(name) {
+				return fmt.Errorf("process %s has an invalid loki label name %s", conf.ProcessName, name)
+			}
+			labels[name] = value
+		}
+	}
+	if len(streamLabels) == 0 && len(labels) == 0 {
+		return fmt.Errorf("process %s has no loki labels. Loki needs at least one label", conf.ProcessName)
+	}
+
+	if l.labels == nil {
+		l.labels = make(map[string]map[string]string)
+		l.streamLabels = make(map[string][]string)
+		l.streams = make(map[string]map[processlogger.Pipe][]byte)
+	}
+	l.labels[conf.ProcessName] = labels
+	l.streamLabels[conf.ProcessName] = streamLabels
+	l.streams[conf.ProcessName] = map[processlogger.Pipe][]byte{
+		processlogger.STDOUT: l.streamKey(conf.ProcessName, processlogger.STDOUT),
+		processlogger.STDERR: l.streamKey(conf.ProcessName, processlogger.STDERR),
+		processlogger.FILE:   l.streamKey(conf.ProcessName, processlogger.FILE),
+	}
+	return nil
+}
+
+// configure checks the settings and fills in the defaults.
+func (l *Loki) configure(config configfile.Loki) error {
+	if config.URL == "" {
+		return fmt.Errorf("url is required")
+	}
+	endpoint, err := url.Parse(config.URL)
+	if err != nil {
+		return fmt.Errorf("url is not valid. Error: %s", err)
+	}
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" {
+		return fmt.Errorf("url must use http or https. Got: %s", config.URL)
+	}
+	if endpoint.Path == "" || endpoint.Path == "/" {
+		endpoint.Path = pushPath
+	}
+
+	if config.Format == "" {
+		config.Format = formatJSON
+	}
+	if _, ok := contentTypes[config.Format]; !ok {
+		return fmt.Errorf("format must be %s or %s. Got: %s", formatJSON, formatProtobuf, config.Format)
+	}
+	if config.Password != "" && config.Username == "" {
+		return fmt.Errorf("username is required when a password is set")
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaultBatchSize
+	}
+	if config.BatchBytes == 0 {
+		config.BatchBytes = defaultBatchBytes
+	}
+	maxRetries := defaultMaxRetries
+	if config.MaxRetries != nil {
+		maxRetries = *config.MaxRetries
+	}
+	if maxRetries < 0 {
+		return fmt.Errorf("max_retries can not be negative. Got: %d", maxRetries)
+	}
+
+	flushInterval, err := batcher.ParseDuration("flush_interval", config.FlushInterval, defaultFlushInterval)
+	if err != nil {
+		return err
+	}
+	timeout, err := batcher.ParseDuration("timeout", config.Timeout, defaultTimeout)
+	if err != nil {
+		return err
+	}
+	retryBackoff, err := batcher.ParseDuration("retry_backoff", config.RetryBackoff, defaultRetryBackoff)
+	if err != nil {
+		return err
+	}
+
+	l.config = config
+	l.endpoint = endpoint.String()
+	l.flushInterval = flushInterval
+	l.retryBackoff = retryBackoff
+	l.maxRetries = maxRetries
+	l.client = &http.Client{Timeout: timeout}
+	l.hostname, err = os.Hostname()
+	if err != nil {
+		l.hostname = "not_available"
+	}
+	return nil
+}
+
+// streamKey encodes the labels of the stream that a message from the process
+// and pipe belongs in. JSON is used as it sorts the label names.
+func (l *Loki) streamKey(processName string, pipe processlogger.Pipe) []byte {
+	labels := make(map[string]string, len(l.labels[processName])+3)
+	for name, value := range l.labels[processName] {
+		labels[name] = value
+	}
+	for _, name := range l.streamLabels[processName] {
+		switch name {
+		case labelProcess:
+			labels[name] = processName
+			if processName == "" {
+				labels[name] = processManagerName
+			}
+		case labelPipe:
+			labels[name] = pipe.Name()
+		case labelHost:
+			labels[name] = l.hostname
+		}
+	}
+	key, _ := json.Marshal(labels)
+	return key
+}
+
+// Start starts the batching of messages.
+func (l *Loki) Start() error {
+	if l.sleep == nil {
+		l.sleep = time.Sleep
+	}
+	l.batcher = batcher.New(
+		batcher.Config{
+			MaxItems: l.config.BatchSize,
+			MaxBytes: int(l.config.BatchBytes.Bytes()),
+			Interval: l.flushInterval,
+			Name:     "loki logger",
+		},
+		l.send,
+	)
+	l.batcher.Start()
+	return nil
+}
+
+// Shutdown pushes the messages that are waiting and stops the logger.
+func (l *Loki) Shutdown() chan error {
+	c := make(chan error, 1)
+	go func() {
+		defer close(c)
+		if l.batcher == nil {
+			c <- nil
+			return
+		}
+		if err := l.batcher.Stop(); err != nil {
+			c <- fmt.Errorf("failed to push the last batch of logs. Error: %s", err)
+			return
+		}
+		c <- nil
+	}()
+	return c
+}
+
+// Submit adds the message to the batch along with the labels of its stream.
+func (l *Loki) Submit(msg processlogger.LogMessage) {
+	key, ok := l.streams[msg.Config.ProcessName][msg.Pipe]
+	if !ok {
+		if _, ok := l.labels[msg.Config.ProcessName]; !ok {
+			return
+		}
+		key = l.streamKey(msg.Config.ProcessName, msg.Pipe)
+	}
+	timestamp := msg.Time
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+	l.batcher.Add(encodeEntry(key, timestamp, strings.TrimSuffix(msg.Message, "\n")))
+}
+
+// encodeEntry packs the stream key, time and line of a message into a batch item.
+func encodeEntry(key []byte, timestamp time.Time, line string) []byte {
+	b := make([]byte, 0, len(key)+len(line)+2*binary.MaxVarintLen64)
+	b = binary.AppendUvarint(b, uint64(len(key)))
+	b = append(b, key...)
+	b = binary.AppendVarint(b, timestamp.UnixNano())
+	return append(b, line...)
+}
+
+func decodeEntry(item []byte) (string, entry, error) {
+	size, n := binary.Uvarint(item)
+	if n <= 0 || int(size) > len(item)-n {
+		return "", entry{}, fmt.Errorf("batch item is corrupt")
+	}
+	key := string(item[n : n+int(size)])
+	item = item[n+int(size):]
+	nanos, n := binary.Varint(item)
+	if n <= 0 {
+		return "", entry{}, fmt.Errorf("batch item is corrupt")
+	}
+	return key, entry{time: time.Unix(0, nanos), line: string(item[n:])}, nil
+}
+
+// groupStreams splits a batch into streams. The streams and the entries in
+// each stream keep the order they were submitted in.
+func groupStreams(batch [][]byte) ([]string, map[string][]entry, error) {
+	keys := []string{}
+	streams := make(map[string][]entry)
+	for _, item := range batch {
+		key, e, err := decodeEntry(item)
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, ok := streams[key]; !ok {
+			keys = append(keys, key)
+		}
+		streams[key] = append(streams[key], e)
+	}
+	return keys, streams, nil
+}
+
+// send pushes a batch. Requests that fail to connect or get a 5xx or 429
+// response are retried with an increasing wait between each try.
+func (l *Loki) send(batch [][]byte) error {
+	body, err := l.body(batch)
+	if err != nil {
+		return err
+	}
+
+	retry := batcher.Retry{MaxRetries: l.maxRetries, Backoff: l.retryBackoff, Sleep: l.sleep}
+	return retry.Send(len(batch), func() (bool, error) {
+		return l.post(body)
+	})
+}
+
+// body creates the push request in the configured format.
+func (l *Loki) body(batch [][]byte) ([]byte, error) {
+	keys, streams, err := groupStreams(batch)
+	if err != nil {
+		return nil, err
+	}
+	if l.config.Format == formatProtobuf {
+		return protobufBody(keys, streams)
+	}
+	return jsonBody(keys, streams)
+}
+
+type jsonStream struct {
+	Stream json.RawMessage `json:"stream"`
+	Values [][2]string     `json:"values"`
+}
+
+func jsonBody(keys []string, streams map[string][]entry) ([]byte, error) {
+	request := struct {
+		Streams []jsonStream `json:"streams"`
+	}{}
+	for _, key := range keys {
+		stream := jsonStream{Stream: json.RawMessage(key)}
+		for _, e := range streams[key] {
+			stream.Values = append(stream.Values, [2]string{strconv.FormatInt(e.time.UnixNano(), 10), e.line})
+		}
+		request.Streams = append(request.Streams, stream)
+	}
+	return json.Marshal(request)
+}
+
+func protobufBody(keys []string, streams map[string][]entry) ([]byte, error) {
+	request := []byte{}
+	for _, key := range keys {
+		labels := map[string]string{}
+		if err := json.Unmarshal([]byte(key), &labels); err != nil {
+			return nil, fmt.Errorf("failed to read stream labels. Error: %s", err)
+		}
+		request = appendStream(request, labelString(labels), streams[key])
+	}
+	return snappy.Encode(nil, request), nil
+}
+
+// labelString writes the labels in the selector form that the protobuf push
+// request uses, eg. {host="web1", process="web"}.
+func labelString(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, name+"="+strconv.Quote(labels[name]))
+	}
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
+
+// post makes a single request. It tells the caller if the request can be
+// tried again.
+func (l *Loki) post(body []byte) (bool, error) {
+	request, err := http.NewRequest(http.MethodPost, l.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Content-Type", contentTypes[l.config.Format])
+	request.Header.Set("User-Agent", "launch")
+	if l.config.TenantID != "" {
+		request.Header.Set("X-Scope-OrgID", l.config.TenantID)
+	}
+	if l.config.Username != "" {
+		request.SetBasicAuth(l.config.Username, l.config.Password)
+	}
+
+	response, err := l.client.Do(request)
+	if err != nil {
+		return true, err
+	}
+	defer response.Body.Close()
+	// Loki explains why a push was rejected in the body.
+	message, _ := ioutil.ReadAll(io.LimitReader(response.Body, 1024))
+	io.Copy(ioutil.Discard, response.Body)
+
+	switch {
+	case response.StatusCode < 300:
+		return false, nil
+	case response.StatusCode >= 500 || response.StatusCode == http.StatusTooManyRequests:
+		return true, fmt.Errorf("got status %s: %s", response.Status, strings.TrimSpace(string(message)))
+	default:
+		return false, fmt.Errorf("got status %s: %s", response.Status, strings.TrimSpace(string(message)))
+	}
+}
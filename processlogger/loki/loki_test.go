@@ -0,0 +1,257 @@
+package loki
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/morfien101/launch/configfile"
+	"github.com/morfien101/launch/processlogger"
+)
+
+// receiver is a test Loki that records the requests it is sent.
+type receiver struct {
+	sync.Mutex
+	bodies   [][]byte
+	requests []*http.Request
+	statuses []int
+	server   *httptest.Server
+}
+
+func newReceiver(statuses ...int) *receiver {
+	r := &receiver{statuses: statuses}
+	r.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		r.Lock()
+		defer r.Unlock()
+		r.bodies = append(r.bodies, body)
+		r.requests = append(r.requests, req)
+		status := http.StatusNoContent
+		if len(r.statuses) > 0 {
+			status = r.statuses[0]
+			r.statuses = r.statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	return r
+}
+
+func (r *receiver) received() ([][]byte, []*http.Request) {
+	r.Lock()
+	defer r.Unlock()
+	return append([][]byte{}, r.bodies...), append([]*http.Request{}, r.requests...)
+}
+
+func startLogger(t *testing.T, config configfile.Loki, confs ...configfile.LoggingConfig) *Loki {
+	defaults := configfile.DefaultLoggerDetails{Config: configfile.LoggingConfig{Loki: config}}
+	l := &Loki{sleep: func(time.Duration) {}}
+	for _, conf := range confs {
+		if err := l.RegisterConfig(conf, defaults); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Start(); err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+type pushRequest struct {
+	Streams []struct {
+		Stream map[string]string `json:"stream"`
+		Values [][2]string       `json:"values"`
+	} `json:"streams"`
+}
+
+func TestJSONPush(t *testing.T) {
+	r := newReceiver()
+	defer r.server.Close()
+
+	web := configfile.LoggingConfig{
+		ProcessName: "web",
+		Fields:      map[string]string{"version": "1.2.3", "env": "dev"},
+		Loki:        configfile.Loki{Labels: map[string]string{"team": "payments"}},
+	}
+	worker := configfile.LoggingConfig{ProcessName: "worker", Loki: configfile.Loki{StreamLabels: []string{labelProcess}}}
+	l := startLogger(t, configfile.Loki{
+		URL:      r.server.URL,
+		TenantID: "tenant1",
+		Username: "user",
+		Password: "secret",
+		Labels:   map[string]string{"env": "prod", "team": "default"},
+	}, web, worker)
+
+	captured := time.Unix(1577934245, 123)
+	l.Submit(processlogger.LogMessage{Source: "web", Pipe: processlogger.STDOUT, Config: web, Message: "one\n", Time: captured})
+	l.Submit(processlogger.LogMessage{Source: "worker", Pipe: processlogger.STDERR, Config: worker, Message: "two\n", Time: captured})
+	l.Submit(processlogger.LogMessage{Source: "web", Pipe: processlogger.STDOUT, Config: web, Message: "three\n", Time: captured})
+	if err := <-l.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+
+	bodies, requests := r.received()
+	if len(bodies) != 1 {
+		t.Fatalf("Expected the batch to be pushed in 1 request. Got: %d", len(bodies))
+	}
+	request := requests[0]
+	user, password, _ := request.BasicAuth()
+	if request.URL.Path != pushPath || request.Header.Get("X-Scope-OrgID") != "tenant1" || user != "user" || password != "secret" {
+		t.Logf("Request is not as expected. Path: %s, Headers: %v", request.URL.Path, request.Header)
+		t.Fail()
+	}
+
+	push := pushRequest{}
+	if err := json.Unmarshal(bodies[0], &push); err != nil {
+		t.Fatalf("Body is not JSON. Got: %s", bodies[0])
+	}
+	if len(push.Streams) != 2 {
+		t.Fatalf("Expected 2 streams. Got: %s", bodies[0])
+	}
+	first, second := push.Streams[0], push.Streams[1]
+	wantLabels := map[string]string{"process": "web", "pipe": "stdout", "host": l.hostname, "env": "prod", "team": "payments", "version": "1.2.3"}
+	for name, value := range wantLabels {
+		if first.Stream[name] != value {
+			t.Logf("Label %s is not as expected. Want: %s, Got: %s", name, value, first.Stream[name])
+			t.Fail()
+		}
+	}
+	if len(first.Values) != 2 || first.Values[0] != [2]string{"1577934245000000123", "one"} || first.Values[1][1] != "three" {
+		t.Logf("Entries are not as expected. Got: %v", first.Values)
+		t.Fail()
+	}
+	if _, ok := second.Stream["pipe"]; ok || second.Stream["process"] != "worker" {
+		t.Logf("Stream labels of the process were not used. Got: %v", second.Stream)
+		t.Fail()
+	}
+}
+
+// readField reads the next protobuf field and returns its number, the value
+// of varints and the contents of length delimited fields.
+func readField(data []byte) (int, uint64, []byte, []byte) {
+	tag, n := binary.Uvarint(data)
+	data = data[n:]
+	value, n := binary.Uvarint(data)
+	data = data[n:]
+	if tag&7 == wireVarint {
+		return int(tag >> 3), value, nil, data
+	}
+	return int(tag >> 3), 0, data[:value], data[value:]
+}
+
+func TestProtobufPush(t *testing.T) {
+	r := newReceiver()
+	defer r.server.Close()
+
+	conf := configfile.LoggingConfig{ProcessName: "web"}
+	l := startLogger(t, configfile.Loki{URL: r.server.URL, Format: formatProtobuf, StreamLabels: []string{labelProcess, labelPipe}}, conf)
+	l.Submit(processlogger.LogMessage{Source: "web", Pipe: processlogger.STDERR, Config: conf, Message: "broken\n", Time: time.Unix(100, 5)})
+	if err := <-l.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+
+	bodies, requests := r.received()
+	if len(bodies) != 1 || requests[0].Header.Get("Content-Type") != "application/x-protobuf" {
+		t.Fatalf("Expected a protobuf request. Got: %d requests", len(bodies))
+	}
+	request, err := snappy.Decode(nil, bodies[0])
+	if err != nil {
+		t.Fatalf("Body is not snappy compressed. Error: %s", err)
+	}
+
+	_, _, stream, _ := readField(request)
+	_, _, labels, rest := readField(stream)
+	if string(labels) != `{pipe="stderr", process="web"}` {
+		t.Logf("Labels are not as expected. Got: %s", labels)
+		t.Fail()
+	}
+	_, _, e, _ := readField(rest)
+	_, _, timestamp, rest := readField(e)
+	_, _, line, _ := readField(rest)
+	_, seconds, _, rest := readField(timestamp)
+	_, nanos, _, _ := readField(rest)
+	if string(line) != "broken" || seconds != 100 || nanos != 5 {
+		t.Logf("Entry is not as expected. Line: %s, Time: %d.%d", line, seconds, nanos)
+		t.Fail()
+	}
+}
+
+func TestRetries(t *testing.T) {
+	r := newReceiver(http.StatusServiceUnavailable, http.StatusTooManyRequests)
+	defer r.server.Close()
+
+	conf := configfile.LoggingConfig{ProcessName: "web"}
+	l := startLogger(t, configfile.Loki{URL: r.server.URL}, conf)
+	l.Submit(processlogger.LogMessage{Source: "web", Pipe: processlogger.STDOUT, Config: conf, Message: "retry me\n"})
+	if err := <-l.Shutdown(); err != nil {
+		t.Fatalf("The batch should be pushed on the third attempt. Error: %s", err)
+	}
+	if bodies, _ := r.received(); len(bodies) != 3 {
+		t.Logf("Expected 3 attempts. Got: %d", len(bodies))
+		t.Fail()
+	}
+
+	rejected := newReceiver(http.StatusBadRequest)
+	defer rejected.server.Close()
+	l = startLogger(t, configfile.Loki{URL: rejected.server.URL}, conf)
+	l.Submit(processlogger.LogMessage{Source: "web", Pipe: processlogger.STDOUT, Config: conf, Message: "bad\n"})
+	if err := <-l.Shutdown(); err == nil {
+		t.Logf("A rejected batch should not be retried and should return an error")
+		t.Fail()
+	}
+	if bodies, _ := rejected.received(); len(bodies) != 1 {
+		t.Logf("Expected 1 attempt. Got: %d", len(bodies))
+		t.Fail()
+	}
+}
+
+func TestRetriesTurnedOff(t *testing.T) {
+	r := newReceiver(http.StatusServiceUnavailable)
+	defer r.server.Close()
+
+	conf := configfile.LoggingConfig{ProcessName: "web"}
+	retries := 0
+	l := startLogger(t, configfile.Loki{URL: r.server.URL, MaxRetries: &retries}, conf)
+	l.Submit(processlogger.LogMessage{Source: "web", Pipe: processlogger.STDOUT, Config: conf, Message: "once\n"})
+	if err := <-l.Shutdown(); err == nil {
+		t.Logf("A failed push should return an error")
+		t.Fail()
+	}
+	if bodies, _ := r.received(); len(bodies) != 1 {
+		t.Logf("max_retries of 0 should not retry. Got: %d attempts", len(bodies))
+		t.Fail()
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	tests := []struct {
+		config configfile.Loki
+		conf   configfile.LoggingConfig
+	}{
+		{config: configfile.Loki{}},
+		{config: configfile.Loki{URL: "loki:3100"}},
+		{config: configfile.Loki{URL: "http://loki:3100", Format: "xml"}},
+		{config: configfile.Loki{URL: "http://loki:3100", Password: "secret"}},
+		{config: configfile.Loki{URL: "http://loki:3100", StreamLabels: []string{"level"}}},
+		{config: configfile.Loki{URL: "http://loki:3100", Labels: map[string]string{"bad-name": "1"}}},
+		{config: configfile.Loki{URL: "http://loki:3100", FlushInterval: "soon"}},
+		{config: configfile.Loki{URL: "http://loki:3100"}, conf: configfile.LoggingConfig{Loki: configfile.Loki{URL: "http://other:3100"}}},
+		{
+			config: configfile.Loki{URL: "http://loki:3100"},
+			conf:   configfile.LoggingConfig{Loki: configfile.Loki{StreamLabels: []string{}}},
+		},
+	}
+	for _, test := range tests {
+		l := &Loki{}
+		defaults := configfile.DefaultLoggerDetails{Config: configfile.LoggingConfig{Loki: test.config}}
+		if err := l.RegisterConfig(test.conf, defaults); err == nil {
+			t.Logf("Config should be rejected: %+v %+v", test.config, test.conf.Loki)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package loki
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// The push API only needs a few protobuf messages so they are written here
+// rather than bringing in the protobuf libraries.
+// See https://github.com/grafana/loki/blob/main/pkg/push/push.proto
+//
+// message PushRequest { repeated StreamAdapter streams = 1; }
+// message StreamAdapter { string labels = 1; repeated EntryAdapter entries = 2; }
+// message EntryAdapter { google.protobuf.Timestamp timestamp = 1; string line = 2; }
+// message Timestamp { int64 seconds = 1; int32 nanos = 2; }
+
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+func appendTag(b []byte, field int, wireType int) []byte {
+	return binary.AppendUvarint(b, uint64(field<<3|wireType))
+}
+
+func appendVarintField(b []byte, field int, value uint64) []byte {
+	b = appendTag(b, field, wireVarint)
+	return binary.AppendUvarint(b, value)
+}
+
+func appendBytesField(b []byte, field int, value []byte) []byte {
+	b = appendTag(b, field, wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(value)))
+	return append(b, value...)
+}
+
+func appendStringField(b []byte, field int, value string) []byte {
+	b = appendTag(b, field, wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(value)))
+	return append(b, value...)
+}
+
+// appendTimestamp writes a google.protobuf.Timestamp. Fields with the zero
+// value are left out as protobuf expects.
+func appendTimestamp(b []byte, field int, t time.Time) []byte {
+	timestamp := []byte{}
+	if seconds := t.Unix(); seconds != 0 {
+		timestamp = appendVarintField(timestamp, 1, uint64(seconds))
+	}
+	if nanos := t.Nanosecond(); nanos != 0 {
+		timestamp = appendVarintField(timestamp, 2, uint64(nanos))
+	}
+	return appendBytesField(b, field, timestamp)
+}
+
+// appendStream writes a StreamAdapter as field 1 of a PushRequest.
+func appendStream(b []byte, labels string, entries []entry) []byte {
+	stream := appendStringField(nil, 1, labels)
+	for _, e := range entries {
+		encoded := appendTimestamp(nil, 1, e.time)
+		encoded = appendStringField(encoded, 2, e.line)
+		stream = appendBytesField(stream, 2, encoded)
+	}
+	return appendBytesField(b, 1, stream)
+}
@@ -1,53 +1,43 @@
 package syslog
 
 import (
-	"encoding/json"
-	"fmt"
-	"strings"
-
+	"github.com/morfien101/launch/processlogger/loglevel"
 	syslogger "github.com/silverstagtech/srslog"
 )
 
-type level struct {
-	LVL string `json:"level"`
+// facilities maps the facility names that can be used in the configuration to
+// syslog facilities.
+var facilities = map[string]syslogger.Priority{
+	"kern":     syslogger.LOG_KERN,
+	"user":     syslogger.LOG_USER,
+	"mail":     syslogger.LOG_MAIL,
+	"daemon":   syslogger.LOG_DAEMON,
+	"auth":     syslogger.LOG_AUTH,
+	"syslog":   syslogger.LOG_SYSLOG,
+	"lpr":      syslogger.LOG_LPR,
+	"news":     syslogger.LOG_NEWS,
+	"uucp":     syslogger.LOG_UUCP,
+	"cron":     syslogger.LOG_CRON,
+	"authpriv": syslogger.LOG_AUTHPRIV,
+	"ftp":      syslogger.LOG_FTP,
+	"local0":   syslogger.LOG_LOCAL0,
+	"local1":   syslogger.LOG_LOCAL1,
+	"local2":   syslogger.LOG_LOCAL2,
+	"local3":   syslogger.LOG_LOCAL3,
+	"local4":   syslogger.LOG_LOCAL4,
+	"local5":   syslogger.LOG_LOCAL5,
+	"local6":   syslogger.LOG_LOCAL6,
+	"local7":   syslogger.LOG_LOCAL7,
 }
 
-func extractJSONlevel(jsonlog []byte) (syslogger.Priority, error) {
-	lvl := &level{}
-	err := json.Unmarshal(jsonlog, lvl)
-	if err != nil {
-		return syslogger.LOG_INFO, fmt.Errorf("Failed to read json log. Error: %s", err)
-	}
-	if lvl.LVL == "" {
-		return syslogger.LOG_INFO, fmt.Errorf("Failed to detect level in json log")
-	}
-
-	return detectLevel(strings.ToLower(lvl.LVL)), nil
+// toPriority converts a level into a syslog severity.
+// loglevel levels use the same values as the syslog severities.
+func toPriority(level loglevel.Level) syslogger.Priority {
+	return syslogger.Priority(level)
 }
 
-func detectLevel(level string) syslogger.Priority {
-	switch level {
-	case "emerg":
-		return syslogger.LOG_EMERG
-	case "alert":
-		return syslogger.LOG_ALERT
-	case "crit":
-		return syslogger.LOG_CRIT
-	case "err":
-		return syslogger.LOG_ERR
-	case "error":
-		return syslogger.LOG_ERR
-	case "warning":
-		return syslogger.LOG_WARNING
-	case "warn":
-		return syslogger.LOG_WARNING
-	case "notice":
-		return syslogger.LOG_NOTICE
-	case "info":
-		return syslogger.LOG_INFO
-	case "debug":
-		return syslogger.LOG_DEBUG
-	default:
-		return syslogger.LOG_INFO
-	}
+// parseSeverity converts a severity name from the configuration.
+func parseSeverity(name string) (syslogger.Priority, bool) {
+	level, ok := loglevel.ParseName(name)
+	return toPriority(level), ok
 }
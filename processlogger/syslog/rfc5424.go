@@ -0,0 +1,184 @@
+package syslog
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/morfien101/launch/processlogger"
+	syslogger "github.com/silverstagtech/srslog"
+)
+
+const (
+	formatDefault = ""
+	formatRFC3164 = "rfc3164"
+	formatRFC5424 = "rfc5424"
+
+	framingNonTransparent = "non_transparent"
+	framingOctetCounting  = "octet_counting"
+
+	// defaultStructuredDataID uses the private enterprise number reserved for
+	// documentation in RFC 5612.
+	defaultStructuredDataID = "launch@32473"
+	// nilValue is used by RFC 5424 when a header field has no value.
+	nilValue = "-"
+
+	rfc5424TimeFormat   = "2006-01-02T15:04:05.000000Z07:00"
+	maxHostnameLength   = 255
+	maxAppNameLength    = 48
+	maxMessageIDLength  = 32
+	maxSDNameLength     = 32
+	sdParamSource       = "source"
+	sdParamPipe         = "pipe"
+	sdParamProcessName  = "process_name"
+	sdParamHostBasename = "container_hostname"
+	sdParamProcessType  = "process_type"
+	sdParamRestarts     = "restart_count"
+	sdParamSequence     = "sequence"
+	sdParamFile         = "file"
+)
+
+var (
+	validFormats = map[string]syslogger.Formatter{
+		formatDefault: syslogger.DefaultFormatter,
+		formatRFC3164: syslogger.RFC3164Formatter,
+		formatRFC5424: rfc5424Formatter,
+	}
+	validFramers = map[string]syslogger.Framer{
+		"":                    syslogger.DefaultFramer,
+		framingNonTransparent: syslogger.DefaultFramer,
+		framingOctetCounting:  syslogger.RFC5425MessageLengthFramer,
+	}
+)
+
+// rfc5424Formatter writes the priority and version of a RFC 5424 message. The
+// content passed in is expected to already contain the rest of the header, the
+// STRUCTURED-DATA and MSG parts. This allows the timestamp and PROCID to come
+// from the captured message rather than the time and process of sending.
+// The trailing new line added by the writer is removed as the framing decides
+// how messages are separated.
+func rfc5424Formatter(p syslogger.Priority, _, _, content string) string {
+	return fmt.Sprintf("<%d>1 %s", p, strings.TrimSuffix(content, "\n"))
+}
+
+// headerField makes a value safe to use as a RFC 5424 header field.
+// Header fields are printable US-ASCII without spaces.
+func headerField(value string, max int) string {
+	value = strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return '_'
+		}
+		return r
+	}, value)
+	if value == "" {
+		return nilValue
+	}
+	if len(value) > max {
+		return value[:max]
+	}
+	return value
+}
+
+// sdName makes a value safe to use as a SD-ID or PARAM-NAME.
+// '=', ' ', ']' and '"' are not allowed in names.
+func sdName(value string) string {
+	value = strings.Map(func(r rune) rune {
+		switch {
+		case r < 33 || r > 126:
+			return '_'
+		case r == '=' || r == ']' || r == '"':
+			return '_'
+		}
+		return r
+	}, value)
+	if len(value) > maxSDNameLength {
+		return value[:maxSDNameLength]
+	}
+	return value
+}
+
+// sdValue escapes the characters that RFC 5424 requires in PARAM-VALUE.
+func sdValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(value)
+}
+
+// structuredData builds the STRUCTURED-DATA part of the message from the
+// message, its fields and the static fields in the configuration.
+func (sl *Syslog) structuredData(msg processlogger.LogMessage) string {
+	id := msg.Config.Syslog.StructuredDataID
+	if id == "" {
+		id = sl.defaults.Config.Syslog.StructuredDataID
+	}
+	if id == "" {
+		id = defaultStructuredDataID
+	}
+
+	params := map[string]string{}
+	for key, value := range msg.Fields {
+		params[key] = value
+	}
+	for key, value := range sl.defaults.Config.Syslog.StructuredData {
+		params[key] = value
+	}
+	for key, value := range msg.Config.Syslog.StructuredData {
+		params[key] = value
+	}
+	params[sdParamSource] = msg.Source
+	params[sdParamPipe] = msg.Pipe.Name()
+	params[sdParamProcessName] = msg.Config.ProcessName
+	params[sdParamHostBasename] = sl.basename
+	params[sdParamProcessType] = msg.ProcessType
+	params[sdParamRestarts] = strconv.Itoa(msg.Generation)
+	if msg.Sequence != 0 {
+		params[sdParamSequence] = strconv.FormatUint(msg.Sequence, 10)
+	}
+	if msg.File != "" {
+		params[sdParamFile] = msg.File
+	}
+
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var sd strings.Builder
+	sd.WriteString("[" + sdName(id))
+	for _, key := range keys {
+		if params[key] == "" {
+			continue
+		}
+		sd.WriteString(fmt.Sprintf(` %s="%s"`, sdName(key), sdValue(params[key])))
+	}
+	sd.WriteString("]")
+	return sd.String()
+}
+
+// rfc5424Content builds everything after the version of a RFC 5424 message.
+// TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+func (sl *Syslog) rfc5424Content(msg processlogger.LogMessage, hostname, tag string) string {
+	msgID := msg.Config.Syslog.MessageID
+	if msgID == "" {
+		msgID = sl.defaults.Config.Syslog.MessageID
+	}
+	timestamp := msg.Time
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+	pid := msg.PID
+	if pid == 0 {
+		pid = os.Getpid()
+	}
+	return fmt.Sprintf("%s %s %s %d %s %s %s",
+		timestamp.Format(rfc5424TimeFormat),
+		headerField(hostname, maxHostnameLength),
+		headerField(tag, maxAppNameLength),
+		pid,
+		headerField(msgID, maxMessageIDLength),
+		sl.structuredData(msg),
+		msg.Message,
+	)
+}
@@ -0,0 +1,185 @@
+package syslog
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/morfien101/launch/configfile"
+	"github.com/morfien101/launch/processlogger"
+	syslogger "github.com/silverstagtech/srslog"
+)
+
+func TestStructuredData(t *testing.T) {
+	sl := &Syslog{
+		basename: "container1",
+		defaults: configfile.DefaultLoggerDetails{
+			Config: configfile.LoggingConfig{
+				Syslog: configfile.Syslog{
+					StructuredData: map[string]string{
+						"env":  "prod",
+						"team": "default",
+					},
+				},
+			},
+		},
+	}
+	msg := processlogger.LogMessage{
+		Source: "web",
+		Pipe:   processlogger.STDERR,
+		Fields: map[string]string{"version": "1.2.3", "env": "dev"},
+		Config: configfile.LoggingConfig{
+			ProcessName: "web",
+			Syslog: configfile.Syslog{
+				StructuredData: map[string]string{
+					"team":   "payments",
+					"quoted": `a "b" [c] \d`,
+				},
+			},
+		},
+	}
+
+	want := `[launch@32473 container_hostname="container1" env="prod" pipe="stderr" process_name="web" quoted="a \"b\" [c\] \\d" restart_count="0" source="web" team="payments" version="1.2.3"]`
+	got := sl.structuredData(msg)
+	if got != want {
+		t.Logf("Structured data is not as expected.\nWant: %s\nGot:  %s", want, got)
+		t.Fail()
+	}
+}
+
+func TestRFC5424Message(t *testing.T) {
+	sl := &Syslog{}
+	captured := time.Date(2020, 1, 2, 3, 4, 5, 6000, time.UTC)
+	msg := processlogger.LogMessage{
+		Source:      "web",
+		Pipe:        processlogger.STDOUT,
+		Message:     "hello\n",
+		Time:        captured,
+		PID:         1234,
+		ProcessType: "main",
+		Sequence:    7,
+		Config: configfile.LoggingConfig{
+			Syslog: configfile.Syslog{MessageID: "ID1"},
+		},
+	}
+	out := rfc5424Formatter(syslogger.LOG_DAEMON|syslogger.LOG_INFO, "", "", sl.rfc5424Content(msg, "my host", ""))
+	parts := strings.SplitN(out, " ", 8)
+	if len(parts) != 8 {
+		t.Fatalf("Formatted message does not have enough parts. Got: %s", out)
+	}
+	want := []string{"<30>1", "2020-01-02T03:04:05.000006Z", "my_host", nilValue, "1234", "ID1"}
+	for i, part := range want {
+		if parts[i] != part {
+			t.Logf("Header field %d is not as expected. Want: %s, Got: %s", i, part, parts[i])
+			t.Fail()
+		}
+	}
+	if !strings.Contains(parts[7], `process_type="main"`) || !strings.Contains(parts[7], `sequence="7"`) {
+		t.Logf("Structured data is missing the process details. Got: %s", parts[7])
+		t.Fail()
+	}
+	if !strings.HasSuffix(out, "] hello") {
+		t.Logf("Message content is not as expected. Got: %q", out)
+		t.Fail()
+	}
+}
+
+func TestOctetCountedRFC5424(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		length, err := reader.ReadString(' ')
+		if err != nil {
+			return
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(length))
+		if err != nil {
+			return
+		}
+		frame := make([]byte, n)
+		if _, err := io.ReadFull(reader, frame); err != nil {
+			return
+		}
+		received <- string(frame)
+	}()
+
+	conf := configfile.LoggingConfig{
+		Engine:      LoggerTag,
+		ProcessName: "test_proc",
+	}
+	defaults := configfile.DefaultLoggerDetails{
+		Config: configfile.LoggingConfig{
+			Syslog: configfile.Syslog{
+				Address:        listener.Addr().String(),
+				ConnectionType: tcpConnection,
+				Format:         formatRFC5424,
+				Framing:        framingOctetCounting,
+				MessageID:      "launch",
+			},
+		},
+	}
+	sl := &Syslog{loggingFacility: syslogger.LOG_DAEMON}
+	if err := sl.RegisterConfig(conf, defaults); err != nil {
+		t.Fatal(err)
+	}
+	if err := sl.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { <-sl.Shutdown() }()
+
+	sl.Submit(processlogger.LogMessage{
+		Source:  "test_proc",
+		Pipe:    processlogger.STDOUT,
+		Config:  conf,
+		Message: "hello world\n",
+	})
+
+	select {
+	case frame := <-received:
+		if !strings.HasPrefix(frame, "<30>1 ") {
+			t.Logf("Frame does not start with the RFC 5424 header. Got: %s", frame)
+			t.Fail()
+		}
+		if !strings.Contains(frame, ` launch [launch@32473 `) {
+			t.Logf("Frame does not contain the message id and structured data. Got: %s", frame)
+			t.Fail()
+		}
+		if !strings.HasSuffix(frame, "] hello world") {
+			t.Logf("Frame does not end with the message. Got: %q", frame)
+			t.Fail()
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatal("Timed out waiting for the syslog frame")
+	}
+}
+
+func TestOctetCountingRequiresStream(t *testing.T) {
+	defaults := configfile.DefaultLoggerDetails{
+		Config: configfile.LoggingConfig{
+			Syslog: configfile.Syslog{
+				ConnectionType: udpConnection,
+				Framing:        framingOctetCounting,
+			},
+		},
+	}
+	sl := &Syslog{}
+	if err := sl.RegisterConfig(configfile.LoggingConfig{}, defaults); err == nil {
+		t.Logf("Octet counting over UDP should be rejected")
+		t.Fail()
+	}
+}
@@ -1,14 +1,14 @@
 package syslog
 
 import (
-	"crypto/tls"
-	"crypto/x509"
 	"fmt"
-	"io/ioutil"
 	"os"
+	"regexp"
+	"time"
 
 	"github.com/morfien101/launch/configfile"
 	"github.com/morfien101/launch/processlogger"
+	"github.com/morfien101/launch/processlogger/loglevel"
 	syslogger "github.com/silverstagtech/srslog"
 )
 
@@ -16,16 +16,25 @@ const (
 	tlsConnection = "tcp+tls"
 	tcpConnection = "tcp"
 	udpConnection = "udp"
+	// unixConnection and unixgramConnection are used for the local syslog socket.
+	unixConnection     = "unix"
+	unixgramConnection = "unixgram"
+	// customConnection tells the syslog writer to use our own dialer.
+	customConnection = "custom"
 	// LoggerTag will be used to call this package
 	LoggerTag       = "syslog"
 	defaultProtocol = tlsConnection
+	// defaultSocket is used when no address is given for a unix socket.
+	defaultSocket = "/dev/log"
 )
 
 var (
 	validDialers = map[string]bool{
-		tlsConnection: true,
-		tcpConnection: true,
-		udpConnection: true,
+		tlsConnection:      true,
+		tcpConnection:      true,
+		udpConnection:      true,
+		unixConnection:     true,
+		unixgramConnection: true,
 	}
 )
 
@@ -34,6 +43,11 @@ func isValidDialer(s string) bool {
 	return ok
 }
 
+// isLocal tells us if the protocol connects to a syslog daemon on this host.
+func isLocal(s string) bool {
+	return s == unixConnection || s == unixgramConnection
+}
+
 func init() {
 	processlogger.RegisterLogger(LoggerTag, func() processlogger.Logger {
 		return &Syslog{
@@ -47,13 +61,23 @@ func init() {
 type Syslog struct {
 	location        string
 	protocol        string
-	tlsconfig       *tls.Config
+	certificates    *certificateStore
 	config          configfile.LoggingConfig
 	defaults        configfile.DefaultLoggerDetails
 	logwriter       *syslogger.Writer
 	running         bool
 	loggingFacility syslogger.Priority
 
+	// levelRegexes holds the compiled level_regex values keyed by the pattern.
+	levelRegexes map[string]*regexp.Regexp
+	// detectors holds the level detectors keyed by process name.
+	detectors map[string]*loglevel.Detector
+
+	// stopWatcher is used to stop the certificate watcher on shutdown.
+	stopWatcher chan bool
+	// certificateCheckInterval overrides how often certificate files are checked.
+	certificateCheckInterval time.Duration
+
 	// hostname is the name that you want to appear in syslog message
 	hostname string
 	// basename is the containers hostname and can be appended to the hostname
@@ -67,24 +91,6 @@ func (sl *Syslog) isStarted() bool {
 	return sl.running
 }
 
-func (sl *Syslog) readCertificates() (*x509.CertPool, error) {
-	if sl.defaults.Config.Syslog.CertificateBundlePath == "" {
-		return nil, fmt.Errorf("No certificate bundle specified")
-	}
-	certbundle, err := ioutil.ReadFile(sl.defaults.Config.Syslog.CertificateBundlePath)
-	if err != nil {
-		return nil, err
-	}
-
-	roots := x509.NewCertPool()
-	ok := roots.AppendCertsFromPEM(certbundle)
-	if !ok {
-		return nil, fmt.Errorf("failed to parse the given certificate bundle")
-	}
-
-	return roots, nil
-}
-
 // RegisterConfig does nothing here.
 func (sl *Syslog) RegisterConfig(config configfile.LoggingConfig, defaults configfile.DefaultLoggerDetails) error {
 	sl.config = config
@@ -97,17 +103,48 @@ func (sl *Syslog) RegisterConfig(config configfile.LoggingConfig, defaults confi
 		return fmt.Errorf("%s is not a valid protocol to connect to syslog", sl.defaults.Config.Syslog.ConnectionType)
 	}
 
+	if _, ok := validFormats[sl.defaults.Config.Syslog.Format]; !ok {
+		return fmt.Errorf("%s is not a valid syslog format", sl.defaults.Config.Syslog.Format)
+	}
+	if _, ok := validFramers[sl.defaults.Config.Syslog.Framing]; !ok {
+		return fmt.Errorf("%s is not a valid syslog framing", sl.defaults.Config.Syslog.Framing)
+	}
+	if sl.defaults.Config.Syslog.Framing == framingOctetCounting {
+		switch sl.defaults.Config.Syslog.ConnectionType {
+		case udpConnection, unixgramConnection:
+			return fmt.Errorf("%s framing can not be used with the %s protocol", framingOctetCounting, sl.defaults.Config.Syslog.ConnectionType)
+		}
+	}
+	if sl.defaults.Config.Syslog.Address == "" && isLocal(sl.defaults.Config.Syslog.ConnectionType) {
+		sl.defaults.Config.Syslog.Address = defaultSocket
+	}
+
+	if err := sl.registerLevels(sl.defaults.Config.Syslog); err != nil {
+		return err
+	}
+	if err := sl.registerLevels(sl.config.Syslog); err != nil {
+		return fmt.Errorf("process %s has an invalid syslog configuration. Error: %s", sl.config.ProcessName, err)
+	}
+	if facility, ok := facilities[sl.defaults.Config.Syslog.Facility]; ok {
+		sl.loggingFacility = facility
+	}
+	detector, err := loglevel.NewDetector(sl.config.LevelDetection, sl.defaults.Config.LevelDetection)
+	if err != nil {
+		return fmt.Errorf("process %s has an invalid level_detection configuration. Error: %s", sl.config.ProcessName, err)
+	}
+	if sl.detectors == nil {
+		sl.detectors = make(map[string]*loglevel.Detector)
+	}
+	sl.detectors[sl.config.ProcessName] = detector
+
 	if sl.defaults.Config.Syslog.ConnectionType == tlsConnection {
-		roots, err := sl.readCertificates()
+		certificates, err := newCertificateStore(sl.defaults.Config.Syslog)
 		if err != nil {
 			return err
 		}
-		sl.tlsconfig = &tls.Config{
-			RootCAs: roots,
-		}
+		sl.certificates = certificates
 	}
 
-	var err error
 	sl.basename, err = os.Hostname()
 	if err != nil {
 		sl.basename = "not_available"
@@ -131,18 +168,18 @@ func (sl *Syslog) Start() error {
 	var err error
 	switch sl.defaults.Config.Syslog.ConnectionType {
 	case tlsConnection:
-		writer, err = syslogger.DialWithTLSConfig(
-			tlsConnection,
+		writer, err = syslogger.DialWithCustomDialer(
+			customConnection,
 			sl.defaults.Config.Syslog.Address,
-			syslogger.LOG_INFO|syslogger.LOG_KERN,
+			syslogger.LOG_INFO|sl.loggingFacility,
 			sl.defaults.Config.Syslog.ProgramName,
-			sl.tlsconfig,
+			sl.dialTLS,
 		)
-	case tcpConnection, udpConnection:
+	case tcpConnection, udpConnection, unixConnection, unixgramConnection:
 		writer, err = syslogger.Dial(
 			sl.defaults.Config.Syslog.ConnectionType,
 			sl.defaults.Config.Syslog.Address,
-			syslogger.LOG_INFO|syslogger.LOG_KERN,
+			syslogger.LOG_INFO|sl.loggingFacility,
 			sl.defaults.Config.Syslog.ProgramName,
 		)
 	default:
@@ -153,11 +190,121 @@ func (sl *Syslog) Start() error {
 		return fmt.Errorf("failed to connect to Syslog server because: %s", err)
 	}
 
+	formatter := validFormats[sl.defaults.Config.Syslog.Format]
+	if sl.defaults.Config.Syslog.Format == formatDefault && isLocal(sl.defaults.Config.Syslog.ConnectionType) {
+		// The local daemon adds the hostname itself.
+		formatter = syslogger.UnixFormatter
+	}
+	writer.SetFormatter(formatter)
+	writer.SetFramer(validFramers[sl.defaults.Config.Syslog.Framing])
+
 	sl.logwriter = writer
 	sl.running = true
+
+	if sl.certificates != nil && sl.defaults.Config.Syslog.ReloadCertificates {
+		sl.stopWatcher = make(chan bool, 1)
+		go sl.watchCertificates()
+	}
+	return nil
+}
+
+// registerLevels validates the facility, severities and level regex in the
+// configuration. Level regexes are compiled once here and reused for each message.
+func (sl *Syslog) registerLevels(conf configfile.Syslog) error {
+	if _, ok := facilities[conf.Facility]; !ok && conf.Facility != "" {
+		return fmt.Errorf("%s is not a valid syslog facility", conf.Facility)
+	}
+	for _, severity := range []string{conf.StdoutSeverity, conf.StderrSeverity} {
+		if _, ok := parseSeverity(severity); !ok && severity != "" {
+			return fmt.Errorf("%s is not a valid syslog severity", severity)
+		}
+	}
+	if conf.LevelRegex == "" {
+		return nil
+	}
+	if sl.levelRegexes == nil {
+		sl.levelRegexes = make(map[string]*regexp.Regexp)
+	}
+	if _, ok := sl.levelRegexes[conf.LevelRegex]; ok {
+		return nil
+	}
+	re, err := regexp.Compile(conf.LevelRegex)
+	if err != nil {
+		return fmt.Errorf("failed to compile level_regex. Error: %s", err)
+	}
+	if re.NumSubexp() == 0 {
+		return fmt.Errorf("level_regex %s must have a capture group for the level", conf.LevelRegex)
+	}
+	sl.levelRegexes[conf.LevelRegex] = re
 	return nil
 }
 
+// detector returns the level detector for the process that sent the message.
+func (sl *Syslog) detector(msg processlogger.LogMessage) *loglevel.Detector {
+	if detector, ok := sl.detectors[msg.Config.ProcessName]; ok {
+		return detector
+	}
+	return loglevel.Default()
+}
+
+// facility returns the facility for the message. The process configuration
+// wins over the default configuration.
+func (sl *Syslog) facility(msg processlogger.LogMessage) syslogger.Priority {
+	if facility, ok := facilities[msg.Config.Syslog.Facility]; ok {
+		return facility
+	}
+	return sl.loggingFacility
+}
+
+// pipeSeverity returns the severity configured for the pipe that the message
+// came from. STDOUT is info and STDERR is crit unless configured otherwise.
+// Lines from log files use the STDOUT severity.
+func (sl *Syslog) pipeSeverity(msg processlogger.LogMessage) syslogger.Priority {
+	var configured, fallback string
+	var level syslogger.Priority
+	switch msg.Pipe {
+	case processlogger.STDOUT, processlogger.FILE:
+		configured, fallback, level = msg.Config.Syslog.StdoutSeverity, sl.defaults.Config.Syslog.StdoutSeverity, syslogger.LOG_INFO
+	case processlogger.STDERR:
+		configured, fallback, level = msg.Config.Syslog.StderrSeverity, sl.defaults.Config.Syslog.StderrSeverity, syslogger.LOG_CRIT
+	}
+	if severity, ok := parseSeverity(configured); ok {
+		return severity
+	}
+	if severity, ok := parseSeverity(fallback); ok {
+		return severity
+	}
+	return level
+}
+
+// detectMessageLevel tries to read the level from the message itself. Parsed
+// messages are checked first, then JSON logs if extract_log_level is on and
+// then the level_regex.
+func (sl *Syslog) detectMessageLevel(msg processlogger.LogMessage) (syslogger.Priority, bool) {
+	detector := sl.detector(msg)
+	if msg.Parsed != nil {
+		if level, err := detector.FromMap(msg.Parsed.Fields); err == nil {
+			return toPriority(level), true
+		}
+	}
+	if msg.Config.Syslog.ExtractLogLevel {
+		if level, err := detector.FromJSON([]byte(msg.Message)); err == nil {
+			return toPriority(level), true
+		}
+	}
+	pattern := msg.Config.Syslog.LevelRegex
+	if pattern == "" {
+		pattern = sl.defaults.Config.Syslog.LevelRegex
+	}
+	if re, ok := sl.levelRegexes[pattern]; ok {
+		if level, err := detector.FromRegex(re, msg.Message); err == nil {
+			return toPriority(level), true
+		}
+	}
+	// You get the default anyway...
+	return 0, false
+}
+
 // send will send the supplied text to syslog server.
 func (sl *Syslog) send(facility, priority syslogger.Priority, tag, hostname, text string) error {
 	_, err := sl.logwriter.WriteWithOverrides(facility, priority, hostname, tag, text)
@@ -202,27 +349,19 @@ func (sl *Syslog) Submit(msg processlogger.LogMessage) {
 	}
 
 	// How critical is the log
-	var level syslogger.Priority
-	switch msg.Pipe {
-	case processlogger.STDOUT:
-		level = syslogger.LOG_INFO
-	case processlogger.STDERR:
-		level = syslogger.LOG_CRIT
-	}
+	level := sl.pipeSeverity(msg)
 
 	// Can we detect the criticality from the log?
-	if msg.Config.Syslog.ExtractLogLevel {
-		detectedlevel, err := extractJSONlevel([]byte(msg.Message))
-		if err != nil {
-			// got nothing to send this to currently.
-			// The process manager should really consume this
-			// You get the default anyway...
-		} else {
-			level = detectedlevel
-		}
+	if detectedlevel, ok := sl.detectMessageLevel(msg); ok {
+		level = detectedlevel
+	}
+	text := msg.Message
+	if sl.defaults.Config.Syslog.Format == formatRFC5424 {
+		text = sl.rfc5424Content(msg, hostname, tag)
 	}
+
 	// Send the log
-	sl.send(sl.loggingFacility, level, tag, hostname, msg.Message)
+	sl.send(sl.facility(msg), level, tag, hostname, text)
 }
 
 // Shutdown will try to close the connection to the syslog server. This is a best effort close.
@@ -236,6 +375,9 @@ func (sl *Syslog) Shutdown() chan error {
 			c <- nil
 			return
 		}
+		if sl.stopWatcher != nil {
+			sl.stopWatcher <- true
+		}
 		err := sl.logwriter.Close()
 		if err != nil {
 			c <- fmt.Errorf("failed to close syslog connection. Error: %s", err)
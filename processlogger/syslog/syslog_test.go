@@ -1,8 +1,14 @@
 package syslog
 
 import (
+	"net"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/morfien101/launch/configfile"
+	"github.com/morfien101/launch/processlogger"
 	syslogger "github.com/silverstagtech/srslog"
 )
 
@@ -23,3 +29,148 @@ func TestSyslogConnection(t *testing.T) {
 		t.Logf("Failed to write message")
 	}
 }
+
+func TestSeverityAndFacilityMapping(t *testing.T) {
+	sl := &Syslog{loggingFacility: syslogger.LOG_DAEMON}
+	defaults := configfile.DefaultLoggerDetails{
+		Config: configfile.LoggingConfig{
+			Syslog: configfile.Syslog{
+				ConnectionType: udpConnection,
+				Facility:       "local3",
+				StderrSeverity: "warning",
+				LevelRegex:     `^\[(WARN|ERROR)\]`,
+			},
+		},
+	}
+	conf := configfile.LoggingConfig{
+		ProcessName: "mapped",
+		Syslog: configfile.Syslog{
+			Facility:       "local5",
+			StdoutSeverity: "notice",
+		},
+	}
+	if err := sl.RegisterConfig(conf, defaults); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout := processlogger.LogMessage{Pipe: processlogger.STDOUT, Config: conf, Message: "hello"}
+	stderr := processlogger.LogMessage{Pipe: processlogger.STDERR, Config: configfile.LoggingConfig{}, Message: "hello"}
+	tagged := processlogger.LogMessage{Pipe: processlogger.STDERR, Config: configfile.LoggingConfig{}, Message: "[ERROR] broken"}
+
+	if got := sl.facility(stdout); got != syslogger.LOG_LOCAL5 {
+		t.Logf("Process facility was not used. Got: %v", got)
+		t.Fail()
+	}
+	if got := sl.facility(stderr); got != syslogger.LOG_LOCAL3 {
+		t.Logf("Default facility was not used. Got: %v", got)
+		t.Fail()
+	}
+	if got := sl.pipeSeverity(stdout); got != syslogger.LOG_NOTICE {
+		t.Logf("Process stdout severity was not used. Got: %v", got)
+		t.Fail()
+	}
+	if got := sl.pipeSeverity(stderr); got != syslogger.LOG_WARNING {
+		t.Logf("Default stderr severity was not used. Got: %v", got)
+		t.Fail()
+	}
+	if got, ok := sl.detectMessageLevel(tagged); !ok || got != syslogger.LOG_ERR {
+		t.Logf("Default level regex was not used. Got: %v", got)
+		t.Fail()
+	}
+	parsed := processlogger.LogMessage{
+		Pipe:    processlogger.STDOUT,
+		Config:  conf,
+		Message: `{"level":"warn"}`,
+		Parsed:  &processlogger.Parsed{Fields: map[string]interface{}{"level": "warn"}},
+	}
+	if got, ok := sl.detectMessageLevel(parsed); !ok || got != syslogger.LOG_WARNING {
+		t.Logf("Level of the parsed message was not used. Got: %v", got)
+		t.Fail()
+	}
+}
+
+func TestInvalidSeverityMapping(t *testing.T) {
+	tests := []configfile.Syslog{
+		{Facility: "potato"},
+		{StderrSeverity: "loud"},
+		{LevelRegex: `[`},
+		{LevelRegex: `^WARN`},
+	}
+	for _, test := range tests {
+		test.ConnectionType = udpConnection
+		sl := &Syslog{}
+		err := sl.RegisterConfig(configfile.LoggingConfig{}, configfile.DefaultLoggerDetails{
+			Config: configfile.LoggingConfig{Syslog: test},
+		})
+		if err == nil {
+			t.Logf("Configuration %+v should have been rejected", test)
+			t.Fail()
+		}
+	}
+}
+
+func TestUnixgramSocket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "log")
+	listener, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	conf := configfile.LoggingConfig{Engine: LoggerTag, ProcessName: "test_proc"}
+	defaults := configfile.DefaultLoggerDetails{
+		Config: configfile.LoggingConfig{
+			Syslog: configfile.Syslog{
+				Address:        socket,
+				ConnectionType: unixgramConnection,
+			},
+		},
+	}
+	sl := &Syslog{loggingFacility: syslogger.LOG_DAEMON}
+	if err := sl.RegisterConfig(conf, defaults); err != nil {
+		t.Fatal(err)
+	}
+	if err := sl.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { <-sl.Shutdown() }()
+
+	sl.Submit(processlogger.LogMessage{Source: "test_proc", Pipe: processlogger.STDOUT, Config: conf, Message: "hello world\n"})
+
+	listener.SetReadDeadline(time.Now().Add(time.Second * 2))
+	packet := make([]byte, 1024)
+	n, err := listener.Read(packet)
+	if err != nil {
+		t.Fatalf("Failed to read the syslog message. Error: %s", err)
+	}
+	message := string(packet[:n])
+	if !strings.HasPrefix(message, "<30>") || !strings.Contains(message, " test_proc[") || !strings.Contains(message, "]: hello world") {
+		t.Logf("Message is not in the local syslog format. Got: %q", message)
+		t.Fail()
+	}
+	// The tag should come straight after the timestamp.
+	if len(message) < 4+len(time.Stamp) || !strings.HasPrefix(message[4+len(time.Stamp):], " test_proc[") {
+		t.Logf("The hostname should be left for the local daemon to add. Got: %q", message)
+		t.Fail()
+	}
+}
+
+func TestLocalSocketDefaults(t *testing.T) {
+	sl := &Syslog{}
+	defaults := configfile.DefaultLoggerDetails{
+		Config: configfile.LoggingConfig{Syslog: configfile.Syslog{ConnectionType: unixConnection}},
+	}
+	if err := sl.RegisterConfig(configfile.LoggingConfig{}, defaults); err != nil {
+		t.Fatal(err)
+	}
+	if sl.defaults.Config.Syslog.Address != defaultSocket {
+		t.Logf("Address should default to %s. Got: %s", defaultSocket, sl.defaults.Config.Syslog.Address)
+		t.Fail()
+	}
+
+	defaults.Config.Syslog = configfile.Syslog{ConnectionType: unixgramConnection, Framing: framingOctetCounting}
+	if err := (&Syslog{}).RegisterConfig(configfile.LoggingConfig{}, defaults); err == nil {
+		t.Logf("Octet counting over unixgram should be rejected")
+		t.Fail()
+	}
+}
@@ -0,0 +1,230 @@
+package syslog
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/morfien101/launch/configfile"
+	"github.com/morfien101/launch/processlogger"
+	"github.com/morfien101/launch/signalreplicator"
+)
+
+const (
+	// certificateCheckInterval is how often the certificate files are checked
+	// for changes when reloading is turned on.
+	certificateCheckInterval = time.Second * 30
+)
+
+var (
+	validTLSVersions = map[string]uint16{
+		"":    0,
+		"1.0": tls.VersionTLS10,
+		"1.1": tls.VersionTLS11,
+		"1.2": tls.VersionTLS12,
+		"1.3": tls.VersionTLS13,
+	}
+)
+
+// certificateStore holds the certificates used to connect to the syslog server.
+// The certificates can be reloaded while the logger is running. New connections
+// will use the reloaded certificates.
+type certificateStore struct {
+	sync.RWMutex
+	config     configfile.Syslog
+	minVersion uint16
+	roots      *x509.CertPool
+	clientCert *tls.Certificate
+	modTimes   map[string]time.Time
+}
+
+func newCertificateStore(config configfile.Syslog) (*certificateStore, error) {
+	minVersion, ok := validTLSVersions[config.MinTLSVersion]
+	if !ok {
+		return nil, fmt.Errorf("%s is not a valid minimum TLS version", config.MinTLSVersion)
+	}
+	if (config.ClientCertificatePath == "") != (config.ClientKeyPath == "") {
+		return nil, fmt.Errorf("client_cert_path and client_key_path must be set together")
+	}
+
+	cs := &certificateStore{
+		config:     config,
+		minVersion: minVersion,
+	}
+	if err := cs.load(); err != nil {
+		return nil, err
+	}
+	return cs, nil
+}
+
+// load reads all the certificates from disk. The current certificates are only
+// replaced if all of them could be read.
+func (cs *certificateStore) load() error {
+	modTimes := map[string]time.Time{}
+	roots, err := cs.readCertificates(modTimes)
+	if err != nil {
+		return err
+	}
+	clientCert, err := cs.readClientCertificate(modTimes)
+	if err != nil {
+		return err
+	}
+
+	cs.Lock()
+	defer cs.Unlock()
+	cs.roots = roots
+	cs.clientCert = clientCert
+	cs.modTimes = modTimes
+	return nil
+}
+
+func (cs *certificateStore) readCertificates(modTimes map[string]time.Time) (*x509.CertPool, error) {
+	if cs.config.CertificateBundlePath == "" && !cs.config.UseSystemRoots {
+		return nil, fmt.Errorf("No certificate bundle specified")
+	}
+
+	roots := x509.NewCertPool()
+	if cs.config.UseSystemRoots {
+		systemRoots, err := x509.SystemCertPool()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the system certificate pool. Error: %s", err)
+		}
+		roots = systemRoots
+	}
+
+	if cs.config.CertificateBundlePath == "" {
+		return roots, nil
+	}
+	certbundle, err := readFile(cs.config.CertificateBundlePath, modTimes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the certificate bundle. Error: %s", err)
+	}
+	ok := roots.AppendCertsFromPEM(certbundle)
+	if !ok {
+		return nil, fmt.Errorf("failed to parse the given certificate bundle")
+	}
+
+	return roots, nil
+}
+
+func (cs *certificateStore) readClientCertificate(modTimes map[string]time.Time) (*tls.Certificate, error) {
+	if cs.config.ClientCertificatePath == "" {
+		return nil, nil
+	}
+	certPEM, err := readFile(cs.config.ClientCertificatePath, modTimes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the client certificate. Error: %s", err)
+	}
+	keyPEM, err := readFile(cs.config.ClientKeyPath, modTimes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the client key. Error: %s", err)
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the client certificate and key. Error: %s", err)
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the client certificate. Error: %s", err)
+	}
+	if time.Now().After(leaf.NotAfter) {
+		return nil, fmt.Errorf("the client certificate expired at %s", leaf.NotAfter.Format(time.RFC3339))
+	}
+	cert.Leaf = leaf
+
+	return &cert, nil
+}
+
+// readFile reads a file and records its modification time so that changes can
+// be detected later.
+func readFile(path string, modTimes map[string]time.Time) ([]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	modTimes[path] = info.ModTime()
+	return ioutil.ReadFile(path)
+}
+
+// changed reports if any of the certificate files have been modified since they
+// were last loaded.
+func (cs *certificateStore) changed() bool {
+	cs.RLock()
+	defer cs.RUnlock()
+	for path, modTime := range cs.modTimes {
+		info, err := os.Stat(path)
+		if err != nil {
+			// The file might be in the middle of being replaced.
+			continue
+		}
+		if !info.ModTime().Equal(modTime) {
+			return true
+		}
+	}
+	return false
+}
+
+// tlsConfig creates a tls.Config from the current certificates.
+func (cs *certificateStore) tlsConfig() *tls.Config {
+	cs.RLock()
+	defer cs.RUnlock()
+	config := &tls.Config{
+		RootCAs:    cs.roots,
+		ServerName: cs.config.ServerName,
+		MinVersion: cs.minVersion,
+	}
+	if cs.clientCert != nil {
+		config.Certificates = []tls.Certificate{*cs.clientCert}
+	}
+	return config
+}
+
+// dialTLS is used as a custom dialer for the syslog writer. The tls.Config is
+// created on each dial so that reconnections pick up reloaded certificates.
+func (sl *Syslog) dialTLS(_, address string) (net.Conn, error) {
+	return tls.Dial("tcp", address, sl.certificates.tlsConfig())
+}
+
+// watchCertificates will reload the certificates when a SIGHUP is received or
+// when the certificate files change. The connection is closed after a reload
+// so that the next message is sent on a connection using the new certificates.
+// watchCertificates is run as a go routine.
+func (sl *Syslog) watchCertificates() {
+	signals := make(chan os.Signal, 1)
+	signalreplicator.Register(signals)
+	defer signalreplicator.Remove(signals)
+	interval := sl.certificateCheckInterval
+	if interval <= 0 {
+		interval = certificateCheckInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		reload := false
+		select {
+		case <-sl.stopWatcher:
+			return
+		case sig := <-signals:
+			reload = sig == syscall.SIGHUP
+		case <-ticker.C:
+			reload = sl.certificates.changed()
+		}
+		if !reload {
+			continue
+		}
+		if err := sl.certificates.load(); err != nil {
+			// Loggers should not terminate service. Keep using the old certificates.
+			processlogger.ReportError("failed to reload syslog certificates, keeping the current certificates. Error: %s\n", err)
+			continue
+		}
+		sl.logwriter.Close()
+	}
+}
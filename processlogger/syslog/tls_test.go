@@ -0,0 +1,280 @@
+package syslog
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/morfien101/launch/configfile"
+	"github.com/morfien101/launch/processlogger"
+	syslogger "github.com/silverstagtech/srslog"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "launch test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{
+		cert: cert,
+		key:  key,
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+// issue creates a certificate signed by the CA and returns the certificate and key as PEM.
+func (ca *testCA) issue(t *testing.T, name string, serial int64, usage x509.ExtKeyUsage) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeTestFile(t *testing.T, dir, name string, content []byte) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "launch_tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t)
+	serverCertPEM, serverKeyPEM := ca.issue(t, "syslog.test", 2, x509.ExtKeyUsageServerAuth)
+	clientCertPEM, clientKeyPEM := ca.issue(t, "launch-client", 3, x509.ExtKeyUsageClientAuth)
+
+	serverCert, err := tls.X509KeyPair(serverCertPEM, serverKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	peer := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		tlsConn := conn.(*tls.Conn)
+		// The handshake is completed by the first read.
+		if _, err := bufio.NewReader(tlsConn).ReadString('\n'); err != nil {
+			return
+		}
+		certs := tlsConn.ConnectionState().PeerCertificates
+		if len(certs) > 0 {
+			peer <- certs[0].Subject.CommonName
+		}
+	}()
+
+	defaults := configfile.DefaultLoggerDetails{
+		Config: configfile.LoggingConfig{
+			Syslog: configfile.Syslog{
+				Address:               listener.Addr().String(),
+				ConnectionType:        tlsConnection,
+				CertificateBundlePath: writeTestFile(t, dir, "ca.pem", ca.pem),
+				ClientCertificatePath: writeTestFile(t, dir, "client.pem", clientCertPEM),
+				ClientKeyPath:         writeTestFile(t, dir, "client.key", clientKeyPEM),
+				ServerName:            "syslog.test",
+				MinTLSVersion:         "1.2",
+			},
+		},
+	}
+	conf := configfile.LoggingConfig{Engine: LoggerTag, ProcessName: "tls_test"}
+	sl := &Syslog{loggingFacility: syslogger.LOG_DAEMON}
+	if err := sl.RegisterConfig(conf, defaults); err != nil {
+		t.Fatal(err)
+	}
+	if err := sl.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { <-sl.Shutdown() }()
+
+	sl.Submit(processlogger.LogMessage{
+		Source:  "tls_test",
+		Pipe:    processlogger.STDOUT,
+		Config:  conf,
+		Message: "hello over mutual tls\n",
+	})
+
+	select {
+	case name := <-peer:
+		if name != "launch-client" {
+			t.Logf("Server saw the wrong client certificate. Want: launch-client, Got: %s", name)
+			t.Fail()
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatal("Timed out waiting for the client certificate")
+	}
+}
+
+func TestCertificateValidation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "launch_tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t)
+	bundle := writeTestFile(t, dir, "ca.pem", ca.pem)
+	clientCertPEM, _ := ca.issue(t, "launch-client", 2, x509.ExtKeyUsageClientAuth)
+	_, otherKeyPEM := ca.issue(t, "other", 3, x509.ExtKeyUsageClientAuth)
+
+	tests := []struct {
+		name   string
+		config configfile.Syslog
+	}{
+		{
+			name:   "no roots",
+			config: configfile.Syslog{},
+		},
+		{
+			name: "bad bundle",
+			config: configfile.Syslog{
+				CertificateBundlePath: writeTestFile(t, dir, "bad.pem", []byte("not a certificate")),
+			},
+		},
+		{
+			name: "missing key",
+			config: configfile.Syslog{
+				CertificateBundlePath: bundle,
+				ClientCertificatePath: writeTestFile(t, dir, "client.pem", clientCertPEM),
+			},
+		},
+		{
+			name: "mismatched key",
+			config: configfile.Syslog{
+				CertificateBundlePath: bundle,
+				ClientCertificatePath: writeTestFile(t, dir, "client.pem", clientCertPEM),
+				ClientKeyPath:         writeTestFile(t, dir, "other.key", otherKeyPEM),
+			},
+		},
+		{
+			name: "bad tls version",
+			config: configfile.Syslog{
+				CertificateBundlePath: bundle,
+				MinTLSVersion:         "2.0",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		if _, err := newCertificateStore(test.config); err == nil {
+			t.Logf("%s did not return an error", test.name)
+			t.Fail()
+		} else {
+			t.Logf("%s returned: %s", test.name, err)
+		}
+	}
+}
+
+func TestCertificateReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "launch_tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t)
+	firstCert, firstKey := ca.issue(t, "first", 2, x509.ExtKeyUsageClientAuth)
+	config := configfile.Syslog{
+		CertificateBundlePath: writeTestFile(t, dir, "ca.pem", ca.pem),
+		ClientCertificatePath: writeTestFile(t, dir, "client.pem", firstCert),
+		ClientKeyPath:         writeTestFile(t, dir, "client.key", firstKey),
+	}
+	cs, err := newCertificateStore(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cs.changed() {
+		t.Fatal("Certificates reported as changed straight after loading")
+	}
+
+	secondCert, secondKey := ca.issue(t, "second", 3, x509.ExtKeyUsageClientAuth)
+	writeTestFile(t, dir, "client.pem", secondCert)
+	writeTestFile(t, dir, "client.key", secondKey)
+	// Make sure the modification time moves even on coarse file systems.
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(config.ClientCertificatePath, future, future)
+
+	if !cs.changed() {
+		t.Fatal("Certificate change was not detected")
+	}
+	if err := cs.load(); err != nil {
+		t.Fatal(err)
+	}
+	name := cs.tlsConfig().Certificates[0].Leaf.Subject.CommonName
+	if name != "second" {
+		t.Logf("Reloaded certificate is not in use. Want: second, Got: %s", name)
+		t.Fail()
+	}
+}
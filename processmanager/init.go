@@ -0,0 +1,143 @@
+package processmanager
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/morfien101/launch/configfile"
+	"github.com/morfien101/launch/signalreplicator"
+)
+
+const (
+	defaultRetryBackoff = time.Second
+	// maxRetryBackoff stops the wait between retries doubling forever.
+	maxRetryBackoff = time.Minute
+)
+
+// initGroups splits the init processes into the groups that are run one after
+// the other. Processes without a parallel group are in a group of their own.
+func initGroups(procs []*configfile.Process) [][]*configfile.Process {
+	groups := [][]*configfile.Process{}
+	for _, proc := range procs {
+		last := len(groups) - 1
+		if proc.ParallelGroup != "" && last >= 0 && groups[last][0].ParallelGroup == proc.ParallelGroup {
+			groups[last] = append(groups[last], proc)
+			continue
+		}
+		groups = append(groups, []*configfile.Process{proc})
+	}
+	return groups
+}
+
+// runInitGroup runs the processes in the group at the same time and waits for
+// all of them to finish. The first error in the order of the configuration is
+// returned.
+func (pm *ProcessManger) runInitGroup(group []*configfile.Process) error {
+	if len(group) == 1 {
+		return pm.runInitProc(group[0])
+	}
+	pm.pmlogger.Printf("Starting parallel group %s with %d processes\n", group[0].ParallelGroup, len(group))
+	errs := make([]error, len(group))
+	var wg sync.WaitGroup
+	for i, procConfig := range group {
+		wg.Add(1)
+		go func(i int, procConfig *configfile.Process) {
+			defer wg.Done()
+			errs[i] = pm.runInitProc(procConfig)
+		}(i, procConfig)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runInitAttempt runs an init process once. A process that is still running
+// when its timeout is reached is sent SIGTERM, and killed if it does not stop
+// within its termination timeout. It has failed even if it then exits
+// successfully. stopped is true if the process was stopped by a signal sent
+// to Launch.
+func (pm *ProcessManger) runInitAttempt(procConfig *configfile.Process, attempt int) (endstate *processEnd, stopped bool, err error) {
+	// Create a process object
+	proc := &Process{
+		config:      procConfig,
+		pmlogger:    pm.pmlogger,
+		sigChan:     make(chan os.Signal, 1),
+		processType: initProcess,
+		generation:  attempt,
+	}
+	pm.pmlogger.Debugf("Attempting to run %s.\n", proc.config.CMD)
+	if err := pm.setupProcess(proc); err != nil {
+		return nil, false, err
+	}
+
+	var timedOut int32
+	var timer *time.Timer
+	if procConfig.TimeoutSeconds > 0 {
+		timer = time.AfterFunc(time.Duration(procConfig.TimeoutSeconds)*time.Second, func() {
+			atomic.StoreInt32(&timedOut, 1)
+			select {
+			case proc.sigChan <- syscall.SIGTERM:
+			default:
+			}
+		})
+	}
+
+	// Run the process
+	endstate = proc.runProcess(initProcess)
+	if timer != nil {
+		timer.Stop()
+	}
+	signalreplicator.Remove(proc.sigChan)
+	pm.pmlogger.Debugf("Finished running %s.\n", proc.config.CMD)
+	// The SIGTERM sent by the timeout marks the process as exiting so the
+	// timeout is checked first.
+	if atomic.LoadInt32(&timedOut) == 1 {
+		endstate.Error = fmt.Errorf("timed out after %d seconds", procConfig.TimeoutSeconds)
+		return endstate, false, nil
+	}
+	proc.RLock()
+	signalled := proc.exiting
+	proc.RUnlock()
+	return endstate, signalled, nil
+}
+
+// waitForRetry waits for the backoff before a retry. false is returned if
+// Launch is told to stop while it waits.
+func (pm *ProcessManger) waitForRetry(delay time.Duration) bool {
+	signals := make(chan os.Signal, 1)
+	signalreplicator.Register(signals)
+	defer signalreplicator.Remove(signals)
+	wait := pm.after(delay)
+	for {
+		select {
+		case <-wait:
+			return true
+		case signal := <-signals:
+			switch signal {
+			case syscall.SIGTERM, syscall.SIGINT, syscall.SIGKILL:
+				return false
+			}
+		}
+	}
+}
+
+// retryBackoff is the wait before a retry. It starts at retry_backoff_seconds
+// and doubles for each attempt until it would be more than maxRetryBackoff.
+func retryBackoff(procConfig *configfile.Process, attempt int) time.Duration {
+	backoff := defaultRetryBackoff
+	if procConfig.RetryBackoffSeconds > 0 {
+		backoff = time.Duration(procConfig.RetryBackoffSeconds) * time.Second
+	}
+	for i := 0; i < attempt && backoff*2 <= maxRetryBackoff; i++ {
+		backoff = backoff * 2
+	}
+	return backoff
+}
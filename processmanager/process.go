@@ -6,12 +6,16 @@ import (
 	"os"
 	"os/exec"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/morfien101/launch/internallogger"
 
 	"github.com/morfien101/launch/configfile"
+	"github.com/morfien101/launch/filetail"
+	"github.com/morfien101/launch/processlogger/lineparser"
+	"github.com/morfien101/launch/processlogger/logfilter"
 )
 
 // Process is used to hold config and state of a process
@@ -26,6 +30,34 @@ type Process struct {
 	sigChan        chan os.Signal
 	proc           *exec.Cmd
 	closePipesChan chan bool
+	processType    string
+	pid            int
+	// generation is how many times this process has been restarted.
+	generation int
+	// sequence is the number of the last message captured from the process.
+	sequence uint64
+	// filter is applied to each line before it is logged. nil keeps every line.
+	filter *logfilter.Filter
+	// parser decodes each line that is logged. nil logs lines as plain text.
+	parser *lineparser.Parser
+	// tailer follows the log files of the process. nil if it has none.
+	tailer *filetail.Tailer
+	// tty copies the output of processes that run under a pty. nil if the
+	// process does not use one.
+	tty *ttyOutput
+}
+
+// getPID returns the pid of the running process. 0 is returned if the process
+// has not been started.
+func (p *Process) getPID() int {
+	p.RLock()
+	defer p.RUnlock()
+	return p.pid
+}
+
+// nextSequence returns the sequence number for the next captured message.
+func (p *Process) nextSequence() uint64 {
+	return atomic.AddUint64(&p.sequence, 1)
 }
 
 func (p *Process) running() bool {
@@ -56,17 +88,26 @@ func (p *Process) runProcess(processType string) *processEnd {
 
 	p.processStartDelay()
 
-	if err := p.proc.Start(); err != nil {
+	err := p.proc.Start()
+	closeStdin(p.proc)
+	if err != nil {
+		p.tty.wait()
 		p.exitcode = 1
 		finalState.Error = err
 		finalState.ExitCode = 1
 		return finalState
 	}
+	p.tty.started()
+	p.Lock()
+	p.pid = p.proc.Process.Pid
+	p.Unlock()
 
 	// Wait for the process to finish
 	done := make(chan error, 1)
 	go func() {
 		done <- p.proc.Wait()
+		// Read the last of the output from the terminal before the pipes close.
+		p.tty.wait()
 		// Close the pipes that redirect std out and err
 		p.closePipesChan <- true
 	}()
@@ -133,7 +174,9 @@ func (p *Process) runProcess(processType string) *processEnd {
 		finalState.Error = timeoutError
 	}
 
+	p.Lock()
 	p.exited = true
+	p.Unlock()
 
 	finalState.ExitCode = readExitError(finalState.Error)
 	return finalState
@@ -171,6 +214,10 @@ func RunSecretProcess(secretConfig configfile.SecretProcess, logger internallogg
 	)
 	defer cancel()
 	cmd := exec.CommandContext(ctx, secretConfig.CMD, secretConfig.Args...)
+	if err := setStdin(cmd, secretConfig.Stdin); err != nil {
+		return "", "", err
+	}
+	defer closeStdin(cmd)
 
 	stdout, err := cmd.Output()
 	if err != nil {
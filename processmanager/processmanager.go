@@ -9,11 +9,15 @@ import (
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/morfien101/launch/bytepipe"
 	"github.com/morfien101/launch/configfile"
+	"github.com/morfien101/launch/filetail"
 	"github.com/morfien101/launch/internallogger"
 	"github.com/morfien101/launch/processlogger"
+	"github.com/morfien101/launch/processlogger/lineparser"
+	"github.com/morfien101/launch/processlogger/logfilter"
 	"github.com/morfien101/launch/signalreplicator"
 )
 
@@ -33,6 +37,14 @@ type ProcessManger struct {
 	wg            sync.WaitGroup
 	tumble        chan bool
 	shuttingDown  bool
+	// endLock protects EndList as processes can finish at the same time.
+	endLock sync.Mutex
+	// after is used to wait between retries. It is replaced in tests.
+	after func(time.Duration) <-chan time.Time
+	// logFilesState is shared by the processes that tail log files. It is
+	// loaded when the first one is set up.
+	logFilesState     *filetail.State
+	logFilesStateLock sync.Mutex
 }
 
 type processEnd struct {
@@ -53,6 +65,7 @@ func New(
 		logger:   logManager,
 		pmlogger: pmlogger,
 		tumble:   make(chan bool, 1),
+		after:    time.After,
 	}
 
 	// Once we get a single process fail we shutdown everything.
@@ -86,15 +99,15 @@ func (pm *ProcessManger) terminator() {
 }
 
 // RunInitProcesses will run all of the processes that are under the
-// init processes configuration. All init processes will be run sequentially
-// in the order supplied and MUST return success before the next is started.
-// If a process does not return successfully an error is returned and further
-// processing will stop.
+// init processes configuration. Init processes will be run in the order
+// supplied and MUST return success before the next is started. Processes that
+// are next to each other in the same parallel group are run at the same time.
+// If a process does not return successfully, after any retries, an error is
+// returned and further processing will stop unless the process is allowed to fail.
 func (pm *ProcessManger) RunInitProcesses() (string, error) {
 	pm.pmlogger.Println("Starting Init Processes")
-	// Start the main processes
-	for _, procConfig := range pm.config.InitProcesses {
-		if err := pm.runInitProc(procConfig); err != nil {
+	for _, group := range initGroups(pm.config.InitProcesses) {
+		if err := pm.runInitGroup(group); err != nil {
 			return pm.exitStatusFormatter(), err
 		}
 	}
@@ -102,26 +115,36 @@ func (pm *ProcessManger) RunInitProcesses() (string, error) {
 }
 
 func (pm *ProcessManger) runInitProc(procConfig *configfile.Process) error {
-	// Create a process object
-	proc := &Process{
-		config:   procConfig,
-		pmlogger: pm.pmlogger,
-		sigChan:  make(chan os.Signal, 1),
-	}
-	pm.pmlogger.Debugf("Attempting to run %s.\n", proc.config.CMD)
-	// setup logging hooks
-	// If this fails we can't carry on.
-	err := pm.setupProcess(proc)
-	if err != nil {
-		return err
+	var endstate *processEnd
+	// stopped is set when Launch is told to stop. The process is not retried
+	// and is not allowed to fail as the next process should not be started.
+	stopped := false
+	for attempt := 0; ; attempt++ {
+		var err error
+		// setup logging hooks
+		// If this fails we can't carry on.
+		endstate, stopped, err = pm.runInitAttempt(procConfig, attempt)
+		if err != nil {
+			return err
+		}
+		if endstate.Error == nil || stopped || attempt >= procConfig.Retries {
+			break
+		}
+		delay := retryBackoff(procConfig, attempt)
+		pm.pmlogger.Printf("Process %s failed. Retrying in %s. Error reported: %s\n", procConfig.Name, delay, endstate.Error)
+		if !pm.waitForRetry(delay) {
+			pm.pmlogger.Printf("Launch is stopping. Process %s will not be retried.\n", procConfig.Name)
+			stopped = true
+			break
+		}
 	}
 
-	// Run the process
-	endstate := proc.runProcess(initProcess)
-	signalreplicator.Remove(proc.sigChan)
-	pm.pmlogger.Debugf("Finished running %s.\n", proc.config.CMD)
-	pm.EndList = append(pm.EndList, endstate)
+	pm.addEndState(endstate)
 	if endstate.Error != nil {
+		if procConfig.AllowFailure && !stopped {
+			pm.pmlogger.Printf("Process %s failed and is allowed to fail. Error reported: %s\n", procConfig.Name, endstate.Error)
+			return nil
+		}
 		pm.pmlogger.Debugln("The last init command failed. Stack will now tumble.")
 		pm.tumble <- true
 
@@ -145,10 +168,11 @@ func (pm *ProcessManger) RunMainProcesses() (chan string, error) {
 		pm.wg.Add(1)
 		pm.pmlogger.Debugf("Adding %s to the list of main processes.\n", procConfig.CMD)
 		proc := &Process{
-			config:   procConfig,
-			pmlogger: pm.pmlogger,
-			sigChan:  make(chan os.Signal, 1),
-			shutdown: make(chan bool, 1),
+			config:      procConfig,
+			pmlogger:    pm.pmlogger,
+			sigChan:     make(chan os.Signal, 1),
+			shutdown:    make(chan bool, 1),
+			processType: mainProcess,
 		}
 		pm.mainProcesses = append(pm.mainProcesses, proc)
 		// setup logging hooks
@@ -162,7 +186,7 @@ func (pm *ProcessManger) RunMainProcesses() (chan string, error) {
 			pm.pmlogger.Debugf("Starting %s.\n", proc.config.CMD)
 			endstate := proc.runProcess(mainProcess)
 			signalreplicator.Remove(proc.sigChan)
-			pm.EndList = append(pm.EndList, endstate)
+			pm.addEndState(endstate)
 			pm.pmlogger.Debugf("%s has terminated.\n", proc.config.CMD)
 			pm.tumble <- true
 			pm.wg.Done()
@@ -186,8 +210,17 @@ func (pm *ProcessManger) waitMain(output chan string) {
 	close(output)
 }
 
+// addEndState records how a process finished.
+func (pm *ProcessManger) addEndState(endstate *processEnd) {
+	pm.endLock.Lock()
+	defer pm.endLock.Unlock()
+	pm.EndList = append(pm.EndList, endstate)
+}
+
 func (pm *ProcessManger) exitStatusFormatter() string {
+	pm.endLock.Lock()
 	b, err := json.Marshal(pm.EndList)
+	pm.endLock.Unlock()
 	if err != nil {
 		pm.pmlogger.Debugf("Error generating end state. Error: %s\n", err)
 	}
@@ -197,16 +230,55 @@ func (pm *ProcessManger) exitStatusFormatter() string {
 // Setup Process will link create the process object and also link the stdout and stderr.
 // An error is returned if anything fails.
 func (pm *ProcessManger) setupProcess(proc *Process) error {
+	filter, err := logfilter.New(proc.config.LoggerConfig.Filters)
+	if err != nil {
+		return fmt.Errorf("failed to create the log filters for %s. Error: %s", proc.config.Name, err)
+	}
+	proc.filter = filter
+	parser, err := lineparser.New(proc.config.LoggerConfig)
+	if err != nil {
+		return fmt.Errorf("failed to create the line parser for %s. Error: %s", proc.config.Name, err)
+	}
+	proc.parser = parser
+	if len(proc.config.LogFiles) > 0 {
+		state, err := pm.loadLogFilesState()
+		if err != nil {
+			return err
+		}
+		proc.tailer = filetail.New(proc.config.LogFiles, state, pm.pmlogger)
+	}
+
 	execProc, stdout, stderr, err := createRunableProcess(proc.config, proc.sigChan)
 	if err != nil {
 		return err
 	}
 	proc.proc = execProc
-	proc.closePipesChan = pm.redirectOutput(stdout, stderr, proc.config.LoggerConfig)
+	if proc.config.TTY {
+		tty, err := attachTTY(execProc, stdout)
+		if err != nil {
+			return fmt.Errorf("failed to create a tty for %s. Error: %s", proc.config.Name, err)
+		}
+		proc.tty = tty
+	}
+	proc.closePipesChan = pm.redirectOutput(stdout, stderr, proc)
 
 	return nil
 }
 
+// loadLogFilesState reads the log file positions the first time they are needed.
+func (pm *ProcessManger) loadLogFilesState() (*filetail.State, error) {
+	pm.logFilesStateLock.Lock()
+	defer pm.logFilesStateLock.Unlock()
+	if pm.logFilesState == nil {
+		state, err := filetail.LoadState(pm.config.LogFilesState)
+		if err != nil {
+			return nil, err
+		}
+		pm.logFilesState = state
+	}
+	return pm.logFilesState, nil
+}
+
 // createRunableProcess will create a process that can be run later. It will also make sure that the
 // output pipes have been linked.
 // We can use this in processes managed by the process manager or secret processes which are just
@@ -215,8 +287,11 @@ func createRunableProcess(
 	config *configfile.Process,
 	signalChan chan os.Signal,
 ) (*exec.Cmd, *bytepipe.BytePipe, *bytepipe.BytePipe, error) {
-	signalreplicator.Register(signalChan)
 	execProc := exec.Command(config.CMD, config.Args...)
+	if err := setStdin(execProc, config.Stdin); err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to set stdin for %s. Error: %s", config.Name, err)
+	}
+	signalreplicator.Register(signalChan)
 
 	// If we have a working dir. Set it here.
 	if config.WorkingDirectory != "" {
@@ -232,45 +307,74 @@ func createRunableProcess(
 	return execProc, stdout, stderr, nil
 }
 
-// redirectOutput will take the pipes of the process and redirect it to the logger for the process
-func (pm *ProcessManger) redirectOutput(stdout, stderr *bytepipe.BytePipe, config configfile.LoggingConfig) chan bool {
+// redirectOutput will take the pipes of the process and redirect it to the logger for the process.
+// Log files written by the process are followed until the pipes are closed.
+// Messages are stamped with the time they are captured and the details of the process.
+// Lines removed by the filters of the process are not given a sequence number.
+// ANSI escape codes are removed before the filters if strip_ansi is set.
+// Lines that are kept are parsed once here rather than by each logger.
+func (pm *ProcessManger) redirectOutput(stdout, stderr *bytepipe.BytePipe, proc *Process) chan bool {
 	closePipeTrigger := make(chan bool, 1)
+	if proc.tailer != nil {
+		pm.wg.Add(1)
+	}
 	go func() {
 		<-closePipeTrigger
 		stdout.Close()
 		stderr.Close()
+		if proc.tailer != nil {
+			proc.tailer.Stop()
+			pm.wg.Done()
+		}
 	}()
 
-	newLog := func(from processlogger.Pipe, msg string) processlogger.LogMessage {
+	config := proc.config.LoggerConfig
+	clean := func(s string) string {
+		if proc.config.StripANSI {
+			return stripANSI(s)
+		}
+		return s
+	}
+	newLog := func(from processlogger.Pipe, line string) processlogger.LogMessage {
 		return processlogger.LogMessage{
-			Source:  config.ProcessName,
-			Pipe:    from,
-			Config:  config,
-			Message: msg,
+			Source:      config.ProcessName,
+			Pipe:        from,
+			Config:      config,
+			Message:     line + "\n",
+			Time:        time.Now(),
+			PID:         proc.getPID(),
+			ProcessType: proc.processType,
+			Generation:  proc.generation,
+			Sequence:    proc.nextSequence(),
+			Fields:      config.Fields,
+			Parsed:      proc.parser.Parse(line),
 		}
 	}
-	pm.wg.Add(1)
-	go func() {
-		for stdoutData := range stdout.Ready {
-			for _, s := range strings.Split(stdoutData, "\n") {
-				if len(s) != 0 {
-					pm.logger.Submit(newLog(processlogger.STDOUT, s+"\n"))
+	forward := func(pipe *bytepipe.BytePipe, from processlogger.Pipe) {
+		for data := range pipe.Ready {
+			for _, s := range strings.Split(data, "\n") {
+				if len(s) == 0 {
+					continue
 				}
-			}
-		}
-		pm.wg.Done()
-	}()
-	pm.wg.Add(1)
-	go func() {
-		for stderrData := range stderr.Ready {
-			for _, s := range strings.Split(stderrData, "\n") {
-				if len(s) != 0 {
-					pm.logger.Submit(newLog(processlogger.STDERR, stderrData))
+				if line, keep := proc.filter.Apply(clean(s)); keep {
+					pm.logger.Submit(newLog(from, line))
 				}
 			}
 		}
 		pm.wg.Done()
-	}()
+	}
+	pm.wg.Add(2)
+	go forward(stdout, processlogger.STDOUT)
+	go forward(stderr, processlogger.STDERR)
+	if proc.tailer != nil {
+		proc.tailer.Start(func(path, s string) {
+			if line, keep := proc.filter.Apply(clean(s)); keep {
+				msg := newLog(processlogger.FILE, line)
+				msg.File = path
+				pm.logger.Submit(msg)
+			}
+		})
+	}
 
 	return closePipeTrigger
 }
@@ -0,0 +1,506 @@
+package processmanager
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/morfien101/launch/bytepipe"
+	"github.com/morfien101/launch/configfile"
+	"github.com/morfien101/launch/filetail"
+	"github.com/morfien101/launch/internallogger"
+	"github.com/morfien101/launch/processlogger"
+	"github.com/morfien101/launch/processlogger/logfilter"
+	"github.com/morfien101/launch/signalreplicator"
+)
+
+// captureLogger keeps the messages that it is given so that tests can inspect them.
+type captureLogger struct {
+	sync.Mutex
+	messages []processlogger.LogMessage
+}
+
+func (cl *captureLogger) RegisterConfig(configfile.LoggingConfig, configfile.DefaultLoggerDetails) error {
+	return nil
+}
+func (cl *captureLogger) Start() error {
+	return nil
+}
+func (cl *captureLogger) Shutdown() chan error {
+	c := make(chan error)
+	close(c)
+	return c
+}
+func (cl *captureLogger) Submit(msg processlogger.LogMessage) {
+	cl.Lock()
+	defer cl.Unlock()
+	cl.messages = append(cl.messages, msg)
+}
+func (cl *captureLogger) captured() []processlogger.LogMessage {
+	cl.Lock()
+	defer cl.Unlock()
+	return append([]processlogger.LogMessage{}, cl.messages...)
+}
+
+// newCaptureManager creates a process manager that sends the process logs to a
+// captureLogger.
+func newCaptureManager(t *testing.T, engine string) (*ProcessManger, *captureLogger) {
+	capture := &captureLogger{}
+	processlogger.RegisterLogger(engine, func() processlogger.Logger {
+		return capture
+	})
+	lm := processlogger.New(10, configfile.DefaultLoggerDetails{})
+	err := lm.StartLoggers(configfile.Processes{}, configfile.LoggingConfig{Engine: engine})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(configfile.Processes{}, lm, internallogger.NewFakeLogger()), capture
+}
+
+func TestRedirectOutputMetadata(t *testing.T) {
+	pm, capture := newCaptureManager(t, "capture_metadata")
+	proc := &Process{
+		config: &configfile.Process{
+			Name: "meta",
+			LoggerConfig: configfile.LoggingConfig{
+				Engine:      "capture_metadata",
+				ProcessName: "meta",
+			},
+		},
+		processType: mainProcess,
+		pid:         4321,
+	}
+
+	stdout := bytepipe.New()
+	stderr := bytepipe.New()
+	closePipes := pm.redirectOutput(stdout, stderr, proc)
+
+	before := time.Now()
+	stdout.Write([]byte("line one\nline two\n"))
+	stderr.Write([]byte("oops one\noops two\n"))
+	closePipes <- true
+
+	deadline := time.Now().Add(time.Second)
+	for len(capture.captured()) < 4 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 5)
+	}
+	messages := capture.captured()
+	if len(messages) != 4 {
+		t.Fatalf("Expected 4 messages, Got: %d. %v", len(messages), messages)
+	}
+
+	sequences := map[uint64]bool{}
+	stderrLines := map[string]bool{}
+	for _, msg := range messages {
+		if msg.Time.Before(before) {
+			t.Logf("Message %q does not have a capture time", msg.Message)
+			t.Fail()
+		}
+		if msg.PID != 4321 || msg.ProcessType != mainProcess {
+			t.Logf("Message %q does not carry the process details. Got pid: %d, type: %s", msg.Message, msg.PID, msg.ProcessType)
+			t.Fail()
+		}
+		sequences[msg.Sequence] = true
+		if msg.Pipe == processlogger.STDERR {
+			stderrLines[msg.Message] = true
+		}
+	}
+	for i := uint64(1); i <= 4; i++ {
+		if !sequences[i] {
+			t.Logf("Sequence number %d is missing", i)
+			t.Fail()
+		}
+	}
+	if !stderrLines["oops one\n"] || !stderrLines["oops two\n"] {
+		t.Logf("STDERR output was not split into lines. Got: %v", stderrLines)
+		t.Fail()
+	}
+}
+
+func TestRedirectOutputFilters(t *testing.T) {
+	pm, capture := newCaptureManager(t, "capture_filters")
+	proc := &Process{
+		config: &configfile.Process{
+			Name: "filtered",
+			LoggerConfig: configfile.LoggingConfig{
+				Engine:      "capture_filters",
+				ProcessName: "filtered",
+			},
+		},
+		processType: mainProcess,
+	}
+	filter, err := logfilter.New(configfile.Filters{
+		Drop:   []string{"health"},
+		Redact: []configfile.RedactRule{{Pattern: "hunter2"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proc.filter = filter
+
+	stdout := bytepipe.New()
+	stderr := bytepipe.New()
+	closePipes := pm.redirectOutput(stdout, stderr, proc)
+	stdout.Write([]byte("GET /health\npassword hunter2\nGET /health\n"))
+	closePipes <- true
+
+	deadline := time.Now().Add(time.Second)
+	for len(capture.captured()) < 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 5)
+	}
+	// Give any lines that should have been dropped time to arrive.
+	time.Sleep(time.Millisecond * 50)
+	messages := capture.captured()
+	if len(messages) != 1 {
+		t.Fatalf("Expected 1 message, Got: %d. %v", len(messages), messages)
+	}
+	if messages[0].Message != "password [REDACTED]\n" || messages[0].Sequence != 1 {
+		t.Logf("Message is not as expected. Got: %q, sequence: %d", messages[0].Message, messages[0].Sequence)
+		t.Fail()
+	}
+}
+
+func TestRedirectOutputLogFiles(t *testing.T) {
+	pm, capture := newCaptureManager(t, "capture_log_files")
+	logFile := filepath.Join(t.TempDir(), "access.log")
+	proc := &Process{
+		config: &configfile.Process{
+			Name: "tailed",
+			LoggerConfig: configfile.LoggingConfig{
+				Engine:      "capture_log_files",
+				ProcessName: "tailed",
+			},
+			LogFiles: []string{logFile},
+		},
+		processType: mainProcess,
+	}
+	proc.tailer = filetail.New(proc.config.LogFiles, nil, pm.pmlogger)
+
+	stdout := bytepipe.New()
+	stderr := bytepipe.New()
+	closePipes := pm.redirectOutput(stdout, stderr, proc)
+	if err := os.WriteFile(logFile, []byte("GET / 200\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Wait for the line to be read before closing so that it is not left for
+	// the last read when the tailer stops.
+	deadline := time.Now().Add(time.Second * 2)
+	for len(capture.captured()) < 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 5)
+	}
+	closePipes <- true
+
+	messages := capture.captured()
+	if len(messages) != 1 {
+		t.Fatalf("Expected 1 message, Got: %d. %v", len(messages), messages)
+	}
+	msg := messages[0]
+	if msg.Message != "GET / 200\n" || msg.Pipe != processlogger.FILE || msg.File != logFile || msg.Source != "tailed" {
+		t.Logf("Message is not as expected. Got: %+v", msg)
+		t.Fail()
+	}
+}
+
+func TestTTYProcess(t *testing.T) {
+	pm, capture := newCaptureManager(t, "capture_tty")
+	proc := &Process{
+		config: &configfile.Process{
+			Name: "terminal",
+			CMD:  "/bin/sh",
+			Args: []string{"-c", `if [ -t 1 ]; then printf '\033[31mis a tty\033[0m\n'; fi; echo to stderr >&2`},
+			LoggerConfig: configfile.LoggingConfig{
+				Engine:      "capture_tty",
+				ProcessName: "terminal",
+			},
+			TTY:       true,
+			StripANSI: true,
+		},
+		pmlogger:    pm.pmlogger,
+		sigChan:     make(chan os.Signal, 1),
+		processType: mainProcess,
+	}
+	if err := pm.setupProcess(proc); err != nil {
+		t.Skipf("A pty could not be created. Error: %s", err)
+	}
+	endState := proc.runProcess(mainProcess)
+	if endState.Error != nil {
+		t.Fatal(endState.Error)
+	}
+
+	deadline := time.Now().Add(time.Second * 2)
+	for len(capture.captured()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 5)
+	}
+	messages := capture.captured()
+	if len(messages) != 2 {
+		t.Fatalf("Expected 2 messages, Got: %d. %v", len(messages), messages)
+	}
+	for i, want := range []string{"is a tty\n", "to stderr\n"} {
+		if messages[i].Message != want || messages[i].Pipe != processlogger.STDOUT {
+			t.Logf("Message %d is not as expected. Want: %q, Got: %q from %s", i, want, messages[i].Message, messages[i].Pipe.Name())
+			t.Fail()
+		}
+	}
+}
+
+func TestStripANSI(t *testing.T) {
+	tests := map[string]string{
+		"\x1b[1;32mok\x1b[0m":          "ok",
+		"\x1b]0;title\x07text":         "text",
+		"\x1b[2Kprogress \x1b[10Ddone": "progress done",
+		"plain":                        "plain",
+	}
+	for line, want := range tests {
+		if got := stripANSI(line); got != want {
+			t.Logf("%q is not as expected. Want: %q, Got: %q", line, want, got)
+			t.Fail()
+		}
+	}
+}
+
+func TestProcessStdin(t *testing.T) {
+	stdinFile := filepath.Join(t.TempDir(), "stdin")
+	if err := os.WriteFile(stdinFile, []byte("from a file\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]configfile.Stdin{
+		"from a value": {Value: "from a value\n"},
+		"from a file":  {File: stdinFile},
+	}
+	for want, stdin := range tests {
+		engine := "capture_stdin_" + strings.ReplaceAll(want, " ", "_")
+		pm, capture := newCaptureManager(t, engine)
+		proc := &Process{
+			config: &configfile.Process{
+				Name:         "reader",
+				CMD:          "/bin/cat",
+				LoggerConfig: configfile.LoggingConfig{Engine: engine, ProcessName: "reader"},
+				Stdin:        stdin,
+			},
+			pmlogger:    pm.pmlogger,
+			sigChan:     make(chan os.Signal, 1),
+			processType: initProcess,
+		}
+		if err := pm.setupProcess(proc); err != nil {
+			t.Fatal(err)
+		}
+		if endState := proc.runProcess(initProcess); endState.Error != nil {
+			t.Fatal(endState.Error)
+		}
+
+		deadline := time.Now().Add(time.Second)
+		for len(capture.captured()) < 1 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond * 5)
+		}
+		messages := capture.captured()
+		if len(messages) != 1 || messages[0].Message != want+"\n" {
+			t.Logf("Stdin was not given to the process. Want: %q, Got: %v", want, messages)
+			t.Fail()
+		}
+	}
+
+	proc := &Process{config: &configfile.Process{Name: "missing", CMD: "/bin/cat", Stdin: configfile.Stdin{File: "/does/not/exist"}}}
+	if _, _, _, err := createRunableProcess(proc.config, make(chan os.Signal, 1)); err == nil {
+		t.Logf("A missing stdin file should be an error")
+		t.Fail()
+	}
+}
+
+func TestSecretProcessStdin(t *testing.T) {
+	stdout, _, err := RunSecretProcess(configfile.SecretProcess{
+		Name:        "secret",
+		CMD:         "/bin/cat",
+		TermTimeout: 5,
+		Stdin:       configfile.Stdin{Value: `{"TOKEN":"abc"}`},
+	}, internallogger.NewFakeLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stdout != `{"TOKEN":"abc"}` {
+		t.Logf("Stdin was not given to the secret process. Got: %q", stdout)
+		t.Fail()
+	}
+}
+
+// shellInitProcess creates the config for an init process that runs a shell script.
+func shellInitProcess(engine, name, script string) *configfile.Process {
+	return &configfile.Process{
+		Name:         name,
+		CMD:          "/bin/sh",
+		Args:         []string{"-c", script},
+		TermTimeout:  1,
+		LoggerConfig: configfile.LoggingConfig{Engine: engine, ProcessName: name},
+	}
+}
+
+func TestInitRetries(t *testing.T) {
+	pm, _ := newCaptureManager(t, "capture_init_retries")
+	var waits []time.Duration
+	pm.after = func(d time.Duration) <-chan time.Time {
+		waits = append(waits, d)
+		return time.After(0)
+	}
+
+	// The script fails until it has been run three times.
+	counter := filepath.Join(t.TempDir(), "counter")
+	proc := shellInitProcess("capture_init_retries", "flaky", `echo run >> `+counter+`; [ $(wc -l < `+counter+`) -ge 3 ]`)
+	proc.Retries = 3
+	proc.RetryBackoffSeconds = 2
+	pm.config.InitProcesses = []*configfile.Process{proc}
+
+	if _, err := pm.RunInitProcesses(); err != nil {
+		t.Fatal(err)
+	}
+	want := []time.Duration{time.Second * 2, time.Second * 4}
+	if len(waits) != len(want) || waits[0] != want[0] || waits[1] != want[1] {
+		t.Logf("Retries did not back off as expected. Want: %v, Got: %v", want, waits)
+		t.Fail()
+	}
+	if len(pm.EndList) != 1 || pm.EndList[0].Error != nil {
+		t.Logf("Only the last attempt should be recorded. Got: %s", pm.exitStatusFormatter())
+		t.Fail()
+	}
+
+	proc.Retries = 1
+	if err := os.Remove(counter); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pm.RunInitProcesses(); err == nil {
+		t.Logf("A process that fails all of its retries should return an error")
+		t.Fail()
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	proc := &configfile.Process{}
+	tests := map[int]time.Duration{0: time.Second, 1: time.Second * 2, 5: time.Second * 32, 10: time.Second * 32}
+	for attempt, want := range tests {
+		if got := retryBackoff(proc, attempt); got != want {
+			t.Logf("Backoff for attempt %d should be %s. Got: %s", attempt, want, got)
+			t.Fail()
+		}
+	}
+	proc.RetryBackoffSeconds = 120
+	if got := retryBackoff(proc, 3); got != time.Second*120 {
+		t.Logf("A backoff that is already over the max should not grow. Got: %s", got)
+		t.Fail()
+	}
+}
+
+func TestInitTimeout(t *testing.T) {
+	pm, _ := newCaptureManager(t, "capture_init_timeout")
+	proc := shellInitProcess("capture_init_timeout", "slow", "exec sleep 5")
+	proc.TimeoutSeconds = 1
+	pm.config.InitProcesses = []*configfile.Process{proc}
+
+	start := time.Now()
+	_, err := pm.RunInitProcesses()
+	if err == nil || !strings.Contains(err.Error(), "timed out after 1 seconds") {
+		t.Logf("The process should have timed out. Got: %v", err)
+		t.Fail()
+	}
+	if took := time.Since(start); took > time.Second*4 {
+		t.Logf("The process was not stopped at its timeout. Took: %s", took)
+		t.Fail()
+	}
+}
+
+func TestInitTimeoutExitsSuccessfully(t *testing.T) {
+	pm, _ := newCaptureManager(t, "capture_init_timeout_trap")
+	pm.after = func(time.Duration) <-chan time.Time { return time.After(0) }
+	// The process stops cleanly when it is sent SIGTERM by the timeout.
+	counter := filepath.Join(t.TempDir(), "counter")
+	proc := shellInitProcess("capture_init_timeout_trap", "trapped", `echo run >> `+counter+`; trap 'exit 0' TERM; sleep 5 >/dev/null 2>&1 & wait`)
+	proc.TimeoutSeconds = 1
+	proc.Retries = 1
+	pm.config.InitProcesses = []*configfile.Process{proc}
+
+	_, err := pm.RunInitProcesses()
+	if err == nil || !strings.Contains(err.Error(), "timed out after 1 seconds") {
+		t.Logf("A process that exits successfully after its timeout should still have timed out. Got: %v", err)
+		t.Fail()
+	}
+	if content, _ := os.ReadFile(counter); strings.Count(string(content), "run") != 2 {
+		t.Logf("A process that timed out should be retried. Got %d runs", strings.Count(string(content), "run"))
+		t.Fail()
+	}
+}
+
+func TestInitRetryStopsOnSignal(t *testing.T) {
+	pm, _ := newCaptureManager(t, "capture_init_retry_signal")
+	// Launch is told to stop while it waits to retry the process.
+	pm.after = func(time.Duration) <-chan time.Time {
+		go signalreplicator.Send(syscall.SIGTERM)
+		return nil
+	}
+	counter := filepath.Join(t.TempDir(), "counter")
+	proc := shellInitProcess("capture_init_retry_signal", "failing", `echo run >> `+counter+`; exit 1`)
+	proc.Retries = 3
+	proc.AllowFailure = true
+	after := shellInitProcess("capture_init_retry_signal", "after", "exit 0")
+	pm.config.InitProcesses = []*configfile.Process{proc, after}
+
+	if _, err := pm.RunInitProcesses(); err == nil {
+		t.Logf("Init processes should stop when Launch is stopping, even if the process is allowed to fail")
+		t.Fail()
+	}
+	if content, _ := os.ReadFile(counter); strings.Count(string(content), "run") != 1 {
+		t.Logf("The process should not be retried after Launch is told to stop. Got %d runs", strings.Count(string(content), "run"))
+		t.Fail()
+	}
+	if len(pm.EndList) != 1 {
+		t.Logf("The next init process should not have started. Got: %s", pm.exitStatusFormatter())
+		t.Fail()
+	}
+}
+
+func TestInitAllowFailure(t *testing.T) {
+	pm, _ := newCaptureManager(t, "capture_init_allow_failure")
+	failing := shellInitProcess("capture_init_allow_failure", "optional", "exit 3")
+	failing.AllowFailure = true
+	after := shellInitProcess("capture_init_allow_failure", "after", "exit 0")
+	pm.config.InitProcesses = []*configfile.Process{failing, after}
+
+	if _, err := pm.RunInitProcesses(); err != nil {
+		t.Fatal(err)
+	}
+	if len(pm.EndList) != 2 || pm.EndList[0].ExitCode != 3 || pm.EndList[1].Name != "after" {
+		t.Logf("The process after the allowed failure should have run. Got: %s", pm.exitStatusFormatter())
+		t.Fail()
+	}
+}
+
+func TestInitParallelGroup(t *testing.T) {
+	pm, _ := newCaptureManager(t, "capture_init_parallel")
+	marker := filepath.Join(t.TempDir(), "marker")
+	first := shellInitProcess("capture_init_parallel", "first", "sleep 0.5; touch "+marker)
+	first.ParallelGroup = "setup"
+	second := shellInitProcess("capture_init_parallel", "second", "sleep 0.5")
+	second.ParallelGroup = "setup"
+	// The next group waits for all of the group before it.
+	after := shellInitProcess("capture_init_parallel", "after", "test -f "+marker)
+	pm.config.InitProcesses = []*configfile.Process{first, second, after}
+
+	start := time.Now()
+	if _, err := pm.RunInitProcesses(); err != nil {
+		t.Fatal(err)
+	}
+	if took := time.Since(start); took > time.Millisecond*900 {
+		t.Logf("The processes in the group did not run at the same time. Took: %s", took)
+		t.Fail()
+	}
+	if len(pm.EndList) != 3 || pm.EndList[2].Name != "after" {
+		t.Logf("All of the processes should have finished. Got: %s", pm.exitStatusFormatter())
+		t.Fail()
+	}
+
+	groups := initGroups([]*configfile.Process{{ParallelGroup: "a"}, {ParallelGroup: "a"}, {}, {}, {ParallelGroup: "b"}})
+	if len(groups) != 4 || len(groups[0]) != 2 {
+		t.Logf("Init processes are not grouped as expected. Got: %d groups", len(groups))
+		t.Fail()
+	}
+}
@@ -0,0 +1,36 @@
+package processmanager
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/morfien101/launch/configfile"
+)
+
+// setStdin connects stdin of the command to what is configured. Files are
+// passed to the process directly, values are written to it by exec.
+func setStdin(cmd *exec.Cmd, stdin configfile.Stdin) error {
+	switch {
+	case stdin.Inherit:
+		cmd.Stdin = os.Stdin
+	case stdin.File != "":
+		f, err := os.Open(stdin.File)
+		if err != nil {
+			return fmt.Errorf("failed to open stdin file %s. Error: %s", stdin.File, err)
+		}
+		cmd.Stdin = f
+	case stdin.Value != "":
+		cmd.Stdin = strings.NewReader(stdin.Value)
+	}
+	return nil
+}
+
+// closeStdin closes the stdin file opened for the command. The process has
+// its own copy once it has been started.
+func closeStdin(cmd *exec.Cmd) {
+	if f, ok := cmd.Stdin.(*os.File); ok && f != os.Stdin {
+		f.Close()
+	}
+}
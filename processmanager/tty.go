@@ -0,0 +1,87 @@
+package processmanager
+
+import (
+	"bufio"
+	"bytes"
+	"os/exec"
+	"regexp"
+	"time"
+
+	"github.com/morfien101/launch/bytepipe"
+	"github.com/morfien101/launch/pty"
+)
+
+const (
+	// ttyDrainTimeout is how long to wait for the last of the output after
+	// the process exits. Children that keep the terminal open would
+	// otherwise stop the pipes from closing.
+	ttyDrainTimeout = time.Second
+	ttyMaxLineSize  = 64 * 1024
+)
+
+// ansiPattern matches CSI sequences such as colours and cursor movement, OSC
+// sequences such as window titles and other two character escapes.
+var ansiPattern = regexp.MustCompile(`\x1b\[[0-?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(\x07|\x1b\\)|\x1b[@-Z\\-_]`)
+
+// stripANSI removes ANSI escape codes from a line.
+func stripANSI(line string) string {
+	return ansiPattern.ReplaceAllString(line, "")
+}
+
+// ttyOutput runs a process under a pty and copies the output line by line
+// into the stdout pipe. A terminal merges stdout and stderr.
+type ttyOutput struct {
+	pty    *pty.PTY
+	copied chan struct{}
+}
+
+// attachTTY creates a pty for the command and starts copying its output.
+func attachTTY(cmd *exec.Cmd, stdout *bytepipe.BytePipe) (*ttyOutput, error) {
+	p, err := pty.Open()
+	if err != nil {
+		return nil, err
+	}
+	p.Attach(cmd)
+	t := &ttyOutput{pty: p, copied: make(chan struct{})}
+	go t.copy(stdout)
+	return t, nil
+}
+
+// copy reads lines until the terminal is closed. Terminals end lines with
+// \r\n so the \r is removed.
+func (t *ttyOutput) copy(stdout *bytepipe.BytePipe) {
+	defer close(t.copied)
+	reader := bufio.NewReaderSize(t.pty.Master, ttyMaxLineSize)
+	for {
+		line, err := reader.ReadSlice('\n')
+		line = bytes.TrimRight(line, "\r\n")
+		if len(line) > 0 {
+			stdout.Write(append([]byte{}, line...))
+		}
+		if err != nil && err != bufio.ErrBufferFull {
+			return
+		}
+	}
+}
+
+// started closes Launch's copy of the terminal once the process is running.
+func (t *ttyOutput) started() {
+	if t != nil {
+		t.pty.Started()
+	}
+}
+
+// wait gives the copy time to read the last of the output and then closes
+// the terminal.
+func (t *ttyOutput) wait() {
+	if t == nil {
+		return
+	}
+	t.pty.Started()
+	select {
+	case <-t.copied:
+	case <-time.After(ttyDrainTimeout):
+	}
+	t.pty.Close()
+	<-t.copied
+}
@@ -0,0 +1,45 @@
+// Package pty creates pseudo terminals for processes that need to be attached
+// to a terminal. Some programs buffer their output or refuse to run when they
+// are not, which hides their logs until they exit.
+package pty
+
+import (
+	"os"
+	"sync"
+)
+
+const (
+	// defaultRows and defaultCols are used when Launch is not attached to a
+	// terminal that the size can be copied from.
+	defaultRows = 24
+	defaultCols = 80
+)
+
+// PTY is a pseudo terminal. The process is attached to the slave end and
+// Launch reads its output from the master end.
+type PTY struct {
+	Master *os.File
+	slave  *os.File
+
+	closeOnce sync.Once
+	// stopResize stops the copying of the window size.
+	stopResize chan struct{}
+}
+
+// Started closes the copy of the slave end that Launch holds once the process
+// has been started. Reads from the master end will then fail once the process
+// and any children have closed the terminal.
+func (p *PTY) Started() {
+	p.slave.Close()
+}
+
+// Close stops the copying of the window size and closes the terminal.
+func (p *PTY) Close() error {
+	var err error
+	p.closeOnce.Do(func() {
+		close(p.stopResize)
+		p.slave.Close()
+		err = p.Master.Close()
+	})
+	return err
+}
@@ -0,0 +1,114 @@
+package pty
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+// winsize is struct winsize from the kernel.
+type winsize struct {
+	rows   uint16
+	cols   uint16
+	xpixel uint16
+	ypixel uint16
+}
+
+// Open creates a pseudo terminal. The window size is copied from the stdin of
+// Launch if it is a terminal, and copied again each time Launch is resized.
+func Open() (*PTY, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open /dev/ptmx. Error: %s", err)
+	}
+	var unlock int32
+	if err := ioctl(master, syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); err != nil {
+		master.Close()
+		return nil, fmt.Errorf("failed to unlock the pty. Error: %s", err)
+	}
+	var number uint32
+	if err := ioctl(master, syscall.TIOCGPTN, uintptr(unsafe.Pointer(&number))); err != nil {
+		master.Close()
+		return nil, fmt.Errorf("failed to get the pty number. Error: %s", err)
+	}
+	slave, err := os.OpenFile("/dev/pts/"+strconv.FormatUint(uint64(number), 10), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, fmt.Errorf("failed to open the pty. Error: %s", err)
+	}
+
+	p := &PTY{Master: master, slave: slave, stopResize: make(chan struct{})}
+	if err := p.inheritSize(); err != nil {
+		p.setSize(winsize{rows: defaultRows, cols: defaultCols})
+	}
+	go p.watchResize()
+	return p, nil
+}
+
+// Attach makes the terminal the stdin, stdout, stderr and controlling
+// terminal of the command. The command is started in a new session.
+func (p *PTY) Attach(cmd *exec.Cmd) {
+	cmd.Stdin = p.slave
+	cmd.Stdout = p.slave
+	cmd.Stderr = p.slave
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setsid = true
+	cmd.SysProcAttr.Setctty = true
+	// Ctty is the file descriptor in the child, which is stdin.
+	cmd.SysProcAttr.Ctty = 0
+}
+
+// watchResize copies the window size of Launch's terminal each time it changes.
+func (p *PTY) watchResize() {
+	resized := make(chan os.Signal, 1)
+	signal.Notify(resized, syscall.SIGWINCH)
+	defer signal.Stop(resized)
+	for {
+		select {
+		case <-resized:
+			p.inheritSize()
+		case <-p.stopResize:
+			return
+		}
+	}
+}
+
+// inheritSize copies the window size of Launch's stdin. An error is returned
+// if stdin is not a terminal.
+func (p *PTY) inheritSize() error {
+	var size winsize
+	if err := ioctl(os.Stdin, syscall.TIOCGWINSZ, uintptr(unsafe.Pointer(&size))); err != nil {
+		return err
+	}
+	return p.setSize(size)
+}
+
+func (p *PTY) setSize(size winsize) error {
+	return ioctl(p.Master, syscall.TIOCSWINSZ, uintptr(unsafe.Pointer(&size)))
+}
+
+// ioctl is run through SyscallConn so that the file is left in non blocking
+// mode and Close can stop a pending Read.
+func ioctl(f *os.File, request, arg uintptr) error {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var errno syscall.Errno
+	err = conn.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, request, arg)
+	})
+	if err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package pty
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// Open is only supported on Linux.
+func Open() (*PTY, error) {
+	return nil, fmt.Errorf("tty is only supported on Linux")
+}
+
+// Attach is only supported on Linux.
+func (p *PTY) Attach(cmd *exec.Cmd) {}
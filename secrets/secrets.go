@@ -0,0 +1,87 @@
+// Package secrets remembers the values that were collected by the secret
+// processes so that they can be removed from anything that Launch logs.
+package secrets
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// Mask replaces secret values.
+	Mask = "[REDACTED]"
+	// minLength is the shortest value that is remembered. Shorter values such
+	// as true or 1 would mask too much of the logs.
+	minLength = 4
+)
+
+var (
+	lock     sync.RWMutex
+	values   = map[string]bool{}
+	replacer = strings.NewReplacer()
+)
+
+// Remember adds values that need to be removed from logs.
+func Remember(secretValues ...string) {
+	lock.Lock()
+	defer lock.Unlock()
+	for _, value := range secretValues {
+		for _, variant := range variants(value) {
+			if len(variant) >= minLength {
+				values[variant] = true
+			}
+		}
+	}
+
+	// Longer values are replaced first so that a secret that contains
+	// another secret is masked in full.
+	sorted := make([]string, 0, len(values))
+	for value := range values {
+		sorted = append(sorted, value)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if len(sorted[i]) != len(sorted[j]) {
+			return len(sorted[i]) > len(sorted[j])
+		}
+		return sorted[i] < sorted[j]
+	})
+	pairs := make([]string, 0, len(sorted)*2)
+	for _, value := range sorted {
+		pairs = append(pairs, value, Mask)
+	}
+	replacer = strings.NewReplacer(pairs...)
+}
+
+// variants returns the forms that a value can take when it is written out.
+// JSON output escapes some characters and YAML output splits multi line
+// values over several lines.
+func variants(value string) []string {
+	found := []string{value}
+	if encoded, err := json.Marshal(value); err == nil {
+		found = append(found, strings.Trim(string(encoded), `"`))
+	}
+	if strings.Contains(value, "\n") {
+		found = append(found, strings.Split(value, "\n")...)
+	}
+	return found
+}
+
+// Scrub replaces the remembered values in the text.
+func Scrub(text string) string {
+	lock.RLock()
+	defer lock.RUnlock()
+	if len(values) == 0 {
+		return text
+	}
+	return replacer.Replace(text)
+}
+
+// Forget removes all the remembered values.
+func Forget() {
+	lock.Lock()
+	defer lock.Unlock()
+	values = map[string]bool{}
+	replacer = strings.NewReplacer()
+}
@@ -0,0 +1,45 @@
+package secrets
+
+import "testing"
+
+func TestScrub(t *testing.T) {
+	defer Forget()
+	if got := Scrub("nothing to hide"); got != "nothing to hide" {
+		t.Logf("Text should not change without secrets. Got: %s", got)
+		t.Fail()
+	}
+
+	Remember("hunter2", "hunter2-extra", "no")
+	tests := map[string]string{
+		"password=hunter2":        "password=" + Mask,
+		"token hunter2-extra end": "token " + Mask + " end",
+		"no short values":         "no short values",
+	}
+	for text, want := range tests {
+		if got := Scrub(text); got != want {
+			t.Logf("Text is not scrubbed as expected. Want: %s, Got: %s", want, got)
+			t.Fail()
+		}
+	}
+
+	Forget()
+	if got := Scrub("password=hunter2"); got != "password=hunter2" {
+		t.Logf("Secrets should be forgotten. Got: %s", got)
+		t.Fail()
+	}
+}
+
+func TestScrubVariants(t *testing.T) {
+	defer Forget()
+	Remember("pa\"ss\nword-line")
+	tests := map[string]string{
+		`{"value":"pa\"ss\nword-line"}`:    `{"value":"` + Mask + `"}`,
+		"value: |-\n  pa\"ss\n  word-line": "value: |-\n  " + Mask + "\n  " + Mask,
+	}
+	for text, want := range tests {
+		if got := Scrub(text); got != want {
+			t.Logf("Text is not scrubbed as expected. Want: %q, Got: %q", want, got)
+			t.Fail()
+		}
+	}
+}
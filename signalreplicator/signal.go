@@ -66,7 +66,15 @@ func (r *replicator) remove(ch chan os.Signal) {
 
 func (r *replicator) listen() {
 	for s := range r.input {
+		// Copy the channels so that they can be removed while the signal is
+		// being sent.
+		r.RLock()
+		channels := make([]chan os.Signal, 0, len(r.signalChannels))
 		for _, procChan := range r.signalChannels {
+			channels = append(channels, procChan)
+		}
+		r.RUnlock()
+		for _, procChan := range channels {
 			procChan <- s
 		}
 	}